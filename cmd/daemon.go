@@ -0,0 +1,167 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"tryffel.net/go/meilindex/config"
+	"tryffel.net/go/meilindex/external"
+	"tryffel.net/go/meilindex/indexer"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Keep indexing new mails",
+	Long: `Run continuously and index new mails as they arrive.
+
+Imap folders are watched with IDLE. New mails are fetched when daemon starts and
+whenever server reports changes.
+Mbox files and isync (maildir) directories are watched for file changes. Files are read when daemon starts,
+and only mails that are added or changed after that are indexed. Run 'meilindex index' first to index
+existing mails. Mailspring is not supported.
+
+All accounts from config.accounts are watched, or with no accounts, the default imap account.
+Daemon stops on SIGINT or SIGTERM.
+
+Examples:
+* meilindex daemon
+* meilindex daemon --account work
+* meilindex daemon --all
+`,
+	Args: cobra.NoArgs,
+	Run:  runDaemon,
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().String("account", "", "Only watch account from config.accounts")
+	daemonCmd.Flags().Bool("all", false, "Watch all imap folders, filtered with imap.include and imap.exclude")
+}
+
+func runDaemon(cmd *cobra.Command, args []string) {
+	accounts := config.Conf.Accounts
+	if name, _ := cmd.Flags().GetString("account"); name != "" {
+		account := config.Conf.Account(name)
+		if account == nil {
+			fmt.Printf("no such account: %s\n", name)
+			return
+		}
+		accounts = []config.Account{*account}
+	} else if len(accounts) == 0 {
+		accounts = []config.Account{{
+			Name: config.DefaultAccount,
+			Type: config.AccountImap,
+			Imap: config.Conf.Imap,
+		}}
+	}
+
+	meili, err := indexer.NewMeiliSearch()
+	if err != nil {
+		logrus.Errorf("Connect to meilisearch: %v", err)
+		return
+	}
+
+	// push synchronously, so that imap sync state is only saved after mails have been indexed.
+	watcher := indexer.NewWatcher(meili.IndexMail)
+	allFolders, _ := cmd.Flags().GetBool("all")
+	// accounts may share the same sync state file.
+	states := map[string]*indexer.ImapSyncState{}
+
+	for _, account := range accounts {
+		log := logrus.WithFields(logrus.Fields{"account": account.Name, "type": account.Type})
+		var err error
+		switch account.Type {
+		case config.AccountImap:
+			state, ok := states[account.Imap.SyncStateFile]
+			if !ok {
+				state, err = indexer.LoadImapSyncState(account.Imap.SyncStateFile)
+				if err != nil {
+					break
+				}
+				states[account.Imap.SyncStateFile] = state
+			}
+			err = watchImap(watcher, account, state, allFolders)
+		case config.AccountMbox:
+			var files []external.MboxFile
+			if account.File.Recursive {
+				files, err = external.MboxFiles(account.File.Directory, true)
+			} else {
+				name, _ := filepath.Abs(account.File.Directory)
+				files = []external.MboxFile{{File: account.File.Directory, Name: name}}
+			}
+			if err == nil {
+				err = watcher.WatchMbox(account.Name, files)
+			}
+		case config.AccountIsync:
+			err = watcher.WatchMaildir(account.Name, account.File.Directory)
+		default:
+			log.Warning("Account type is not supported in daemon mode")
+			continue
+		}
+		if err != nil {
+			log.Errorf("Watch account: %v", err)
+		}
+	}
+
+	logrus.Info("Daemon started")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logrus.WithField("signal", sig.String()).Info("Stopping daemon")
+
+	watcher.Stop()
+	meili.WaitIndexComplete()
+	logrus.Info("Daemon stopped")
+}
+
+// watchImap starts watching imap folders of account.
+func watchImap(watcher *indexer.Watcher, account config.Account, state *indexer.ImapSyncState, all bool) error {
+	folders := []string{account.Imap.Folder}
+	if all {
+		client := newImapClient(account)
+		err := client.Connect()
+		if err != nil {
+			return err
+		}
+		mailboxes, err := client.Mailboxes()
+		client.Disconnect()
+		if err != nil {
+			return err
+		}
+		folders = indexer.FilterMailboxes(mailboxes, account.Imap.Include, account.Imap.Exclude)
+	}
+
+	// each folder needs its own connection, since IDLE only reports changes in selected folder.
+	for _, folder := range folders {
+		watcher.WatchImap(func() *indexer.Imap {
+			return newImapClient(account)
+		}, folder, state)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"tryffel.net/go/meilindex/config"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Print or clear search history",
+	Long: `Print searches executed in terminal gui, oldest first. History is stored in file set with
+'search.history_file'.
+
+Examples:
+* meilindex history
+* meilindex history --limit 10
+* meilindex history --clear
+`,
+	Args: cobra.NoArgs,
+	Run:  history,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().Bool("clear", false, "Clear history")
+	historyCmd.Flags().Int("limit", 0, "Only print latest searches")
+}
+
+func history(cmd *cobra.Command, args []string) {
+	history, err := config.LoadHistory(config.Conf.Search.HistoryFile, config.Conf.Search.HistorySize)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if clear, _ := cmd.Flags().GetBool("clear"); clear {
+		history.Clear()
+		err = history.Save()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("History cleared")
+		return
+	}
+
+	entries := history.Entries
+	if limit, _ := cmd.Flags().GetInt("limit"); limit > 0 && limit < len(entries) {
+		entries = entries[len(entries)-limit:]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tQUERY\tFILTER")
+	for _, v := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Time.Local().Format("2006-01-02 15:04"), v.Query, v.Filter)
+	}
+	w.Flush()
+}
@@ -23,7 +23,6 @@ package cmd
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 	"tryffel.net/go/meilindex/config"
 	"tryffel.net/go/meilindex/indexer"
 
@@ -32,26 +31,35 @@ import (
 
 // indexCmd represents the index command
 var indexCmd = &cobra.Command{
-	Use:   "index imap|file|dir",
+	Use:   "index imap|file|dir|mailspring|isync",
 	Short: "Index mails",
 	Long: `Index mails from imap or file(s).
 	
 default imap / file / dir configuration is gathered from config file.
 'dir' indexes config.file.directory 
+With --account or --all-accounts, accounts are read from config.accounts and no location is needed.
 
 Examples:
 * meilindex index imap 
 * meilindex index imap --folder Archive/All
+* meilindex index imap --all
 * meilindex index file ~/.thunderbird/my-profile/ImapMail/host/Inbox
 * meilindex index dir
 * meilindex index dir ~/.thunderbird/my-profile/ImapMail/host
+* meilindex index --account work
+* meilindex index --all-accounts
 `,
 	Args: func(cmd *cobra.Command, args []string) error {
+		account, _ := cmd.Flags().GetString("account")
+		allAccounts, _ := cmd.Flags().GetBool("all-accounts")
+		if account != "" || allAccounts {
+			return nil
+		}
 		if len(args) < 1 {
 			return fmt.Errorf("expected at least 1 argument")
 		}
 		if args[0] != "imap" && args[0] != "file" && args[0] != "dir" && args[0] != "mailspring" && args[0] != "isync" {
-			return fmt.Errorf("expect location either 'imap', 'file', 'dir' or 'mailspring'")
+			return fmt.Errorf("expect location either 'imap', 'file', 'dir', 'isync' or 'mailspring'")
 		}
 		return nil
 	},
@@ -61,127 +69,238 @@ func init() {
 	rootCmd.AddCommand(indexCmd)
 
 	indexCmd.Flags().String("folder", "INBOX", "Imap folder to index")
+	indexCmd.Flags().Bool("full", false, "Reindex all imap mails instead of only new mails")
+	indexCmd.Flags().Bool("all", false, "Index all imap folders, filtered with imap.include and imap.exclude")
+	indexCmd.Flags().Bool("prune", false, "Remove mails from index that no longer exist in indexed folders")
+	indexCmd.Flags().Bool("dry-run", false, "With --prune, only list mails that would be removed")
+	indexCmd.Flags().String("account", "", "Index account from config.accounts")
+	indexCmd.Flags().Bool("all-accounts", false, "Index all accounts from config.accounts")
 	indexCmd.Run = indexMail
 }
 
 func indexMail(cmd *cobra.Command, args []string) {
-	var mails []*indexer.Mail
-	var err error
+	accounts, err := indexAccounts(args)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	meili, err := indexer.NewMeiliSearch()
 	if err != nil {
 		logrus.Errorf("Connect to meilisearch: %v", err)
 		return
 	}
 
-	if args[0] == "file" {
-		var file string
-		var err error
-		if len(args) >= 2 {
-			file = args[1]
-		} else {
-			file, err = indexCmd.Flags().GetString("file")
+	var seen *indexer.SeenMails
+	prune, _ := indexCmd.Flags().GetBool("prune")
+	dryRun, _ := indexCmd.Flags().GetBool("dry-run")
+	if prune || dryRun {
+		seen = indexer.NewSeenMails()
+	}
+
+	for _, account := range accounts {
+		if len(accounts) > 1 {
+			fmt.Printf("Index account %s\n", account.Name)
 		}
-		mails, err = indexer.ReadFiles(file, false, meili.IndexMailBackground)
+		err = indexAccount(meili, account, seen)
 		if err != nil {
-			logrus.Error(err)
+			logrus.Errorf("Index account %s: %v", account.Name, err)
 		}
-		meili.WaitIndexComplete()
-	} else if args[0] == "dir" {
-		recursive := true
+	}
 
-		var dir string
-		var err error
-		if len(args) >= 2 {
-			dir = args[1]
-		} else {
-			dir, err = indexCmd.Flags().GetString("dir")
+	if seen != nil {
+		pruneMails(meili, seen, dryRun)
+	}
+}
+
+// indexAccounts returns accounts to index. Accounts are either selected with flags from config, or
+// built from command line location and global config.
+func indexAccounts(args []string) ([]config.Account, error) {
+	if all, _ := indexCmd.Flags().GetBool("all-accounts"); all {
+		if len(config.Conf.Accounts) == 0 {
+			return nil, fmt.Errorf("no accounts configured")
 		}
+		return config.Conf.Accounts, nil
+	}
 
-		if dir == "" {
-			dir = viper.GetString("file.directory")
-			recursive = viper.GetBool("file.recursive")
+	if name, _ := indexCmd.Flags().GetString("account"); name != "" {
+		account := config.Conf.Account(name)
+		if account == nil {
+			return nil, fmt.Errorf("no such account: %s", name)
 		}
+		return []config.Account{*account}, nil
+	}
 
-		mails, err = indexer.ReadFiles(dir, recursive, meili.IndexMailBackground)
-		if err != nil {
-			fmt.Println(err)
-			//return
+	account := config.Account{
+		Name: config.DefaultAccount,
+		Imap: config.Conf.Imap,
+		File: config.Conf.File,
+	}
+
+	location := ""
+	if len(args) >= 2 {
+		location = args[1]
+	}
+
+	switch args[0] {
+	case "file":
+		account.Type = config.AccountMbox
+		account.File.Directory = location
+		account.File.Recursive = false
+	case "dir":
+		account.Type = config.AccountMbox
+		if location != "" {
+			account.File.Directory = location
+			account.File.Recursive = true
 		}
-		meili.WaitIndexComplete()
-	} else if args[0] == "mailspring" {
-		var file string
-		var err error
-		if len(args) >= 2 {
-			file = args[1]
-		} else {
-			file = config.Conf.File.Directory
+	case "mailspring":
+		account.Type = config.AccountMailspring
+		if location != "" {
+			account.File.Directory = location
 		}
-
-		_, err = indexer.ReadMailspring(file, false, meili.IndexMailBackground)
-		if err != nil {
-			logrus.Error(err)
+	case "isync":
+		account.Type = config.AccountIsync
+		if location != "" {
+			account.File.Directory = location
 		}
-		meili.WaitIndexComplete()
-	} else if args[0] == "isync" {
+	default:
+		account.Type = config.AccountImap
+	}
+	return []config.Account{account}, nil
+}
 
-		var dir string
-		var err error
-		if len(args) >= 2 {
-			dir = args[1]
-		} else {
-			dir, err = indexCmd.Flags().GetString("dir")
+// indexAccount indexes single account. If seen is not nil, all mails that exist in source are added to it.
+func indexAccount(meili *indexer.Meilisearch, account config.Account, seen *indexer.SeenMails) error {
+	// account must be set before mails are recorded to seen, since file sources do not set it.
+	flushFunc := meili.IndexMailBackground
+	if seen != nil {
+		flushFunc = seen.Wrap(flushFunc)
+	}
+	flushFunc = indexer.WithAccount(account.Name, flushFunc)
+	// folders are pruned only after they have been read completely.
+	var doneFunc func(folder string)
+	if seen != nil {
+		doneFunc = func(folder string) {
+			seen.CompleteFolder(account.Name, folder)
 		}
+	}
 
-		if dir == "" {
-			dir = viper.GetString("file.directory")
+	var err error
+	switch account.Type {
+	case config.AccountMbox:
+		_, err = indexer.ReadFiles(account.File.Directory, account.File.Recursive, flushFunc, doneFunc)
+		meili.WaitIndexComplete()
+	case config.AccountMailspring:
+		_, err = indexer.ReadMailspring(account.File.Directory, false, flushFunc, doneFunc)
+		meili.WaitIndexComplete()
+	case config.AccountIsync:
+		err = indexer.ReadVerbatimDir(account.File.Directory, flushFunc, doneFunc)
+		meili.WaitIndexComplete()
+	case config.AccountImap:
+		var state *indexer.ImapSyncState
+		state, err = indexer.LoadImapSyncState(account.Imap.SyncStateFile)
+		if err != nil {
+			return err
 		}
 
-		err = indexer.ReadVerbatimDir(dir, meili.IndexMailBackground)
+		// imap mails are recorded separately, since only new mails are fetched.
+		// Mails are pushed synchronously, so that sync state only advances after mails have been indexed.
+		var total int
+		total, err = retrieveImap(account, state, seen, meili.IndexMail)
 		if err != nil {
-			fmt.Println(err)
-			//return
+			return fmt.Errorf("index from imap: %v", err)
 		}
 		meili.WaitIndexComplete()
-	} else {
-		mails, err = retrieveImap()
-		if err != nil {
-			fmt.Printf("Error indexing from imap: %v\n", err)
-			return
-		}
-		err = meili.IndexMail(mails)
-		if err != nil {
-			fmt.Printf("Error pushing mails to meilisearch: %v\n", err)
-			return
+		if total == 0 {
+			fmt.Println("No new mails")
 		}
+		err = state.Save()
+	default:
+		err = fmt.Errorf("unknown account type '%s'", account.Type)
 	}
+	return err
 }
 
-func retrieveImap() ([]*indexer.Mail, error) {
-	client := &indexer.Imap{
-		Url:                 config.Conf.Imap.Url,
-		Tls:                 config.Conf.Imap.Tls,
-		TlsSkipVerification: config.Conf.Imap.SkipVerification,
-		Username:            config.Conf.Imap.Username,
-		Password:            config.Conf.Imap.Password,
+// pruneMails removes mails from index that were not seen during indexing.
+func pruneMails(meili *indexer.Meilisearch, seen *indexer.SeenMails, dryRun bool) {
+	removed, err := meili.Prune(seen, dryRun)
+	if err != nil {
+		logrus.Errorf("Prune mails: %v", err)
+		return
 	}
+
+	if dryRun {
+		for _, mail := range removed {
+			fmt.Printf("%s\t%s\t%s\t%s\n", mail.Date(), mail.Account, mail.Folder, mail.Subject)
+		}
+		fmt.Printf("Would remove %d mails from %d folders\n", len(removed), len(seen.Folders()))
+	} else {
+		fmt.Printf("Removed %d mails from %d folders\n", len(removed), len(seen.Folders()))
+	}
+}
+
+// retrieveImap fetches new mails from imap. If seen is not nil, ids of all mails in indexed folders are added to it.
+func retrieveImap(account config.Account, state *indexer.ImapSyncState, seen *indexer.SeenMails,
+	flushFunc func(mails []*indexer.Mail) error) (int, error) {
+	client := newImapClient(account)
 	var err error
 	err = client.Connect()
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
 	defer client.Disconnect()
 
-	folder := config.Conf.Imap.Folder
-	if f, err := indexCmd.Flags().GetString("folder"); f != "INBOX" && err != nil {
-		folder = f
+	folders := []string{account.Imap.Folder}
+	if indexCmd.Flags().Changed("folder") {
+		folder, _ := indexCmd.Flags().GetString("folder")
+		folders = []string{folder}
+	} else if all, _ := indexCmd.Flags().GetBool("all"); all {
+		mailboxes, err := client.Mailboxes()
+		if err != nil {
+			return 0, err
+		}
+		folders = indexer.FilterMailboxes(mailboxes, account.Imap.Include, account.Imap.Exclude)
+		fmt.Printf("Index %d / %d imap folders\n", len(folders), len(mailboxes))
 	}
 
-	fmt.Printf("Index imap folder %s\n", folder)
-	err = client.SelectMailbox(folder)
-	if err != nil {
-		return nil, fmt.Errorf("select folder: %v", err)
+	if full, _ := indexCmd.Flags().GetBool("full"); full {
+		state.Reset(account.Name)
+	}
+
+	total := 0
+	for _, folder := range folders {
+		fmt.Printf("Index imap folder %s\n", folder)
+		err = client.SelectMailbox(folder)
+		if err != nil {
+			logrus.Errorf("select folder %s: %v", folder, err)
+			continue
+		}
+		fetched, err := client.FetchMail(state.Folder(account.Name, folder), flushFunc)
+		total += fetched
+		if err != nil {
+			logrus.Errorf("fetch folder %s: %v", folder, err)
+			continue
+		}
+		if seen != nil {
+			err = client.CollectMailIds(seen)
+			if err != nil {
+				logrus.Errorf("list mails in folder %s: %v", folder, err)
+			}
+		}
+	}
+	return total, nil
+}
+
+// newImapClient returns imap client for account.
+func newImapClient(account config.Account) *indexer.Imap {
+	return &indexer.Imap{
+		Url:                 account.Imap.Url,
+		Tls:                 account.Imap.Tls,
+		TlsSkipVerification: account.Imap.SkipVerification,
+		Username:            account.Imap.Username,
+		Password:            account.Imap.Password,
+		BatchSize:           account.Imap.BatchSize,
+		Account:             account.Name,
 	}
-	mails, err := client.FetchMail()
-	return mails, err
 }
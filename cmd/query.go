@@ -21,8 +21,14 @@
 package cmd
 
 import (
+	"fmt"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"io"
+	"os"
 	"strings"
+	"time"
+	"tryffel.net/go/meilindex/config"
 	"tryffel.net/go/meilindex/indexer"
 )
 
@@ -35,6 +41,28 @@ var queryCmd = &cobra.Command{
 1. 'meilindex query my mail' => match 'my mail'
 2. 'meilindex query --folder inbox --subject "item received" my mail' => match 'my mail' in folder 'inbox' and subject '
 item received'
+3. 'meilindex query --account work my mail' => match 'my mail' in account 'work'
+4. 'meilindex query --filter "flagged=true AND seen=false" my mail' => match 'my mail' in flagged, unread mails
+5. 'meilindex query --format mbox --output result.mbox my mail' => export results to mbox file
+6. 'meilindex query --format csv --columns date,from_address,subject my mail' => print results as csv
+7. 'meilindex query --limit 20 --offset 40 my mail' => show results 41-60
+8. 'meilindex query --all --format jsonl my mail' => print all results as json lines
+9. 'meilindex query --sort date:desc my mail' => show newest mails first
+10. 'meilindex query from:alice@example.com has:attachment -is:unread after:-7d report' => inline operators
+11. 'meilindex query --saved invoices --format csv' => run saved search 'invoices'
+
+Query can contain gmail-style operators: from:, to:, cc:, subject:, folder:, account:, has:attachment,
+is:unread (read, flagged, answered, draft), before: and after:. Prefix operator with '-' to negate it.
+Values with spaces must be quoted, e.g. subject:"weekly report". Addresses (from:alice@example.com),
+folder: and account: match exactly. Names (from:alice) and subject: are searched as text, and negated
+-subject: or -from:alice excludes only exact values.
+
+Supported formats: text (default), json, jsonl, csv, mbox and eml. Eml writes each mail to its own file
+in directory given with --output. Other formats are written to stdout, if output is not set.
+Mbox and eml contain original messages, read from mbox files and maildirs or fetched from imap servers.
+If original message cannot be read, e.g. it has been deleted or mail was indexed from mailspring, message
+is reconstructed from indexed fields with main headers and plain text body but no attachments, and a
+warning is logged.
 `,
 }
 
@@ -42,9 +70,20 @@ func init() {
 	rootCmd.AddCommand(queryCmd)
 
 	queryCmd.Flags().String("folder", "", "Folder to limit search to")
-	queryCmd.Flags().String("from", "", "From sender (must match exactly)")
-	queryCmd.Flags().String("to", "", "To receiver (must match exactly)")
+	queryCmd.Flags().String("account", "", "Account to limit search to")
+	queryCmd.Flags().String("from", "", "From sender name or address (must match exactly)")
+	queryCmd.Flags().String("to", "", "To receiver name or address (must match exactly)")
 	queryCmd.Flags().String("subject", "", "Subject (must match exactly)")
+	queryCmd.Flags().String("filter", "", "Additional filter, e.g. 'flagged=true AND folder=inbox'")
+	queryCmd.Flags().String("format", indexer.FormatText, "Output format: "+strings.Join(indexer.ExportFormats, ", "))
+	queryCmd.Flags().StringP("output", "o", "", "Output file, or directory for eml")
+	queryCmd.Flags().StringSlice("columns", nil, "Csv columns, default is export.columns from config. "+
+		"Supported columns: "+strings.Join(indexer.ExportColumns, ", "))
+	queryCmd.Flags().Int("limit", 100, "Max number of mails to return, or page size with --all")
+	queryCmd.Flags().Int("offset", 0, "Number of mails to skip")
+	queryCmd.Flags().Bool("all", false, "Return all matching mails, fetching them one page at a time")
+	queryCmd.Flags().String("sort", indexer.SortRelevance, "Sort order: "+strings.Join(indexer.SortOrders, ", "))
+	queryCmd.Flags().String("saved", "", "Run saved search, see 'meilindex saved'. Query and filters are added to it")
 
 	queryCmd.Run = query
 }
@@ -53,12 +92,176 @@ func query(cmd *cobra.Command, args []string) {
 	q := strings.Join(args, " ")
 
 	filter := ""
+	filters := []string{}
+
+	if name, _ := queryCmd.Flags().GetString("saved"); name != "" {
+		searches, err := config.LoadSavedSearches(config.Conf.Search.SavedFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		saved := searches.Get(name)
+		if saved == nil {
+			fmt.Printf("no such saved search: %s\n", name)
+			return
+		}
+		q = strings.TrimSpace(saved.Query + " " + q)
+		filters = append(filters, saved.Filter)
+	}
 
 	folder, err := queryCmd.Flags().GetString("folder")
 	if err == nil && folder != "" {
 		filter += "folder=" + folder
 	}
 
-	indexer.SearchMail(q, filter)
+	account, err := queryCmd.Flags().GetString("account")
+	if err == nil && account != "" {
+		if filter != "" {
+			filter += " AND "
+		}
+		filter += fmt.Sprintf("account=\"%s\"", account)
+	}
+
+	for _, field := range []string{"from", "to"} {
+		value, err := queryCmd.Flags().GetString(field)
+		if err != nil || value == "" {
+			continue
+		}
+		if filter != "" {
+			filter += " AND "
+		}
+		// match either exact address or display name
+		if strings.Contains(value, "@") {
+			filter += fmt.Sprintf("%s_address=\"%s\"", field, strings.ToLower(value))
+		} else {
+			filter += fmt.Sprintf("%s=\"%s\"", field, value)
+		}
+	}
+
+	extra, _ := queryCmd.Flags().GetString("filter")
+	filters = append(filters, extra)
+	for _, extra := range filters {
+		if extra == "" {
+			continue
+		}
+		f, err := indexer.NewFilter(extra)
+		if err != nil {
+			if filterErr, ok := err.(*indexer.FilterError); ok {
+				fmt.Printf("Invalid filter: %s\n%s\n", filterErr.Message, filterErr.Caret())
+			} else {
+				fmt.Printf("Invalid filter: %v\n", err)
+			}
+			return
+		}
+		if f.Query() != "" {
+			if filter != "" {
+				filter += " AND "
+			}
+			filter += "(" + f.Query() + ")"
+		}
+	}
+
+	parsed, err := indexer.ParseQuery(q, time.Now())
+	if err != nil {
+		if filterErr, ok := err.(*indexer.FilterError); ok {
+			fmt.Printf("Invalid query: %s\n%s\n", filterErr.Message, filterErr.Caret())
+		} else {
+			fmt.Printf("Invalid query: %v\n", err)
+		}
+		return
+	}
+
+	err = searchMails(parsed.Text, indexer.CombineFilters(filter, parsed.Filter))
+	if err != nil {
+		logrus.Error(err)
+	}
+}
+
+// searchMails prints or exports search results in given format to --output or stdout.
+func searchMails(query, filter string) error {
+	format, _ := queryCmd.Flags().GetString("format")
+	output, _ := queryCmd.Flags().GetString("output")
+	columns, _ := queryCmd.Flags().GetStringSlice("columns")
+	if len(columns) == 0 {
+		columns = config.Conf.Export.Columns
+	}
+	opts := indexer.SearchOptions{Highlight: format == indexer.FormatText}
+	opts.Limit, _ = queryCmd.Flags().GetInt("limit")
+	opts.Offset, _ = queryCmd.Flags().GetInt("offset")
+	opts.Sort, _ = queryCmd.Flags().GetString("sort")
+	if err := indexer.ValidateSort(opts.Sort); err != nil {
+		return err
+	}
+	all, _ := queryCmd.Flags().GetBool("all")
+	if opts.Limit <= 0 {
+		return fmt.Errorf("limit must be positive")
+	}
+	// validate everything before output file is created, so that an existing file is not truncated.
+	if err := indexer.ValidateExport(format, columns); err != nil {
+		return err
+	}
+	if format == indexer.FormatEml && output == "" {
+		return fmt.Errorf("eml format requires output directory")
+	}
+
+	meili, err := indexer.NewMeiliSearch()
+	if err != nil {
+		return fmt.Errorf("connect to meilisearch: %v", err)
+	}
 
+	// mbox and eml contain original messages, if they can be read from source.
+	var raw indexer.RawFunc
+	if format == indexer.FormatMbox || format == indexer.FormatEml {
+		reader := newRawReader()
+		defer reader.Close()
+		raw = reader.Read
+	}
+
+	var exporter indexer.Exporter
+	if format == indexer.FormatEml {
+		exporter, err = indexer.NewEmlExporter(output, raw)
+	} else {
+		var w io.Writer = os.Stdout
+		if output != "" {
+			file, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			w = file
+		}
+		exporter, err = indexer.NewExporter(w, format, columns, raw)
+	}
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	if all {
+		// limit is the page size
+		count, err = meili.SearchAll(query, filter, opts, exporter.Export)
+	} else {
+		var res *indexer.SearchResult
+		res, err = meili.Search(query, filter, opts)
+		if err == nil {
+			count = len(res.Mails)
+			if res.Truncated {
+				logrus.Warningf("Only first %d mails by relevance are sorted by date, refine query to get the rest",
+					res.TotalHits)
+			}
+			if res.TotalHits > opts.Offset+count {
+				logrus.Infof("Showing mails %d-%d of about %d, use --offset or --all to get more",
+					opts.Offset+1, opts.Offset+count, res.TotalHits)
+			}
+			err = exporter.Export(res.Mails)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("search: %v", err)
+	}
+	err = exporter.Close()
+	if err == nil && output != "" {
+		logrus.Infof("Exported %d mails to %s", count, output)
+	}
+	return err
 }
@@ -25,6 +25,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 	"tryffel.net/go/meilindex/config"
@@ -45,7 +46,7 @@ var rootCmd = &cobra.Command{
 Index emails from IMAP server or from local mail files. Running 'meilindex' opens gui for viewing indexed emails.
 Licensed under AGPLv3`,
 	Run: func(cmd *cobra.Command, args []string) {
-		w := widgets.NewWindow()
+		w := widgets.NewWindow(readRaw)
 		w.Run()
 
 	},
@@ -69,17 +70,17 @@ func init() {
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
+	// Find home directory.
+	home, err := homedir.Dir()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	if cfgFile != "" {
 		// Use config file from the flag.
 		viper.SetConfigFile(cfgFile)
 	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
 		// Search config in home directory with name ".meilindex" (without extension).
 		viper.SetConfigType("yaml")
 		viper.AddConfigPath(home)
@@ -92,6 +93,10 @@ func initConfig() {
 	viper.SetDefault("imap.username", "me@mymail.com")
 	viper.SetDefault("imap.password", "memailing")
 	viper.SetDefault("imap.folder", "INBOX")
+	viper.SetDefault("imap.batch_size", 500)
+	viper.SetDefault("imap.include", []string{})
+	viper.SetDefault("imap.exclude", []string{})
+	viper.SetDefault("imap.sync_state_file", filepath.Join(home, ".meilindex-imap.json"))
 
 	viper.SetDefault("file.directory", "/home/me/.mails")
 	viper.SetDefault("file.recursive", "false")
@@ -104,6 +109,28 @@ func initConfig() {
 
 	viper.SetDefault("gui.mouse", false)
 
+	viper.SetDefault("attachments.types", []string{"text", "pdf", "docx", "xlsx", "odt", "calendar", "vcard"})
+	viper.SetDefault("attachments.max_size", 10*1024*1024)
+	viper.SetDefault("attachments.max_text_size", 100*1024)
+	viper.SetDefault("attachments.cache", false)
+	viper.SetDefault("attachments.cache_dir", filepath.Join(home, ".cache", "meilindex", "attachments"))
+	viper.SetDefault("attachments.save_dir", filepath.Join(home, "Downloads"))
+
+	viper.SetDefault("search.saved_file", filepath.Join(home, ".meilindex-searches.json"))
+	viper.SetDefault("search.history_file", filepath.Join(home, ".meilindex-history.json"))
+	viper.SetDefault("search.history_size", 500)
+
+	viper.SetDefault("open.client", "thunderbird")
+	viper.SetDefault("open.command", "")
+	viper.SetDefault("open.terminal", false)
+
+	viper.SetDefault("compose.handler", config.ComposeMailto)
+	viper.SetDefault("compose.editor", "")
+	viper.SetDefault("compose.sendmail", "sendmail -t")
+	viper.SetDefault("compose.from", "")
+
+	viper.SetDefault("export.columns", []string{"date", "account", "folder", "from_address", "to_address", "subject"})
+
 	viper.SetEnvPrefix("meilindex")
 	viper.AutomaticEnv() // read in environment variables that match
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -129,6 +156,10 @@ func initConfig() {
 			Username:         viper.GetString("imap.username"),
 			Password:         viper.GetString("imap.password"),
 			Folder:           viper.GetString("imap.folder"),
+			BatchSize:        viper.GetInt("imap.batch_size"),
+			Include:          viper.GetStringSlice("imap.include"),
+			Exclude:          viper.GetStringSlice("imap.exclude"),
+			SyncStateFile:    viper.GetString("imap.sync_state_file"),
 		},
 		Meilisearch: config.Meilisearch{
 			Url:    viper.GetString("meilisearch.url"),
@@ -138,6 +169,47 @@ func initConfig() {
 		Gui: config.Gui{
 			Mouse: viper.GetBool("gui.mouse"),
 		},
+		Attachments: config.Attachments{
+			Types:       viper.GetStringSlice("attachments.types"),
+			MaxSize:     viper.GetInt("attachments.max_size"),
+			MaxTextSize: viper.GetInt("attachments.max_text_size"),
+			Cache:       viper.GetBool("attachments.cache"),
+			CacheDir:    viper.GetString("attachments.cache_dir"),
+			SaveDir:     viper.GetString("attachments.save_dir"),
+		},
+		Export: config.Export{
+			Columns: viper.GetStringSlice("export.columns"),
+		},
+		Search: config.Search{
+			SavedFile:   viper.GetString("search.saved_file"),
+			HistoryFile: viper.GetString("search.history_file"),
+			HistorySize: viper.GetInt("search.history_size"),
+		},
+		Open: config.Opener{
+			Client:   viper.GetString("open.client"),
+			Command:  viper.GetString("open.command"),
+			Terminal: viper.GetBool("open.terminal"),
+		},
+		Compose: config.Compose{
+			Handler:  viper.GetString("compose.handler"),
+			Editor:   viper.GetString("compose.editor"),
+			Sendmail: viper.GetString("compose.sendmail"),
+			From:     viper.GetString("compose.from"),
+		},
+	}
+
+	err = viper.UnmarshalKey("accounts", &config.Conf.Accounts)
+	if err != nil {
+		fmt.Printf("Error reading accounts: %v\n", err)
+	}
+	rawAccounts, _ := viper.Get("accounts").([]interface{})
+	for i := range config.Conf.Accounts {
+		account := &config.Conf.Accounts[i]
+		setAccountDefaults(account)
+		// tls is enabled unless account explicitly disables it, same as global imap.tls
+		if i < len(rawAccounts) && !isKeySet(rawAccounts[i], "imap", "tls") {
+			account.Imap.Tls = true
+		}
 	}
 
 	logrus.SetFormatter(&logrus.TextFormatter{
@@ -146,6 +218,36 @@ func initConfig() {
 	})
 }
 
+// setAccountDefaults fills account settings that are not set with global settings.
+func setAccountDefaults(account *config.Account) {
+	if account.Imap.Folder == "" {
+		account.Imap.Folder = "INBOX"
+	}
+	if account.Imap.BatchSize == 0 {
+		account.Imap.BatchSize = config.Conf.Imap.BatchSize
+	}
+	if account.Imap.SyncStateFile == "" {
+		account.Imap.SyncStateFile = config.Conf.Imap.SyncStateFile
+	}
+}
+
+// isKeySet returns true if nested keys exist in raw config value, e.g. in an item of 'accounts' list.
+func isKeySet(raw interface{}, keys ...string) bool {
+	for _, key := range keys {
+		var ok bool
+		switch value := raw.(type) {
+		case map[string]interface{}:
+			raw, ok = value[key]
+		case map[interface{}]interface{}:
+			raw, ok = value[key]
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
 func updateConfigFile() {
 
 	err := viper.WriteConfig()
@@ -0,0 +1,148 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"tryffel.net/go/meilindex/config"
+	"tryffel.net/go/meilindex/indexer"
+)
+
+// savedCmd represents the saved command
+var savedCmd = &cobra.Command{
+	Use:   "saved",
+	Short: "Manage saved searches",
+	Long: `Saved searches are named query and filter pairs. Run them with 'meilindex query --saved <name>',
+or select them from sidebar in terminal gui. Searches are stored in file set with 'search.saved_file'.
+
+Examples:
+* meilindex saved add invoices --filter "folder=inbox AND after=-1m" invoice
+* meilindex saved add alice from:alice@example.com has:attachment
+* meilindex saved list
+* meilindex saved rm invoices
+`,
+}
+
+var savedAddCmd = &cobra.Command{
+	Use:   "add <name> [query]",
+	Short: "Add or replace saved search",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   savedAdd,
+}
+
+var savedListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List saved searches",
+	Args:  cobra.NoArgs,
+	Run:   savedList,
+}
+
+var savedRmCmd = &cobra.Command{
+	Use:   "rm <name>",
+	Short: "Remove saved search",
+	Args:  cobra.ExactArgs(1),
+	Run:   savedRm,
+}
+
+func init() {
+	rootCmd.AddCommand(savedCmd)
+	savedCmd.AddCommand(savedAddCmd)
+	savedCmd.AddCommand(savedListCmd)
+	savedCmd.AddCommand(savedRmCmd)
+
+	savedAddCmd.Flags().String("filter", "", "Filter, e.g. 'flagged=true AND folder=inbox'")
+}
+
+func savedAdd(cmd *cobra.Command, args []string) {
+	search := config.SavedSearch{
+		Name:  args[0],
+		Query: strings.Join(args[1:], " "),
+	}
+	search.Filter, _ = cmd.Flags().GetString("filter")
+
+	// validate before saving
+	if _, err := indexer.ParseQuery(search.Query, time.Now()); err != nil {
+		fmt.Printf("Invalid query: %v\n", err)
+		return
+	}
+	if _, err := indexer.NewFilter(search.Filter); err != nil {
+		fmt.Printf("Invalid filter: %v\n", err)
+		return
+	}
+
+	searches, err := config.LoadSavedSearches(config.Conf.Search.SavedFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = searches.Add(search)
+	if err != nil {
+		fmt.Printf("Invalid search: %v\n", err)
+		return
+	}
+	err = searches.Save()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Saved search '%s'\n", search.Name)
+}
+
+func savedList(cmd *cobra.Command, args []string) {
+	searches, err := config.LoadSavedSearches(config.Conf.Search.SavedFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if len(searches.Searches) == 0 {
+		fmt.Println("No saved searches")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tQUERY\tFILTER")
+	for _, v := range searches.Searches {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, v.Query, v.Filter)
+	}
+	w.Flush()
+}
+
+func savedRm(cmd *cobra.Command, args []string) {
+	searches, err := config.LoadSavedSearches(config.Conf.Search.SavedFile)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if !searches.Remove(args[0]) {
+		fmt.Printf("no such saved search: %s\n", args[0])
+		return
+	}
+	err = searches.Save()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Removed search '%s'\n", args[0])
+}
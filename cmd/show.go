@@ -0,0 +1,164 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"tryffel.net/go/meilindex/config"
+	"tryffel.net/go/meilindex/indexer"
+)
+
+// showCmd represents the show command
+var showCmd = &cobra.Command{
+	Use:   "show <uid>",
+	Short: "Show single mail",
+	Long: `Show indexed mail and its original location. Uid is the document uid shown in json exports,
+or the message id of the mail.
+
+With --raw, the original message is read from its source and printed as is: mbox files and maildirs
+are read directly and imap messages are fetched from the server. Mailspring does not store raw messages.
+
+Examples:
+* meilindex show 5d41402abc4b2a76b9719d911017c592
+* meilindex show 5d41402abc4b2a76b9719d911017c592 --raw > message.eml
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		raw, _ := cmd.Flags().GetBool("raw")
+		return show(args[0], raw)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(showCmd)
+	showCmd.Flags().Bool("raw", false, "Print original message from source")
+}
+
+func show(uid string, raw bool) error {
+	meili, err := indexer.NewMeiliSearch()
+	if err != nil {
+		return fmt.Errorf("connect to meilisearch: %v", err)
+	}
+
+	mail, err := meili.Mail(uid)
+	if err != nil {
+		return err
+	}
+
+	if !raw {
+		exporter, err := indexer.NewExporter(os.Stdout, indexer.FormatText, nil, nil)
+		if err != nil {
+			return err
+		}
+		err = exporter.Export([]*indexer.Mail{mail})
+		if err != nil {
+			return err
+		}
+		if mail.Source != nil {
+			fmt.Printf("Source: %s\n", mail.Source.String())
+		} else {
+			fmt.Println("Source: not recorded, reindex mail")
+		}
+		return exporter.Close()
+	}
+
+	reader := newRawReader()
+	defer reader.Close()
+	data, err := reader.Read(mail.Source)
+	if err != nil {
+		return fmt.Errorf("read raw message: %v", err)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// readRaw reads original message from source. Imap server is connected only for this message.
+func readRaw(source *indexer.Source) ([]byte, error) {
+	reader := newRawReader()
+	defer reader.Close()
+	return reader.Read(source)
+}
+
+// rawReader reads original messages from their sources. Imap server of each account is connected on first
+// use, and connection is kept open until Close.
+type rawReader struct {
+	clients map[string]*indexer.Imap
+	// errors contains accounts that could not be connected.
+	errors map[string]error
+}
+
+func newRawReader() *rawReader {
+	return &rawReader{
+		clients: map[string]*indexer.Imap{},
+		errors:  map[string]error{},
+	}
+}
+
+// Read reads original message from source, connecting to imap server if needed.
+func (r *rawReader) Read(source *indexer.Source) ([]byte, error) {
+	if source == nil || source.Type != indexer.SourceImap {
+		return indexer.ReadRaw(source)
+	}
+	if err := r.errors[source.Account]; err != nil {
+		return nil, err
+	}
+	client := r.clients[source.Account]
+	if client == nil {
+		var err error
+		client, err = r.connect(source.Account)
+		if err != nil {
+			r.errors[source.Account] = err
+			return nil, err
+		}
+		r.clients[source.Account] = client
+	}
+	return client.FetchRaw(source)
+}
+
+func (r *rawReader) connect(name string) (*indexer.Imap, error) {
+	account := config.Conf.Account(name)
+	if account == nil && name == config.DefaultAccount {
+		account = &config.Account{Name: config.DefaultAccount, Type: config.AccountImap, Imap: config.Conf.Imap}
+		setAccountDefaults(account)
+	}
+	if account == nil {
+		return nil, fmt.Errorf("account '%s' not found in config", name)
+	}
+	client := newImapClient(*account)
+	err := client.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// Close disconnects all imap servers.
+func (r *rawReader) Close() {
+	for name, client := range r.clients {
+		if err := client.Disconnect(); err != nil {
+			logrus.Warningf("disconnect imap %s: %v", name, err)
+		}
+	}
+	r.clients = map[string]*indexer.Imap{}
+}
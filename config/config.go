@@ -20,6 +20,12 @@
 
 package config
 
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
 const Version = "v0.2.0"
 
 var Conf *Config
@@ -30,24 +36,123 @@ type Config struct {
 	Imap        Imap
 	Meilisearch Meilisearch
 	Gui         Gui
+	Accounts    []Account
+	Attachments Attachments
+	Export      Export
+	Search      Search
+	// Open is the default mail client for accounts without their own.
+	Open    Opener
+	Compose Compose
+}
+
+// Account returns account with given name, or nil if there's no such account.
+func (c *Config) Account(name string) *Account {
+	for i, v := range c.Accounts {
+		if v.Name == name {
+			return &c.Accounts[i]
+		}
+	}
+	return nil
+}
+
+// Opener returns mail client configuration for account. Accounts without configured client and
+// unknown accounts use the default client.
+func (c *Config) Opener(account string) Opener {
+	if a := c.Account(account); a != nil && a.Open.Client != "" {
+		return a.Open
+	}
+	return c.Open
+}
+
+// DefaultAccount is the account name used when indexing without configured accounts.
+const DefaultAccount = "default"
+
+const (
+	AccountImap       = "imap"
+	AccountMbox       = "mbox"
+	AccountIsync      = "isync"
+	AccountMailspring = "mailspring"
+)
+
+// Account is a named mail source. Depending on type, either Imap or File is used.
+type Account struct {
+	Name string `mapstructure:"name"`
+	// Type is one of imap, mbox, isync or mailspring.
+	Type string `mapstructure:"type"`
+	Imap Imap   `mapstructure:"imap"`
+	File File   `mapstructure:"file"`
+	// Open overrides default mail client for this account.
+	Open Opener `mapstructure:"open"`
+}
+
+// FolderPath returns location of folder for mail clients: mbox file, maildir directory or imap url.
+// Returns empty string if location is not known.
+func (a *Account) FolderPath(folder string) string {
+	switch a.Type {
+	case AccountMbox:
+		if filepath.IsAbs(folder) {
+			// mbox file that was indexed without recursion
+			return folder
+		}
+		// thunderbird stores subfolders in directory 'parent.sbd'
+		parts := strings.Split(folder, "/")
+		for i := range parts[:len(parts)-1] {
+			parts[i] += ".sbd"
+		}
+		return filepath.Join(append([]string{a.File.Directory}, parts...)...)
+	case AccountIsync:
+		// mails in root maildir are in folder external.MaildirInbox
+		if folder == "Inbox" {
+			return a.File.Directory
+		}
+		return filepath.Join(a.File.Directory, folder)
+	case AccountImap:
+		scheme := "imap"
+		if a.Imap.Tls {
+			scheme = "imaps"
+		}
+		u := url.URL{Scheme: scheme, Host: a.Imap.Url, Path: "/" + folder}
+		if a.Imap.Username != "" {
+			u.User = url.User(a.Imap.Username)
+		}
+		return u.String()
+	}
+	return ""
+}
+
+// Opener configures mail client that mails are opened with.
+type Opener struct {
+	// Client is one of thunderbird, mutt, neomutt, aerc, mailspring, evolution or command.
+	Client string `mapstructure:"client"`
+	// Command is the command template for client 'command'. Placeholders {id}, {folder} and {path}
+	// are replaced with Message-ID, folder name and folder location.
+	Command string `mapstructure:"command"`
+	// Terminal is true if command runs in terminal.
+	Terminal bool `mapstructure:"terminal"`
 }
 
 // File is email locating on filesystem
 type File struct {
-	Directory string
-	Recursive bool
-	Mode      string
-	BatchSize int
+	Directory string `mapstructure:"directory"`
+	Recursive bool   `mapstructure:"recursive"`
+	Mode      string `mapstructure:"mode"`
+	BatchSize int    `mapstructure:"batch_size"`
 }
 
 // Imap is imap-based email source
 type Imap struct {
-	Url              string
-	Tls              bool
-	SkipVerification bool
-	Username         string
-	Password         string
-	Folder           string
+	Url              string `mapstructure:"url"`
+	Tls              bool   `mapstructure:"tls"`
+	SkipVerification bool   `mapstructure:"skip_tls_verification"`
+	Username         string `mapstructure:"username"`
+	Password         string `mapstructure:"password"`
+	Folder           string `mapstructure:"folder"`
+	BatchSize        int    `mapstructure:"batch_size"`
+	// Include and Exclude are folder name patterns used when indexing all folders.
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+	// SyncStateFile stores last indexed uids for incremental indexing.
+	SyncStateFile string `mapstructure:"sync_state_file"`
 }
 
 // Meilisearch contains meilisearch-instance configuration
@@ -57,6 +162,56 @@ type Meilisearch struct {
 	ApiKey string
 }
 
+// Attachments configures extracting text from attachments and caching them.
+type Attachments struct {
+	// Types are enabled extractors: text, pdf, docx, xlsx, odt, calendar, vcard.
+	Types []string
+	// MaxSize is max attachment size in bytes to extract text from. 0 is unlimited.
+	MaxSize int
+	// MaxTextSize is max length of extracted text per mail. 0 is unlimited.
+	MaxTextSize int
+	// Cache stores attachments to CacheDir when indexing, so that they can be opened and saved later.
+	Cache    bool
+	CacheDir string
+	// SaveDir is the directory where attachments are saved from gui.
+	SaveDir string
+}
+
+// Export configures exporting search results.
+type Export struct {
+	// Columns are default columns for csv export.
+	Columns []string
+}
+
+// Compose handlers
+const (
+	ComposeMailto = "mailto"
+	ComposeEditor = "editor"
+)
+
+// Compose configures writing replies and forwards.
+type Compose struct {
+	// Handler is either 'mailto', which opens mailto: url with xdg-open, or 'editor', which opens draft
+	// in Editor and sends it with Sendmail.
+	Handler string
+	// Editor is the editor command. Empty uses $VISUAL or $EDITOR.
+	Editor string
+	// Sendmail is a sendmail-compatible command that reads message from stdin, e.g. 'msmtp -t'.
+	Sendmail string
+	// From is the sender address of drafts, e.g. 'Me <me@example.com>'.
+	From string
+}
+
+// Search configures searching.
+type Search struct {
+	// SavedFile stores saved searches.
+	SavedFile string
+	// HistoryFile stores executed searches.
+	HistoryFile string
+	// HistorySize is max number of searches in history.
+	HistorySize int
+}
+
 type Gui struct {
 	Mouse bool
 }
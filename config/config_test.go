@@ -0,0 +1,91 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package config
+
+import "testing"
+
+func TestAccount_FolderPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		account Account
+		folder  string
+		want    string
+	}{
+		{
+			name:    "thunderbird subfolder",
+			account: Account{Type: AccountMbox, File: File{Directory: "/home/me/.thunderbird/mail"}},
+			folder:  "Archive/2020",
+			want:    "/home/me/.thunderbird/mail/Archive.sbd/2020",
+		},
+		{
+			name:    "single mbox file",
+			account: Account{Type: AccountMbox, File: File{Directory: "/home/me/mbox"}},
+			folder:  "/home/me/mbox",
+			want:    "/home/me/mbox",
+		},
+		{
+			name:    "maildir inbox",
+			account: Account{Type: AccountIsync, File: File{Directory: "/home/me/Mail"}},
+			folder:  "Inbox",
+			want:    "/home/me/Mail",
+		},
+		{
+			name:    "maildir folder",
+			account: Account{Type: AccountIsync, File: File{Directory: "/home/me/Mail"}},
+			folder:  "Archive/2020",
+			want:    "/home/me/Mail/Archive/2020",
+		},
+		{
+			name:    "imap",
+			account: Account{Type: AccountImap, Imap: Imap{Url: "imap.example.com:993", Tls: true, Username: "me@example.com"}},
+			folder:  "Sent Items",
+			want:    "imaps://me%40example.com@imap.example.com:993/Sent%20Items",
+		},
+		{
+			name:    "mailspring",
+			account: Account{Type: AccountMailspring},
+			folder:  "INBOX",
+			want:    "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.account.FolderPath(tt.folder); got != tt.want {
+				t.Errorf("FolderPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Opener(t *testing.T) {
+	conf := &Config{
+		Open: Opener{Client: "thunderbird"},
+		Accounts: []Account{
+			{Name: "work", Open: Opener{Client: "neomutt"}},
+			{Name: "home"},
+		},
+	}
+	for account, want := range map[string]string{"work": "neomutt", "home": "thunderbird", "unknown": "thunderbird"} {
+		if got := conf.Opener(account).Client; got != want {
+			t.Errorf("Opener(%s) = %s, want %s", account, got, want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// HistoryEntry is an executed search.
+type HistoryEntry struct {
+	Query  string    `json:"query"`
+	Filter string    `json:"filter"`
+	Time   time.Time `json:"time"`
+}
+
+// String returns query and filter formatted on one line.
+func (h HistoryEntry) String() string {
+	if h.Filter == "" {
+		return h.Query
+	}
+	return h.Query + " | " + h.Filter
+}
+
+// History is a list of executed searches, oldest first, persisted to a file. Each query and filter pair is
+// stored only once, with the time it was last executed.
+type History struct {
+	file    string
+	maxSize int
+	Entries []HistoryEntry `json:"entries"`
+}
+
+// LoadHistory reads history from file. If file does not exist, empty history is returned.
+// History keeps at most maxSize latest entries, 0 is unlimited.
+func LoadHistory(file string, maxSize int) (*History, error) {
+	history := &History{
+		file:    file,
+		maxSize: maxSize,
+		Entries: []HistoryEntry{},
+	}
+
+	err := LoadJsonFile(file, history)
+	if err != nil {
+		return history, fmt.Errorf("load history: %v", err)
+	}
+	if history.Entries == nil {
+		history.Entries = []HistoryEntry{}
+	}
+	return history, nil
+}
+
+// Add adds search to the end of history. If the same search already exists, it is moved to the end.
+// Empty searches are ignored. Returns true if history needs to be saved: repeating the latest search only
+// updates its time and returns false.
+func (h *History) Add(query, filter string, ts time.Time) bool {
+	query = strings.TrimSpace(query)
+	filter = strings.TrimSpace(filter)
+	if query == "" && filter == "" {
+		return false
+	}
+	if last := len(h.Entries) - 1; last >= 0 && h.Entries[last].Query == query && h.Entries[last].Filter == filter {
+		h.Entries[last].Time = ts
+		return false
+	}
+
+	for i, v := range h.Entries {
+		if v.Query == query && v.Filter == filter {
+			h.Entries = append(h.Entries[:i], h.Entries[i+1:]...)
+			break
+		}
+	}
+	h.Entries = append(h.Entries, HistoryEntry{Query: query, Filter: filter, Time: ts})
+	if h.maxSize > 0 && len(h.Entries) > h.maxSize {
+		h.Entries = h.Entries[len(h.Entries)-h.maxSize:]
+	}
+	return true
+}
+
+// Clear removes all entries.
+func (h *History) Clear() {
+	h.Entries = []HistoryEntry{}
+}
+
+// Search returns entries that fuzzy-match pattern, best match first. Entries with equal score
+// are sorted newest first. Empty pattern returns all entries, newest first.
+func (h *History) Search(pattern string) []HistoryEntry {
+	type match struct {
+		entry HistoryEntry
+		score int
+		index int
+	}
+	var matches []match
+	for i, v := range h.Entries {
+		score, ok := fuzzyScore(pattern, v.String())
+		if ok {
+			matches = append(matches, match{entry: v, score: score, index: i})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].index > matches[j].index
+	})
+
+	out := make([]HistoryEntry, len(matches))
+	for i, v := range matches {
+		out[i] = v.entry
+	}
+	return out
+}
+
+// Save writes history to file.
+func (h *History) Save() error {
+	err := SaveJsonFile(h.file, h)
+	if err != nil {
+		return fmt.Errorf("save history: %v", err)
+	}
+	return nil
+}
+
+// fuzzyScore returns true if all characters of pattern are found in text in the same order, case-insensitive.
+// Consecutive matches and matches at the start of words score higher. Best score of all possible
+// starting positions is returned.
+func fuzzyScore(pattern, text string) (int, bool) {
+	patternRunes := []rune(strings.ToLower(pattern))
+	textRunes := []rune(strings.ToLower(text))
+	if len(patternRunes) == 0 {
+		return 0, true
+	}
+
+	best := -1
+	for start, r := range textRunes {
+		if r != patternRunes[0] {
+			continue
+		}
+		if score, ok := fuzzyScoreFrom(patternRunes, textRunes, start); ok && score > best {
+			best = score
+		}
+	}
+	return best, best >= 0
+}
+
+// fuzzyScoreFrom matches pattern greedily starting from text[start].
+func fuzzyScoreFrom(pattern, text []rune, start int) (int, bool) {
+	score := 0
+	p := 0
+	previous := -2
+	for i := start; i < len(text) && p < len(pattern); i++ {
+		if text[i] != pattern[p] {
+			continue
+		}
+		score += 1
+		if previous == i-1 {
+			score += 2
+		}
+		if i == 0 || !unicode.IsLetter(text[i-1]) && !unicode.IsDigit(text[i-1]) {
+			score += 3
+		}
+		previous = i
+		p++
+	}
+	return score, p == len(pattern)
+}
@@ -0,0 +1,101 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistory_Add(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.json")
+
+	history, err := LoadHistory(file, 3)
+	if err != nil {
+		t.Fatalf("load missing file: %v", err)
+	}
+	ts := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	history.Add("invoice", "folder=inbox", ts)
+	history.Add("report", "", ts.Add(time.Minute))
+	history.Add(" ", "", ts.Add(2*time.Minute))
+	history.Add("invoice", "folder=inbox", ts.Add(3*time.Minute))
+	history.Add("alice", "", ts.Add(4*time.Minute))
+	if !history.Add("bob", "", ts.Add(time.Minute)) {
+		t.Errorf("Add() new search = false, want true")
+	}
+	if history.Add("bob", "", ts.Add(5*time.Minute)) {
+		t.Errorf("Add() repeated latest search = true, want false")
+	}
+	if err := history.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadHistory(file, 3)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := []HistoryEntry{
+		{Query: "invoice", Filter: "folder=inbox", Time: ts.Add(3 * time.Minute)},
+		{Query: "alice", Time: ts.Add(4 * time.Minute)},
+		{Query: "bob", Time: ts.Add(5 * time.Minute)},
+	}
+	if !reflect.DeepEqual(loaded.Entries, want) {
+		t.Errorf("got %v, want %v", loaded.Entries, want)
+	}
+}
+
+func TestHistory_Search(t *testing.T) {
+	history := &History{}
+	ts := time.Now()
+	for _, query := range []string{"invoice march", "annual report", "from:alice invoice", "vacation"} {
+		history.Add(query, "", ts)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "", want: []string{"vacation", "from:alice invoice", "annual report", "invoice march"}},
+		{pattern: "inv", want: []string{"from:alice invoice", "invoice march"}},
+		{pattern: "INVM", want: []string{"invoice march"}},
+		{pattern: "arep", want: []string{"annual report"}},
+		{pattern: "xyz", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got := []string{}
+			for _, v := range history.Search(tt.pattern) {
+				got = append(got, v.Query)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%s) = %v, want %v", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
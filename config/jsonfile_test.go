@@ -0,0 +1,79 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJsonFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type state struct {
+		Values map[string]int `json:"values"`
+	}
+	file := filepath.Join(dir, "sub", "state.json")
+
+	// missing file leaves value as is
+	got := &state{Values: map[string]int{"default": 1}}
+	if err := LoadJsonFile(file, got); err != nil {
+		t.Fatalf("LoadJsonFile() missing file error = %v", err)
+	}
+	if want := map[string]int{"default": 1}; !reflect.DeepEqual(got.Values, want) {
+		t.Errorf("LoadJsonFile() missing file = %v, want %v", got.Values, want)
+	}
+
+	for _, values := range []map[string]int{{"a": 1}, {"b": 2}} {
+		if err := SaveJsonFile(file, &state{Values: values}); err != nil {
+			t.Fatalf("SaveJsonFile() error = %v", err)
+		}
+		got = &state{}
+		if err := LoadJsonFile(file, got); err != nil {
+			t.Fatalf("LoadJsonFile() error = %v", err)
+		}
+		if !reflect.DeepEqual(got.Values, values) {
+			t.Errorf("LoadJsonFile() = %v, want %v", got.Values, values)
+		}
+	}
+
+	// temporary files are renamed
+	entries, err := ioutil.ReadDir(filepath.Dir(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "state.json" || entries[0].Mode().Perm() != 0600 {
+		t.Errorf("directory has unexpected files: %v", entries)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("{truncated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadJsonFile(file, &state{}); err == nil {
+		t.Errorf("LoadJsonFile() invalid json error = nil")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SavedSearch is a named query and filter.
+type SavedSearch struct {
+	Name   string `json:"name"`
+	Query  string `json:"query"`
+	Filter string `json:"filter"`
+}
+
+// SavedSearches are user's saved searches, persisted to a file.
+type SavedSearches struct {
+	file     string
+	Searches []SavedSearch `json:"searches"`
+}
+
+// LoadSavedSearches reads saved searches from file. If file does not exist, empty list is returned.
+func LoadSavedSearches(file string) (*SavedSearches, error) {
+	searches := &SavedSearches{
+		file:     file,
+		Searches: []SavedSearch{},
+	}
+
+	err := LoadJsonFile(file, searches)
+	if err != nil {
+		return searches, fmt.Errorf("load saved searches: %v", err)
+	}
+	if searches.Searches == nil {
+		searches.Searches = []SavedSearch{}
+	}
+	return searches, nil
+}
+
+// Get returns saved search with given name, case-insensitive, or nil if there's no such search.
+func (s *SavedSearches) Get(name string) *SavedSearch {
+	for i, v := range s.Searches {
+		if strings.EqualFold(v.Name, name) {
+			return &s.Searches[i]
+		}
+	}
+	return nil
+}
+
+// Add adds new search or replaces existing search with same name. Searches are kept sorted by name.
+func (s *SavedSearches) Add(search SavedSearch) error {
+	if strings.TrimSpace(search.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if search.Query == "" && search.Filter == "" {
+		return fmt.Errorf("query or filter is required")
+	}
+	if existing := s.Get(search.Name); existing != nil {
+		*existing = search
+		return nil
+	}
+	s.Searches = append(s.Searches, search)
+	sort.SliceStable(s.Searches, func(i, j int) bool {
+		return strings.ToLower(s.Searches[i].Name) < strings.ToLower(s.Searches[j].Name)
+	})
+	return nil
+}
+
+// Remove removes search with given name. Returns false if there's no such search.
+func (s *SavedSearches) Remove(name string) bool {
+	for i, v := range s.Searches {
+		if strings.EqualFold(v.Name, name) {
+			s.Searches = append(s.Searches[:i], s.Searches[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes searches to file.
+func (s *SavedSearches) Save() error {
+	err := SaveJsonFile(s.file, s)
+	if err != nil {
+		return fmt.Errorf("save saved searches: %v", err)
+	}
+	return nil
+}
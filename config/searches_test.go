@@ -0,0 +1,81 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSavedSearches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex-searches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "searches.json")
+
+	searches, err := LoadSavedSearches(file)
+	if err != nil {
+		t.Fatalf("load missing file: %v", err)
+	}
+	if len(searches.Searches) != 0 {
+		t.Fatalf("expected no searches, got %v", searches.Searches)
+	}
+
+	if err := searches.Add(SavedSearch{Name: "invoices", Query: "invoice", Filter: "folder=inbox"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := searches.Add(SavedSearch{Name: "Alice", Query: "from:alice"}); err != nil {
+		t.Fatal(err)
+	}
+	// replace existing
+	if err := searches.Add(SavedSearch{Name: "Invoices", Query: "invoice", Filter: "after=-1m"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := searches.Add(SavedSearch{Name: "empty"}); err == nil {
+		t.Error("expected error for search without query and filter")
+	}
+	if err := searches.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadSavedSearches(file)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := []SavedSearch{
+		{Name: "Alice", Query: "from:alice"},
+		{Name: "Invoices", Query: "invoice", Filter: "after=-1m"},
+	}
+	if !reflect.DeepEqual(loaded.Searches, want) {
+		t.Errorf("got %v, want %v", loaded.Searches, want)
+	}
+
+	if !loaded.Remove("alice") || loaded.Remove("alice") {
+		t.Error("remove should succeed only once")
+	}
+	if loaded.Get("invoices") == nil || loaded.Get("alice") != nil {
+		t.Errorf("unexpected searches after remove: %v", loaded.Searches)
+	}
+}
@@ -0,0 +1,51 @@
+package external
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// OpenURL opens url, e.g. mailto: url, with default application using xdg-open.
+func OpenURL(url string) error {
+	return exec.Command("xdg-open", url).Run()
+}
+
+// EditCommand returns command that opens file in editor. Editor may contain arguments, e.g. 'code --wait'.
+// If editor is empty, $VISUAL, $EDITOR or vi is used.
+func EditCommand(editor, file string) *exec.Cmd {
+	if editor == "" {
+		editor = os.Getenv("VISUAL")
+	}
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := append(strings.Fields(editor), file)
+	return exec.Command(args[0], args[1:]...)
+}
+
+// Sendmail sends message by piping it to sendmail-compatible command, e.g. 'sendmail -t' or 'msmtp -t'.
+// Recipients are read from message headers by the command.
+func Sendmail(command string, message []byte) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return fmt.Errorf("empty sendmail command")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(message)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %v: %s", args[0], err, msg)
+		}
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	return nil
+}
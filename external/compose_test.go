@@ -0,0 +1,79 @@
+package external
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSendmail writes shell script that records its arguments and input to dir, and exits with given code.
+func fakeSendmail(t *testing.T, dir string, exitCode int) string {
+	script := filepath.Join(dir, "sendmail")
+	content := fmt.Sprintf(`#!/bin/sh
+echo "$@" > %s
+cat > %s
+if [ %d -ne 0 ]; then
+	echo 'no recipients found' >&2
+fi
+exit %d
+`, filepath.Join(dir, "args"), filepath.Join(dir, "message"), exitCode, exitCode)
+	err := ioutil.WriteFile(script, []byte(content), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func TestSendmail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex-sendmail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	message := "To: alice@example.com\nSubject: Re: report\n\nThanks!\n"
+	err = Sendmail(fakeSendmail(t, dir, 0)+" -t -i", []byte(message))
+	if err != nil {
+		t.Fatalf("Sendmail() error = %v", err)
+	}
+	args, _ := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if string(args) != "-t -i\n" {
+		t.Errorf("sendmail args = %q, want %q", args, "-t -i\n")
+	}
+	got, _ := ioutil.ReadFile(filepath.Join(dir, "message"))
+	if string(got) != message {
+		t.Errorf("sendmail input = %q, want %q", got, message)
+	}
+
+	err = Sendmail(fakeSendmail(t, dir, 1)+" -t", []byte(message))
+	if err == nil || !strings.Contains(err.Error(), "no recipients found") {
+		t.Errorf("Sendmail() error = %v, want error with sendmail output", err)
+	}
+
+	if err := Sendmail("", []byte(message)); err == nil {
+		t.Error("Sendmail() with empty command error = nil")
+	}
+}
+
+func TestEditCommand(t *testing.T) {
+	os.Setenv("VISUAL", "")
+	os.Setenv("EDITOR", "nano -w")
+	defer os.Unsetenv("EDITOR")
+
+	tests := []struct {
+		editor string
+		want   []string
+	}{
+		{editor: "code --wait", want: []string{"code", "--wait", "/tmp/draft.eml"}},
+		{editor: "", want: []string{"nano", "-w", "/tmp/draft.eml"}},
+	}
+	for _, tt := range tests {
+		cmd := EditCommand(tt.editor, "/tmp/draft.eml")
+		if strings.Join(cmd.Args, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("EditCommand(%q) = %q, want %q", tt.editor, cmd.Args, tt.want)
+		}
+	}
+}
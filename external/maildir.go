@@ -0,0 +1,112 @@
+package external
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// MaildirInbox is the folder name for mails in root maildir.
+const MaildirInbox = "Inbox"
+
+// VerbatimFiles lists all mail files in maildir folders found in baseDir, e.g. synced with isync.
+// Mails are read from both 'cur' and 'new'. Folder names follow directory structure, e.g. 'Archive/2020'
+// (isync 'SubFolders Verbatim'), or Maildir++ naming, where directory '.Archive.2020' is folder 'Archive/2020'.
+// Mails in baseDir itself belong to MaildirInbox.
+func VerbatimFiles(baseDir string) ([]MboxFile, error) {
+	dirs, err := MaildirDirs(strings.TrimSuffix(baseDir, "/"), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []MboxFile
+	for _, dir := range dirs {
+		if !dir.Mails {
+			continue
+		}
+		found, err := MaildirFiles(dir.Path, dir.Folder)
+		if err != nil {
+			return files, err
+		}
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+// MaildirDir is a directory in maildir tree.
+type MaildirDir struct {
+	Path string
+	// Folder is the folder name. For folder directories, it is the name that is passed to MaildirDirs
+	// when listing the directory again.
+	Folder string
+	// Mails is true for 'cur' and 'new' directories that contain mail files, and false for
+	// folder directories that contain them and subfolders.
+	Mails bool
+}
+
+// MaildirDirs lists maildir folder dir and all its 'cur' and 'new' directories and subfolders, even if
+// they are empty. Name is the folder name of dir, and is empty for root of maildir.
+func MaildirDirs(dir, name string) ([]MaildirDir, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := []MaildirDir{{Path: dir, Folder: name}}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		switch entry.Name() {
+		case "cur", "new":
+			folder := name
+			if folder == "" {
+				folder = MaildirInbox
+			}
+			dirs = append(dirs, MaildirDir{Path: path, Folder: folder, Mails: true})
+		case "tmp":
+			// mails that are being delivered
+		default:
+			found, err := MaildirDirs(path, maildirFolderName(name, entry.Name()))
+			if err != nil {
+				return dirs, err
+			}
+			dirs = append(dirs, found...)
+		}
+	}
+	return dirs, nil
+}
+
+// MaildirFiles lists mail files in maildir 'cur' or 'new' directory.
+func MaildirFiles(dir, folder string) ([]MboxFile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []MboxFile
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, MboxFile{
+			File: filepath.Join(dir, entry.Name()),
+			Name: folder,
+		})
+	}
+	return files, nil
+}
+
+// maildirFolderName returns folder name for directory dir in parent folder. Maildir++ directories,
+// e.g. '.Folder.Sub', are converted to 'Folder/Sub'.
+func maildirFolderName(parent, dir string) string {
+	name := dir
+	if strings.HasPrefix(dir, ".") && len(dir) > 1 {
+		name = strings.ReplaceAll(strings.TrimPrefix(dir, "."), ".", "/")
+	}
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
@@ -0,0 +1,53 @@
+package external
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMaildirDirs(t *testing.T) {
+	root, err := ioutil.TempDir("", "meilindex-maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// empty 'new' directories and an empty folder must be listed too
+	for _, dir := range []string{"cur", "new", "tmp", ".Archive.2020/cur", ".Archive.2020/new", "Empty"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "cur", "1:2,S"), []byte("Subject: a\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := MaildirDirs(root, "")
+	if err != nil {
+		t.Fatalf("MaildirDirs() error = %v", err)
+	}
+	want := []MaildirDir{
+		{Path: root},
+		{Path: filepath.Join(root, ".Archive.2020"), Folder: "Archive/2020"},
+		{Path: filepath.Join(root, ".Archive.2020/cur"), Folder: "Archive/2020", Mails: true},
+		{Path: filepath.Join(root, ".Archive.2020/new"), Folder: "Archive/2020", Mails: true},
+		{Path: filepath.Join(root, "Empty"), Folder: "Empty"},
+		{Path: filepath.Join(root, "cur"), Folder: MaildirInbox, Mails: true},
+		{Path: filepath.Join(root, "new"), Folder: MaildirInbox, Mails: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MaildirDirs() = %v, want %v", got, want)
+	}
+
+	files, err := VerbatimFiles(root)
+	if err != nil {
+		t.Fatalf("VerbatimFiles() error = %v", err)
+	}
+	wantFiles := []MboxFile{{File: filepath.Join(root, "cur", "1:2,S"), Name: MaildirInbox}}
+	if !reflect.DeepEqual(files, wantFiles) {
+		t.Errorf("VerbatimFiles() = %v, want %v", files, wantFiles)
+	}
+}
@@ -0,0 +1,130 @@
+package external
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Supported mail clients
+const (
+	ClientThunderbird = "thunderbird"
+	ClientMutt        = "mutt"
+	ClientNeomutt     = "neomutt"
+	ClientAerc        = "aerc"
+	ClientMailspring  = "mailspring"
+	ClientEvolution   = "evolution"
+	// ClientCommand runs command from template.
+	ClientCommand = "command"
+)
+
+var Clients = []string{ClientThunderbird, ClientMutt, ClientNeomutt, ClientAerc, ClientMailspring, ClientEvolution,
+	ClientCommand}
+
+// OpenTarget is a mail to open in mail client.
+type OpenTarget struct {
+	// Id is the Message-ID of mail.
+	Id     string
+	Folder string
+	// Path is the location of folder: mbox file, maildir directory or imap url. Empty if not known.
+	Path string
+}
+
+// Opener opens mails in a mail client.
+type Opener interface {
+	// Command returns command that opens mail.
+	Command(target OpenTarget) (*exec.Cmd, error)
+	// Terminal returns true if command runs in terminal, in which case it needs to take over the terminal
+	// until it exits.
+	Terminal() bool
+}
+
+// NewOpener returns opener for client. Template and terminal are only used with ClientCommand.
+func NewOpener(client, template string, terminal bool) (Opener, error) {
+	switch client {
+	case ClientThunderbird:
+		return thunderbirdOpener{}, nil
+	case ClientMutt, ClientNeomutt:
+		return muttOpener{binary: client}, nil
+	case ClientAerc:
+		return aercOpener{}, nil
+	case ClientMailspring:
+		return appOpener{binary: "mailspring"}, nil
+	case ClientEvolution:
+		return appOpener{binary: "evolution", args: []string{"--component=mail"}}, nil
+	case ClientCommand:
+		args := strings.Fields(template)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty command for mail client 'command'")
+		}
+		return commandOpener{args: args, terminal: terminal}, nil
+	}
+	return nil, fmt.Errorf("unknown mail client '%s', supported clients: %s", client, strings.Join(Clients, ", "))
+}
+
+// muttOpener opens folder in mutt or neomutt and limits view to the mail.
+type muttOpener struct {
+	binary string
+}
+
+// muttPatternEscape escapes backslashes and quotes in mutt pattern inside double-quoted push command.
+var muttPatternEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func (m muttOpener) Command(target OpenTarget) (*exec.Cmd, error) {
+	if target.Path == "" {
+		return nil, fmt.Errorf("location of folder '%s' is not known", target.Folder)
+	}
+	pattern := "~i " + regexp.QuoteMeta(target.Id)
+	push := fmt.Sprintf(`push "<limit>%s<enter><display-message>"`, muttPatternEscape.Replace(pattern))
+	return exec.Command(m.binary, "-f", target.Path, "-e", push), nil
+}
+
+func (m muttOpener) Terminal() bool {
+	return true
+}
+
+// aercOpener changes folder in running aerc instance. Aerc can not select a mail from command line.
+type aercOpener struct{}
+
+func (a aercOpener) Command(target OpenTarget) (*exec.Cmd, error) {
+	return exec.Command("aerc", ":cf", target.Folder), nil
+}
+
+func (a aercOpener) Terminal() bool {
+	return false
+}
+
+// appOpener starts graphical mail client. Client can not select a mail from command line.
+type appOpener struct {
+	binary string
+	args   []string
+}
+
+func (a appOpener) Command(target OpenTarget) (*exec.Cmd, error) {
+	return exec.Command(a.binary, a.args...), nil
+}
+
+func (a appOpener) Terminal() bool {
+	return false
+}
+
+// commandOpener runs command from template. Template is split into arguments at whitespace before
+// placeholders are replaced, so values with spaces are passed as single arguments.
+type commandOpener struct {
+	args     []string
+	terminal bool
+}
+
+func (c commandOpener) Command(target OpenTarget) (*exec.Cmd, error) {
+	replacer := strings.NewReplacer("{id}", target.Id, "{folder}", target.Folder, "{path}", target.Path)
+	args := make([]string, len(c.args))
+	for i, v := range c.args {
+		args[i] = replacer.Replace(v)
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+func (c commandOpener) Terminal() bool {
+	return c.terminal
+}
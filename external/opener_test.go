@@ -0,0 +1,93 @@
+package external
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewOpener(t *testing.T) {
+	target := OpenTarget{
+		Id:     "1234.abc+x@example.com",
+		Folder: "Archive/2020",
+		Path:   "/home/me/Mail/Archive 2020",
+	}
+	tests := []struct {
+		name         string
+		client       string
+		template     string
+		terminal     bool
+		wantArgs     []string
+		wantTerminal bool
+		wantErr      bool
+	}{
+		{
+			name:     "thunderbird",
+			client:   ClientThunderbird,
+			wantArgs: []string{"thunderbird", "-thunderlink", "thunderlink://messageid=1234.abc+x@example.com"},
+		},
+		{
+			name:   "neomutt",
+			client: ClientNeomutt,
+			wantArgs: []string{"neomutt", "-f", "/home/me/Mail/Archive 2020", "-e",
+				`push "<limit>~i 1234\\.abc\\+x@example\\.com<enter><display-message>"`},
+			wantTerminal: true,
+		},
+		{
+			name:     "aerc",
+			client:   ClientAerc,
+			wantArgs: []string{"aerc", ":cf", "Archive/2020"},
+		},
+		{
+			name:     "evolution",
+			client:   ClientEvolution,
+			wantArgs: []string{"evolution", "--component=mail"},
+		},
+		{
+			name:         "command",
+			client:       ClientCommand,
+			template:     "mailclient --folder={folder} --path {path} mid:{id}",
+			terminal:     true,
+			wantArgs:     []string{"mailclient", "--folder=Archive/2020", "--path", "/home/me/Mail/Archive 2020", "mid:1234.abc+x@example.com"},
+			wantTerminal: true,
+		},
+		{
+			name:    "empty command",
+			client:  ClientCommand,
+			wantErr: true,
+		},
+		{
+			name:    "unknown client",
+			client:  "pine",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opener, err := NewOpener(tt.client, tt.template, tt.terminal)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewOpener() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			cmd, err := opener.Command(target)
+			if err != nil {
+				t.Fatalf("Command() error = %v", err)
+			}
+			if !reflect.DeepEqual(cmd.Args, tt.wantArgs) {
+				t.Errorf("Command() args = %q, want %q", cmd.Args, tt.wantArgs)
+			}
+			if opener.Terminal() != tt.wantTerminal {
+				t.Errorf("Terminal() = %t, want %t", opener.Terminal(), tt.wantTerminal)
+			}
+		})
+	}
+}
+
+func TestMuttOpenerRequiresPath(t *testing.T) {
+	opener, _ := NewOpener(ClientMutt, "", false)
+	_, err := opener.Command(OpenTarget{Id: "1@example.com", Folder: "INBOX"})
+	if err == nil {
+		t.Error("Command() error = nil, want error for unknown folder location")
+	}
+}
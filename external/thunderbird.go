@@ -3,17 +3,20 @@ package external
 import (
 	"github.com/sirupsen/logrus"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
 )
 
-// OpenById opens given mail id with thunderbird email client.
-func OpenById(id string) error {
-	cmd := exec.Command("thunderbird", "-thunderlink",
-		"thunderlink://messageid="+id)
-	return cmd.Run()
+// thunderbirdOpener opens mails in thunderbird. Requires 'thunderlink' add-on.
+type thunderbirdOpener struct{}
+
+func (t thunderbirdOpener) Command(target OpenTarget) (*exec.Cmd, error) {
+	return exec.Command("thunderbird", "-thunderlink", "thunderlink://messageid="+target.Id), nil
+}
+
+func (t thunderbirdOpener) Terminal() bool {
+	return false
 }
 
 // files that are not mbox files
@@ -68,71 +71,3 @@ func mboxFiles(baseDir string, parentName string) ([]MboxFile, error) {
 	}
 	return files, nil
 }
-
-func VerbatimFiles(baseDir string) ([]MboxFile, error) {
-	return verbatimFiles(baseDir, "")
-}
-
-var verbatimFileSuffices = map[string]bool{
-	",S":  true,
-	",RS": true,
-	",FS": true,
-}
-
-func verbatimFiles(baseDir, parentName string) ([]MboxFile, error) {
-	var files []MboxFile
-
-	dirs, err := filepath.Glob(baseDir + "/*")
-	if err != nil {
-		return nil, err
-	}
-	fileNames, err := filepath.Glob(baseDir)
-	if err != nil {
-		return nil, err
-
-	}
-
-	println(fileNames)
-	for _, v := range dirs {
-
-		_, relativeName := path.Split(v)
-		if relativeName == "cur" {
-			found, err := filepath.Glob(v + "/*")
-			if err != nil {
-				logrus.Errorf("list files: %v", err)
-			} else {
-				for _, file := range found {
-					base := filepath.Base(v)
-					file := MboxFile{
-						File: file,
-					}
-					if parentName == "" {
-						file.Name = base
-					} else {
-						file.Name = parentName
-					}
-					files = append(files, file)
-				}
-			}
-
-		} else if relativeName == "tmp" || relativeName == "new" || relativeName == ".mbsyncstate" ||
-			relativeName == ".uidvalidity" {
-			// pass
-		} else {
-			// recurse
-			base := filepath.Base(v)
-			parent := base
-			if parentName != "" {
-				parent = parentName + "/" + base
-			}
-			found, err := verbatimFiles(v, parent)
-			if err != nil {
-				logrus.Error(err)
-			} else {
-				files = append(files, found...)
-			}
-
-		}
-	}
-	return files, nil
-}
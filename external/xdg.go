@@ -0,0 +1,8 @@
+package external
+
+import "os/exec"
+
+// OpenFile opens file with default application using xdg-open.
+func OpenFile(path string) error {
+	return exec.Command("xdg-open", path).Run()
+}
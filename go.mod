@@ -6,6 +6,7 @@ require (
 	github.com/emersion/go-imap v1.0.4
 	github.com/emersion/go-mbox v1.0.0
 	github.com/emersion/go-message v0.12.0
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/gdamore/tcell v1.3.0
 	github.com/jaytaylor/html2text v0.0.0-20200412013138-3577fbdbcff7
 	github.com/jmoiron/sqlx v1.3.1
@@ -0,0 +1,161 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"tryffel.net/go/meilindex/config"
+)
+
+// AttachmentCache stores attachments of indexed mails in local directory, since attachments are not stored
+// in index. Contents are stored once by their sha256 hash in 'blobs', and attachments of each mail are listed
+// in 'mails/<uid>.json', where uid is the document uid, which is Mail.Uid in search results.
+type AttachmentCache struct {
+	Dir string
+}
+
+// CachedAttachment is an attachment stored in AttachmentCache.
+type CachedAttachment struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int    `json:"size"`
+	Hash     string `json:"hash"`
+}
+
+func NewAttachmentCache(dir string) *AttachmentCache {
+	return &AttachmentCache{Dir: dir}
+}
+
+func (c *AttachmentCache) blobPath(hash string) string {
+	return filepath.Join(c.Dir, "blobs", hash[:2], hash)
+}
+
+func (c *AttachmentCache) mailPath(uid string) string {
+	return filepath.Join(c.Dir, "mails", uid+".json")
+}
+
+// Store stores attachments of mail that has been read from mail source. Mails without attachments are skipped.
+func (c *AttachmentCache) Store(mail *Mail) error {
+	if len(mail.Attachments) == 0 {
+		return nil
+	}
+
+	attachments := make([]CachedAttachment, len(mail.Attachments))
+	for i, v := range mail.Attachments {
+		hash := sha256.Sum256(v.Data)
+		attachment := CachedAttachment{
+			Name:     attachmentFileName(v.Name, i),
+			MimeType: v.MimeType,
+			Size:     len(v.Data),
+			Hash:     hex.EncodeToString(hash[:]),
+		}
+		path := c.blobPath(attachment.Hash)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			err = config.WriteFileAtomic(path, v.Data)
+			if err != nil {
+				return fmt.Errorf("write attachment: %v", err)
+			}
+		}
+		attachments[i] = attachment
+	}
+
+	data, err := json.MarshalIndent(attachments, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode attachments: %v", err)
+	}
+	return config.WriteFileAtomic(c.mailPath(documentUid(mail.Uid)), data)
+}
+
+// List returns attachments of mail with given document uid. Mails that are not cached have no attachments.
+func (c *AttachmentCache) List(uid string) ([]CachedAttachment, error) {
+	data, err := ioutil.ReadFile(c.mailPath(uid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var attachments []CachedAttachment
+	err = json.Unmarshal(data, &attachments)
+	if err != nil {
+		return nil, fmt.Errorf("decode attachments: %v", err)
+	}
+	return attachments, nil
+}
+
+// Read returns contents of attachment.
+func (c *AttachmentCache) Read(attachment CachedAttachment) ([]byte, error) {
+	return ioutil.ReadFile(c.blobPath(attachment.Hash))
+}
+
+// Save copies attachment to directory dir, see SaveAttachment. Returns path to saved file.
+func (c *AttachmentCache) Save(attachment CachedAttachment, dir string) (string, error) {
+	data, err := c.Read(attachment)
+	if err != nil {
+		return "", err
+	}
+	return SaveAttachment(attachment.Name, data, dir)
+}
+
+// SaveAttachment writes attachment data to file name in directory dir. If file with same name already exists,
+// number is appended to name. Returns path to saved file.
+func SaveAttachment(name string, data []byte, dir string) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	path := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return path, err
+	}
+}
+
+// attachmentFileName returns safe file name for attachment. Attachments without name are named by their index.
+func attachmentFileName(name string, index int) string {
+	name = strings.TrimSpace(strings.ReplaceAll(name, "\\", "/"))
+	name = filepath.Base(name)
+	if name == "" || name == "." || name == "/" || name == ".." {
+		return fmt.Sprintf("attachment-%d", index+1)
+	}
+	return name
+}
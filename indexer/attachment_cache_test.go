@@ -0,0 +1,96 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAttachmentCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex-attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := NewAttachmentCache(filepath.Join(dir, "cache"))
+	report := []byte("a,b\n1,2\n")
+	mails := []*Mail{
+		{
+			Uid: "<1@example.com>",
+			Attachments: []Attachment{
+				{Name: "report.csv", MimeType: "text/csv", Data: report},
+				{MimeType: "text/html", Data: []byte("<p>hi</p>")},
+			},
+		},
+		{
+			Uid:         "<2@example.com>",
+			Attachments: []Attachment{{Name: "../../report.csv", MimeType: "text/csv", Data: report}},
+		},
+		{Uid: "<3@example.com>"},
+	}
+	for _, mail := range mails {
+		if err := cache.Store(mail); err != nil {
+			t.Fatalf("Store() error = %v", err)
+		}
+	}
+
+	first, err := cache.List(documentUid("<1@example.com>"))
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	second, err := cache.List(documentUid("<2@example.com>"))
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	names := []string{}
+	for _, v := range append(first, second...) {
+		names = append(names, v.Name)
+	}
+	if want := []string{"report.csv", "attachment-2", "report.csv"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List() names = %v, want %v", names, want)
+	}
+	if first[0].Hash != second[0].Hash {
+		t.Errorf("same content has different hashes: %s, %s", first[0].Hash, second[0].Hash)
+	}
+	if missing, err := cache.List(documentUid("<3@example.com>")); err != nil || missing != nil {
+		t.Errorf("List() for mail without attachments = %v, %v, want nil, nil", missing, err)
+	}
+
+	saveDir := filepath.Join(dir, "saved")
+	for _, want := range []string{"report.csv", "report (1).csv"} {
+		path, err := cache.Save(first[0], saveDir)
+		if err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		if filepath.Base(path) != want {
+			t.Errorf("Save() path = %s, want file %s", path, want)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil || string(data) != string(report) {
+			t.Errorf("saved file = %q, %v, want %q", data, err, report)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Draft types
+const (
+	DraftReply    = "reply"
+	DraftReplyAll = "reply-all"
+	DraftForward  = "forward"
+)
+
+var DraftTypes = []string{DraftReply, DraftReplyAll, DraftForward}
+
+// NewDraft creates reply or forward draft of mail. Draft is a mail without id, which can be formatted
+// with MailToMessage or MailtoURL. From is the sender of draft, and it is left out from recipients
+// when replying to all. Search highlights are removed from mail.
+func NewDraft(mail *Mail, draftType string, from Address, now time.Time) (*Mail, error) {
+	subject := removeHighlight(mail.Subject)
+	sender := mail.FromAddress
+	if sender.Name == "" {
+		sender.Name = removeHighlight(mail.From)
+		if sender.Name == sender.Address {
+			sender.Name = ""
+		}
+	}
+
+	draft := &Mail{
+		FromAddress: from,
+		From:        from.DisplayName(),
+		Timestamp:   now,
+	}
+	switch draftType {
+	case DraftReply, DraftReplyAll:
+		draft.Subject = prefixSubject("Re:", subject)
+		draft.InReplyTo = mail.Id
+		draft.References = append(append([]string{}, mail.References...), mail.Id)
+		draft.Body = fmt.Sprintf("\n\nOn %s, %s wrote:\n%s", mail.Timestamp.Format("Mon, 2 Jan 2006 15:04"),
+			sender.DisplayName(), quoteBody(removeHighlight(mail.Body)))
+		if sender.Address != "" {
+			draft.ToAddresses = []Address{sender}
+		}
+		if draftType == DraftReplyAll {
+			draft.CcAddresses = replyAllRecipients(mail, sender, from)
+		}
+	case DraftForward:
+		draft.Subject = prefixSubject("Fwd:", subject)
+		draft.Body = fmt.Sprintf("\n\n---------- Forwarded message ----------\nFrom: %s\nDate: %s\nSubject: %s\nTo: %s\n\n%s",
+			formatAddressList([]Address{sender}), mail.Timestamp.Format(time.RFC1123Z), subject,
+			formatAddressList(mail.ToAddresses), removeHighlight(mail.Body))
+	default:
+		return nil, fmt.Errorf("unknown draft type '%s', supported types: %s", draftType, strings.Join(DraftTypes, ", "))
+	}
+
+	draft.To = displayNames(draft.ToAddresses)
+	draft.Cc = displayNames(draft.CcAddresses)
+	return draft, nil
+}
+
+// replyAllRecipients returns recipients of mail, without sender of mail, draft sender and duplicates.
+func replyAllRecipients(mail *Mail, sender, from Address) []Address {
+	seen := map[string]bool{
+		strings.ToLower(sender.Address): true,
+		strings.ToLower(from.Address):   true,
+	}
+	var recipients []Address
+	for _, v := range append(append([]Address{}, mail.ToAddresses...), mail.CcAddresses...) {
+		address := strings.ToLower(v.Address)
+		if address == "" || seen[address] {
+			continue
+		}
+		seen[address] = true
+		recipients = append(recipients, v)
+	}
+	return recipients
+}
+
+// prefixSubject adds prefix, e.g. 'Re:', to subject, unless subject already has it.
+func prefixSubject(prefix, subject string) string {
+	if strings.HasPrefix(strings.ToLower(subject), strings.ToLower(prefix)) {
+		return subject
+	}
+	return prefix + " " + subject
+}
+
+// quoteBody prefixes every line of body with '> '.
+func quoteBody(body string) string {
+	body = strings.TrimRight(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ">") {
+			lines[i] = ">" + line
+		} else {
+			lines[i] = "> " + line
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// removeHighlight removes search match tags from text.
+func removeHighlight(text string) string {
+	return strings.NewReplacer("<em>", "", "</em>", "").Replace(text)
+}
+
+// formatAddressList formats addresses as a comma-separated list without encoding.
+func formatAddressList(addresses []Address) string {
+	out := make([]string, len(addresses))
+	for i, v := range addresses {
+		out[i] = v.String()
+	}
+	return strings.Join(out, ", ")
+}
+
+// MailtoURL returns draft as mailto: url (RFC 6068).
+func MailtoURL(draft *Mail) string {
+	to := make([]string, len(draft.ToAddresses))
+	for i, v := range draft.ToAddresses {
+		to[i] = url.PathEscape(v.Address)
+	}
+	cc := make([]string, len(draft.CcAddresses))
+	for i, v := range draft.CcAddresses {
+		cc[i] = v.Address
+	}
+
+	query := url.Values{}
+	if len(cc) > 0 {
+		query.Set("cc", strings.Join(cc, ","))
+	}
+	query.Set("subject", draft.Subject)
+	if draft.InReplyTo != "" {
+		query.Set("in-reply-to", "<"+draft.InReplyTo+">")
+	}
+	query.Set("body", strings.ReplaceAll(draft.Body, "\n", "\r\n"))
+	// mailto uses percent-encoding, where '+' is not a space.
+	return "mailto:" + strings.Join(to, ",") + "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+}
@@ -0,0 +1,119 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewDraft(t *testing.T) {
+	mail := &Mail{
+		Id:          "2@example.com",
+		From:        "<em>Alice</em>",
+		FromAddress: Address{Name: "Alice", Address: "alice@example.com"},
+		ToAddresses: []Address{{Name: "Me", Address: "me@example.com"}, {Address: "bob@example.com"}},
+		CcAddresses: []Address{{Address: "Alice@example.com"}, {Address: "carol@example.com"}},
+		Subject:     "Weekly <em>report</em>",
+		Body:        "Numbers attached.\n> earlier\n",
+		Timestamp:   time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC),
+		References:  []string{"1@example.com"},
+	}
+	me := Address{Name: "Me", Address: "me@example.com"}
+	now := time.Date(2021, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	reply, err := NewDraft(mail, DraftReply, me, now)
+	if err != nil {
+		t.Fatalf("NewDraft() error = %v", err)
+	}
+	if reply.Subject != "Re: Weekly report" {
+		t.Errorf("reply subject = %q", reply.Subject)
+	}
+	if !reflect.DeepEqual(reply.ToAddresses, []Address{mail.FromAddress}) || len(reply.CcAddresses) != 0 {
+		t.Errorf("reply recipients = %v, cc %v", reply.ToAddresses, reply.CcAddresses)
+	}
+	if reply.InReplyTo != "2@example.com" || !reflect.DeepEqual(reply.References, []string{"1@example.com", "2@example.com"}) {
+		t.Errorf("reply in-reply-to = %q, references = %v", reply.InReplyTo, reply.References)
+	}
+	wantBody := "\n\nOn Mon, 1 Mar 2021 10:00, Alice wrote:\n> Numbers attached.\n>> earlier\n"
+	if reply.Body != wantBody {
+		t.Errorf("reply body = %q, want %q", reply.Body, wantBody)
+	}
+	if !reflect.DeepEqual(mail.References, []string{"1@example.com"}) {
+		t.Errorf("original references modified: %v", mail.References)
+	}
+
+	replyAll, err := NewDraft(mail, DraftReplyAll, me, now)
+	if err != nil {
+		t.Fatalf("NewDraft() error = %v", err)
+	}
+	wantCc := []Address{{Address: "bob@example.com"}, {Address: "carol@example.com"}}
+	if !reflect.DeepEqual(replyAll.CcAddresses, wantCc) {
+		t.Errorf("reply all cc = %v, want %v", replyAll.CcAddresses, wantCc)
+	}
+
+	reply.Subject = "RE: foo"
+	again, _ := NewDraft(reply, DraftReply, me, now)
+	if again.Subject != "RE: foo" {
+		t.Errorf("reply to reply subject = %q, want %q", again.Subject, "RE: foo")
+	}
+
+	forward, err := NewDraft(mail, DraftForward, me, now)
+	if err != nil {
+		t.Fatalf("NewDraft() error = %v", err)
+	}
+	if forward.Subject != "Fwd: Weekly report" || len(forward.ToAddresses) != 0 || forward.InReplyTo != "" {
+		t.Errorf("forward = subject %q, to %v, in-reply-to %q", forward.Subject, forward.ToAddresses, forward.InReplyTo)
+	}
+	if !strings.Contains(forward.Body, "From: Alice <alice@example.com>\n") ||
+		!strings.HasSuffix(forward.Body, "\n\nNumbers attached.\n> earlier\n") {
+		t.Errorf("forward body = %q", forward.Body)
+	}
+
+	message := string(MailToMessage(reply))
+	for _, want := range []string{"From: \"Me\" <me@example.com>\r\n", "To: \"Alice\" <alice@example.com>\r\n",
+		"In-Reply-To: <2@example.com>\r\n", "References: <1@example.com> <2@example.com>\r\n"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message does not contain %q:\n%s", want, message)
+		}
+	}
+
+	if _, err := NewDraft(mail, "bounce", me, now); err == nil {
+		t.Error("NewDraft() with unknown type error = nil")
+	}
+}
+
+func TestMailtoURL(t *testing.T) {
+	draft := &Mail{
+		ToAddresses: []Address{{Name: "Alice", Address: "alice@example.com"}},
+		CcAddresses: []Address{{Address: "bob@example.com"}, {Address: "carol@example.com"}},
+		Subject:     "Re: 1+1 & more",
+		InReplyTo:   "2@example.com",
+		Body:        "hi\n> quote",
+	}
+	want := "mailto:alice@example.com?body=hi%0D%0A%3E%20quote&cc=bob%40example.com%2Ccarol%40example.com" +
+		"&in-reply-to=%3C2%40example.com%3E&subject=Re%3A%201%2B1%20%26%20more"
+	if got := MailtoURL(draft); got != want {
+		t.Errorf("MailtoURL() = %s, want %s", got, want)
+	}
+}
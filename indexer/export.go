@@ -0,0 +1,413 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package indexer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/emersion/go-mbox"
+	"github.com/mgutz/ansi"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Export formats
+const (
+	FormatText  = "text"
+	FormatJson  = "json"
+	FormatJsonl = "jsonl"
+	FormatCsv   = "csv"
+	FormatMbox  = "mbox"
+	FormatEml   = "eml"
+)
+
+// ExportFormats lists supported export formats.
+var ExportFormats = []string{FormatText, FormatJson, FormatJsonl, FormatCsv, FormatMbox, FormatEml}
+
+// ExportColumns lists columns that can be exported to csv.
+var ExportColumns = []string{"uid", "id", "account", "folder", "date", "from", "from_address", "to", "to_address",
+	"cc", "cc_address", "bcc", "bcc_address", "subject", "flags", "attachments", "thread_id", "in_reply_to", "body"}
+
+// Exporter writes mails in some format. Mails can be exported in several batches,
+// and Close must be called after last batch.
+type Exporter interface {
+	Export(mails []*Mail) error
+	Close() error
+}
+
+// RawFunc reads original message from source, e.g. ReadRaw.
+type RawFunc func(source *Source) ([]byte, error)
+
+// ValidateExport returns error if format is not supported or csv columns are invalid.
+func ValidateExport(format string, columns []string) error {
+	switch format {
+	case FormatText, FormatJson, FormatJsonl, FormatMbox, FormatEml:
+		return nil
+	case FormatCsv:
+		for _, column := range columns {
+			if _, err := exportColumn(&Mail{}, column); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format '%s', supported formats: %s", format, strings.Join(ExportFormats, ", "))
+	}
+}
+
+// NewExporter creates exporter that writes to w. Columns are only used with csv. Raw is used with mbox to
+// export original messages, see NewEmlExporter. Eml requires a directory, see NewEmlExporter.
+func NewExporter(w io.Writer, format string, columns []string, raw RawFunc) (Exporter, error) {
+	if err := ValidateExport(format, columns); err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatText:
+		return &textExporter{w: w}, nil
+	case FormatJson:
+		return &jsonExporter{w: w}, nil
+	case FormatJsonl:
+		return &jsonExporter{w: w, lines: true}, nil
+	case FormatCsv:
+		return &csvExporter{writer: csv.NewWriter(w), columns: columns}, nil
+	case FormatMbox:
+		return &mboxExporter{writer: mbox.NewWriter(w), raw: raw}, nil
+	default:
+		return nil, fmt.Errorf("eml format requires output directory")
+	}
+}
+
+// textExporter prints mails to terminal with highlighted matches.
+type textExporter struct {
+	w     io.Writer
+	count int
+}
+
+func (t *textExporter) Export(mails []*Mail) error {
+	yellow := ansi.ColorCode("yellow+i:black")
+	reset := ansi.ColorCode("reset")
+	for _, mail := range mails {
+		if strings.Contains(mail.Body, "<em>") {
+			mail.Body = strings.Replace(mail.Body, "<em>", yellow, -1)
+			mail.Body = strings.Replace(mail.Body, "</em>", reset, -1)
+		}
+
+		mail.Subject = ansi.Blue + mail.Subject + ansi.Reset
+		_, err := fmt.Fprintf(t.w, "-----------------\n%d %s", t.count, mail.String())
+		if err != nil {
+			return err
+		}
+		t.count += 1
+	}
+	return nil
+}
+
+func (t *textExporter) Close() error {
+	return nil
+}
+
+// jsonExporter writes mails as json array, or with lines, one json object per line.
+type jsonExporter struct {
+	w     io.Writer
+	lines bool
+	count int
+}
+
+func (j *jsonExporter) Export(mails []*Mail) error {
+	for _, mail := range mails {
+		var data []byte
+		var err error
+		if j.lines {
+			data, err = json.Marshal(mail)
+		} else {
+			data, err = json.MarshalIndent(mail, "  ", "  ")
+			if j.count == 0 {
+				data = append([]byte("[\n  "), data...)
+			} else {
+				data = append([]byte(",\n  "), data...)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if j.lines {
+			data = append(data, '\n')
+		}
+		_, err = j.w.Write(data)
+		if err != nil {
+			return err
+		}
+		j.count += 1
+	}
+	return nil
+}
+
+func (j *jsonExporter) Close() error {
+	if j.lines {
+		return nil
+	}
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// csvExporter writes mails as csv with given columns. First row contains column names.
+type csvExporter struct {
+	writer  *csv.Writer
+	columns []string
+	started bool
+}
+
+func (c *csvExporter) Export(mails []*Mail) error {
+	if !c.started {
+		c.started = true
+		err := c.writer.Write(c.columns)
+		if err != nil {
+			return err
+		}
+	}
+	row := make([]string, len(c.columns))
+	for _, mail := range mails {
+		for i, column := range c.columns {
+			row[i], _ = exportColumn(mail, column)
+		}
+		err := c.writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+func (c *csvExporter) Close() error {
+	if !c.started {
+		return c.Export(nil)
+	}
+	return nil
+}
+
+// exportColumn returns csv value for mail. Lists are joined with '; '.
+func exportColumn(mail *Mail, column string) (string, error) {
+	addresses := func(list []Address) string {
+		out := make([]string, len(list))
+		for i, v := range list {
+			out[i] = v.Address
+		}
+		return strings.Join(out, "; ")
+	}
+
+	switch column {
+	case "uid":
+		return mail.Uid, nil
+	case "id":
+		return mail.Id, nil
+	case "account":
+		return mail.Account, nil
+	case "folder":
+		return mail.Folder, nil
+	case "date":
+		return mail.Timestamp.Format(time.RFC3339), nil
+	case "from":
+		return mail.From, nil
+	case "from_address":
+		return mail.FromAddress.Address, nil
+	case "to":
+		return strings.Join(mail.To, "; "), nil
+	case "to_address":
+		return addresses(mail.ToAddresses), nil
+	case "cc":
+		return strings.Join(mail.Cc, "; "), nil
+	case "cc_address":
+		return addresses(mail.CcAddresses), nil
+	case "bcc":
+		return strings.Join(mail.Bcc, "; "), nil
+	case "bcc_address":
+		return addresses(mail.BccAddresses), nil
+	case "subject":
+		return mail.Subject, nil
+	case "flags":
+		return strings.Join(mail.Flags, "; "), nil
+	case "attachments":
+		return strings.Join(mail.AttachmentNames, "; "), nil
+	case "thread_id":
+		return mail.ThreadId, nil
+	case "in_reply_to":
+		return mail.InReplyTo, nil
+	case "body":
+		return mail.Body, nil
+	default:
+		return "", fmt.Errorf("unknown column '%s', supported columns: %s", column, strings.Join(ExportColumns, ", "))
+	}
+}
+
+// mboxExporter writes mails to mbox file, see exportMessage.
+type mboxExporter struct {
+	writer *mbox.Writer
+	raw    RawFunc
+}
+
+func (m *mboxExporter) Export(mails []*Mail) error {
+	for _, mail := range mails {
+		msg, err := m.writer.CreateMessage(mail.FromAddress.Address, mail.Timestamp)
+		if err != nil {
+			return fmt.Errorf("create message: %v", err)
+		}
+		_, err = msg.Write(exportMessage(mail, m.raw))
+		if err != nil {
+			return fmt.Errorf("write message: %v", err)
+		}
+	}
+	return nil
+}
+
+func (m *mboxExporter) Close() error {
+	return m.writer.Close()
+}
+
+// emlExporter writes each mail to its own .eml file, named by mail uid. See exportMessage.
+type emlExporter struct {
+	dir string
+	raw RawFunc
+}
+
+// NewEmlExporter creates exporter that writes mails as .eml files to directory dir, which is created if needed.
+// Original message is read with raw, if it is set. If original message cannot be read, message is
+// reconstructed from index and a warning is logged.
+func NewEmlExporter(dir string, raw RawFunc) (Exporter, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &emlExporter{dir: dir, raw: raw}, nil
+}
+
+func (e *emlExporter) Export(mails []*Mail) error {
+	for _, mail := range mails {
+		name := filepath.Join(e.dir, documentUid(mail.Uid)+".eml")
+		err := ioutil.WriteFile(name, exportMessage(mail, e.raw), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *emlExporter) Close() error {
+	return nil
+}
+
+// exportMessage returns original message of mail, if raw is set and message can be read from source.
+// Otherwise message is reconstructed with MailToMessage, and a warning is logged.
+func exportMessage(mail *Mail, raw RawFunc) []byte {
+	if raw == nil {
+		return MailToMessage(mail)
+	}
+	data, err := raw(mail.Source)
+	if err != nil {
+		logrus.Warningf("Export mail %s: read original message: %v. Exporting message reconstructed from index, "+
+			"without attachments", mail.Id, err)
+		return MailToMessage(mail)
+	}
+	return data
+}
+
+// MailToMessage reconstructs RFC 5322 message from indexed fields. Original raw message is not stored in index,
+// thus the result only has main headers and plain text body. Attachments are not included, but their names
+// are listed in header 'X-Meilindex-Attachments'.
+func MailToMessage(mail *Mail) []byte {
+	buf := &bytes.Buffer{}
+	header := func(key, value string) {
+		if value != "" {
+			buf.WriteString(key + ": " + value + "\r\n")
+		}
+	}
+	addressList := func(names []string, addresses []Address) string {
+		if len(addresses) == 0 {
+			return encodeHeader(strings.Join(names, ", "))
+		}
+		out := make([]string, len(addresses))
+		for i, v := range addresses {
+			out[i] = formatAddress(v)
+		}
+		return strings.Join(out, ", ")
+	}
+
+	from := formatAddress(mail.FromAddress)
+	if from == "" {
+		from = encodeHeader(mail.From)
+	}
+	header("Date", mail.Timestamp.Format(time.RFC1123Z))
+	header("From", from)
+	header("To", addressList(mail.To, mail.ToAddresses))
+	header("Cc", addressList(mail.Cc, mail.CcAddresses))
+	header("Bcc", addressList(mail.Bcc, mail.BccAddresses))
+	header("Subject", encodeHeader(mail.Subject))
+	if mail.Id != "" {
+		header("Message-ID", "<"+mail.Id+">")
+	}
+	if mail.InReplyTo != "" {
+		header("In-Reply-To", "<"+mail.InReplyTo+">")
+	}
+	if len(mail.References) > 0 {
+		header("References", "<"+strings.Join(mail.References, "> <")+">")
+	}
+	header("X-Meilindex-Account", mail.Account)
+	header("X-Meilindex-Folder", encodeHeader(mail.Folder))
+	if len(mail.AttachmentNames) > 0 {
+		header("X-Meilindex-Attachments", encodeHeader(strings.Join(mail.AttachmentNames, ", ")))
+	}
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+
+	body := strings.ReplaceAll(mail.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if !strings.HasSuffix(body, "\n") {
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+// encodeHeader encodes non-ascii header value with RFC 2047 encoding.
+func encodeHeader(value string) string {
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+// formatAddress formats address as 'name <address>', encoding name if needed.
+func formatAddress(address Address) string {
+	if address.Address == "" {
+		return encodeHeader(address.Name)
+	}
+	return (&netmail.Address{Name: address.Name, Address: address.Address}).String()
+}
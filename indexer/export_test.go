@@ -0,0 +1,277 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testExportMail() *Mail {
+	return &Mail{
+		Uid:         "abc",
+		Id:          "1234@example.com",
+		From:        "Järvinen, Matti",
+		FromAddress: Address{Name: "Järvinen, Matti", Address: "matti@example.com"},
+		To:          []string{"Alice", "bob@example.com"},
+		ToAddresses: []Address{{Name: "Alice", Address: "alice@example.com"}, {Address: "bob@example.com"}},
+		Subject:     "Re: Välitys",
+		Body:        "First line\nFrom here on\n",
+		Timestamp:   time.Date(2021, 3, 4, 10, 20, 0, 0, time.UTC),
+		Folder:      "Inbox",
+		Account:     "work",
+		Flags:       []string{FlagSeen},
+		InReplyTo:   "1000@example.com",
+		References:  []string{"999@example.com", "1000@example.com"},
+	}
+}
+
+func TestMailToMessage(t *testing.T) {
+	mail := testExportMail()
+	msg, err := netmail.ReadMessage(bytes.NewReader(MailToMessage(mail)))
+	if err != nil {
+		t.Fatalf("parse message: %v", err)
+	}
+
+	from, err := addressParser.Parse(msg.Header.Get("From"))
+	if err != nil {
+		t.Fatalf("parse from: %v", err)
+	}
+	if from.Name != mail.FromAddress.Name || from.Address != mail.FromAddress.Address {
+		t.Errorf("from: got %v, want %v", from, mail.FromAddress)
+	}
+	if to := parseAddressList(msg.Header.Get("To")); !reflect.DeepEqual(to, mail.ToAddresses) {
+		t.Errorf("to: got %v, want %v", to, mail.ToAddresses)
+	}
+	subject, err := addressParser.WordDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != mail.Subject {
+		t.Errorf("subject: got %s (%v), want %s", subject, err, mail.Subject)
+	}
+	date, err := msg.Header.Date()
+	if err != nil || !date.Equal(mail.Timestamp) {
+		t.Errorf("date: got %v (%v), want %v", date, err, mail.Timestamp)
+	}
+
+	headers := map[string]string{
+		"Message-Id":          "<1234@example.com>",
+		"In-Reply-To":         "<1000@example.com>",
+		"References":          "<999@example.com> <1000@example.com>",
+		"X-Meilindex-Account": "work",
+		"Cc":                  "",
+	}
+	for key, want := range headers {
+		if got := msg.Header.Get(key); got != want {
+			t.Errorf("header %s: got %s, want %s", key, got, want)
+		}
+	}
+
+	body, _ := ioutil.ReadAll(msg.Body)
+	if string(body) != "First line\r\nFrom here on\r\n" {
+		t.Errorf("body: got %q", string(body))
+	}
+}
+
+func TestExportCsv(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "addresses",
+			columns: []string{"date", "from_address", "to_address", "subject"},
+			want: "date,from_address,to_address,subject\n" +
+				"2021-03-04T10:20:00Z,matti@example.com,alice@example.com; bob@example.com,Re: Välitys\n",
+		},
+		{
+			name:    "quoted",
+			columns: []string{"from", "flags", "body"},
+			want:    "from,flags,body\n\"Järvinen, Matti\",seen,\"First line\nFrom here on\n\"\n",
+		},
+		{
+			name:    "unknown column",
+			columns: []string{"date", "size"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			exporter, err := NewExporter(buf, FormatCsv, tt.columns, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewExporter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			err = exporter.Export([]*Mail{testExportMail()})
+			if err != nil {
+				t.Errorf("Export() error = %v", err)
+			}
+			err = exporter.Close()
+			if err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Export() got = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestJsonExporter(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		batches [][]*Mail
+		want    int
+	}{
+		{
+			name:    "empty",
+			format:  FormatJson,
+			batches: nil,
+			want:    0,
+		},
+		{
+			name:    "batches",
+			format:  FormatJson,
+			batches: [][]*Mail{{testExportMail(), testExportMail()}, {}, {testExportMail()}},
+			want:    3,
+		},
+		{
+			name:    "lines",
+			format:  FormatJsonl,
+			batches: [][]*Mail{{testExportMail()}, {testExportMail()}},
+			want:    2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			exporter, err := NewExporter(buf, tt.format, nil, nil)
+			if err != nil {
+				t.Fatalf("NewExporter() error = %v", err)
+			}
+			for _, batch := range tt.batches {
+				err = exporter.Export(batch)
+				if err != nil {
+					t.Fatalf("Export() error = %v", err)
+				}
+			}
+			err = exporter.Close()
+			if err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			var mails []*Mail
+			if tt.format == FormatJsonl {
+				for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+					mail := &Mail{}
+					err = json.Unmarshal([]byte(line), mail)
+					if err != nil {
+						t.Fatalf("invalid json line %q: %v", line, err)
+					}
+					mails = append(mails, mail)
+				}
+			} else {
+				err = json.Unmarshal(buf.Bytes(), &mails)
+				if err != nil {
+					t.Fatalf("invalid json %q: %v", buf.String(), err)
+				}
+			}
+			if len(mails) != tt.want {
+				t.Fatalf("got %d mails, want %d", len(mails), tt.want)
+			}
+			for _, mail := range mails {
+				if !reflect.DeepEqual(mail.ToAddresses, testExportMail().ToAddresses) {
+					t.Errorf("to addresses: got %v", mail.ToAddresses)
+				}
+			}
+		})
+	}
+}
+
+func TestEmlExporter_raw(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex-eml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	original := []byte("Subject: original\r\n\r\nwith attachments\r\n")
+	withSource := testExportMail()
+	withSource.Source = &Source{Type: SourceMaildir, Path: "inbox/cur/1"}
+	withoutSource := testExportMail()
+	withoutSource.Uid = "def"
+
+	raw := func(source *Source) ([]byte, error) {
+		if source == nil {
+			return nil, ErrNoRawMessage
+		}
+		return original, nil
+	}
+	exporter, err := NewEmlExporter(dir, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = exporter.Export([]*Mail{withSource, withoutSource})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	tests := []struct {
+		mail *Mail
+		want []byte
+	}{
+		{mail: withSource, want: original},
+		{mail: withoutSource, want: MailToMessage(withoutSource)},
+	}
+	for _, tt := range tests {
+		got, err := ioutil.ReadFile(filepath.Join(dir, documentUid(tt.mail.Uid)+".eml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("mail %s: got %q, want %q", tt.mail.Uid, got, tt.want)
+		}
+	}
+}
+
+func TestValidateExport(t *testing.T) {
+	if err := ValidateExport(FormatCsv, []string{"date", "subject"}); err != nil {
+		t.Errorf("ValidateExport() error = %v", err)
+	}
+	if err := ValidateExport(FormatCsv, []string{"size"}); err == nil {
+		t.Errorf("ValidateExport() unknown column: error = nil")
+	}
+	if err := ValidateExport("pdf", nil); err == nil {
+		t.Errorf("ValidateExport() unknown format: error = nil")
+	}
+}
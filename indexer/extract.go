@@ -0,0 +1,285 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"github.com/jaytaylor/html2text"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+	"tryffel.net/go/meilindex/config"
+)
+
+// defaultDecompressLimit is the max size of decompressed data when attachment size is not limited.
+const defaultDecompressLimit = 100 * 1024 * 1024
+
+// errDecompressLimit is returned when decompressed data is larger than decompressLimit.
+var errDecompressLimit = errors.New("decompressed data too large")
+
+// decompressLimit returns max size of data decompressed from attachment, e.g. zip entry or pdf stream.
+// Max size only limits the compressed attachment, so decompressed data must be limited separately.
+func decompressLimit() int64 {
+	if config.Conf != nil && config.Conf.Attachments.MaxSize > 0 {
+		return int64(config.Conf.Attachments.MaxSize)
+	}
+	return defaultDecompressLimit
+}
+
+// readLimited reads all data from reader, or returns errDecompressLimit if there are more than limit bytes.
+func readLimited(reader io.Reader, limit int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(reader, limit+1))
+	if int64(len(data)) > limit {
+		return nil, errDecompressLimit
+	}
+	return data, err
+}
+
+// Extractor extracts plain text from attachment.
+type Extractor func(data []byte) (string, error)
+
+type extractor struct {
+	name    string
+	extract Extractor
+}
+
+var (
+	extractorLock sync.RWMutex
+	// extractors contains mime type -> extractor. Type can be a wildcard, e.g. 'text/*'.
+	extractors = map[string]extractor{}
+	// extensions are used to detect mime type when attachment has generic type, e.g. application/octet-stream.
+	extensions = map[string]string{
+		".txt":  "text/plain",
+		".csv":  "text/csv",
+		".md":   "text/markdown",
+		".html": "text/html",
+		".pdf":  "application/pdf",
+		".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		".odt":  "application/vnd.oasis.opendocument.text",
+		".ics":  "text/calendar",
+		".vcf":  "text/vcard",
+	}
+)
+
+// RegisterExtractor registers extractor for given mime types. Name is used to enable extractor in
+// config.Attachments.Types. Mime type may end with '/*' to match all subtypes. Existing extractors
+// for same types are replaced.
+func RegisterExtractor(name string, mimeTypes []string, extract Extractor) {
+	extractorLock.Lock()
+	defer extractorLock.Unlock()
+	for _, v := range mimeTypes {
+		extractors[strings.ToLower(v)] = extractor{name: name, extract: extract}
+	}
+}
+
+// ExtractorNames returns names of all registered extractors.
+func ExtractorNames() []string {
+	extractorLock.RLock()
+	defer extractorLock.RUnlock()
+	found := map[string]bool{}
+	var names []string
+	for _, v := range extractors {
+		if !found[v.name] {
+			found[v.name] = true
+			names = append(names, v.name)
+		}
+	}
+	return names
+}
+
+// findExtractor returns extractor for mime type or file name, or false if there's none.
+func findExtractor(mimeType, name string) (extractor, bool) {
+	extractorLock.RLock()
+	defer extractorLock.RUnlock()
+
+	mimeType = strings.ToLower(mimeType)
+	if detected, ok := extensions[strings.ToLower(filepath.Ext(name))]; ok {
+		if mimeType == "" || mimeType == "application/octet-stream" {
+			mimeType = detected
+		}
+	}
+
+	if e, ok := extractors[mimeType]; ok {
+		return e, true
+	}
+	if i := strings.Index(mimeType, "/"); i > 0 {
+		e, ok := extractors[mimeType[:i]+"/*"]
+		return e, ok
+	}
+	return extractor{}, false
+}
+
+// ExtractAttachmentText extracts text from all attachments that have an extractor enabled in config.Conf.Attachments
+// and that are not larger than max size. Texts are separated with an empty line.
+func ExtractAttachmentText(attachments []Attachment) string {
+	if config.Conf == nil || len(attachments) == 0 {
+		return ""
+	}
+	conf := config.Conf.Attachments
+	enabled := map[string]bool{}
+	for _, v := range conf.Types {
+		enabled[v] = true
+	}
+
+	texts := make([]string, 0, len(attachments))
+	size := 0
+	for _, attachment := range attachments {
+		if conf.MaxSize > 0 && len(attachment.Data) > conf.MaxSize {
+			logrus.Debugf("skip extracting text from attachment %s: too large (%d bytes)",
+				attachment.Name, len(attachment.Data))
+			continue
+		}
+		e, ok := findExtractor(attachment.MimeType, attachment.Name)
+		if !ok || !enabled[e.name] {
+			continue
+		}
+
+		text, err := e.extract(attachment.Data)
+		if err != nil {
+			logrus.Warningf("(skip) extract text from attachment %s: %v", attachment.Name, err)
+			continue
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		texts = append(texts, text)
+		size += len(text)
+		if conf.MaxTextSize > 0 && size >= conf.MaxTextSize {
+			break
+		}
+	}
+
+	out := strings.Join(texts, "\n\n")
+	if conf.MaxTextSize > 0 && len(out) > conf.MaxTextSize {
+		out = strings.ToValidUTF8(out[:conf.MaxTextSize], "")
+	}
+	return out
+}
+
+func extractText(data []byte) (string, error) {
+	return string(data), nil
+}
+
+func extractHtml(data []byte) (string, error) {
+	return html2text.FromString(string(data), html2text.Options{PrettyTables: false})
+}
+
+// calendarProperties are calendar (RFC 5545) and vcard (RFC 6350) properties that contain text.
+var calendarProperties = map[string]bool{
+	"SUMMARY":     true,
+	"DESCRIPTION": true,
+	"LOCATION":    true,
+	"COMMENT":     true,
+	"ORGANIZER":   true,
+	"ATTENDEE":    true,
+	"FN":          true,
+	"N":           true,
+	"NICKNAME":    true,
+	"EMAIL":       true,
+	"TEL":         true,
+	"ORG":         true,
+	"TITLE":       true,
+	"ADR":         true,
+	"NOTE":        true,
+}
+
+var calendarUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+// extractCalendar extracts text properties from iCalendar or vCard data.
+func extractCalendar(data []byte) (string, error) {
+	// unfold lines: lines starting with whitespace continue previous line.
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	var out []string
+	for _, line := range lines {
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			continue
+		}
+		params := strings.Split(line[:i], ";")
+		name := strings.ToUpper(params[0])
+		if !calendarProperties[name] {
+			continue
+		}
+
+		value := line[i+1:]
+		if name == "ORGANIZER" || name == "ATTENDEE" {
+			// value is e.g. mailto:me@example.com, common name is in parameters.
+			value = strings.TrimPrefix(strings.TrimPrefix(value, "mailto:"), "MAILTO:")
+			for _, param := range params[1:] {
+				if strings.HasPrefix(strings.ToUpper(param), "CN=") {
+					value = strings.Trim(param[3:], `"`) + " " + value
+				}
+			}
+		} else if name == "N" || name == "ADR" {
+			value = strings.Join(strings.Fields(strings.Replace(value, ";", " ", -1)), " ")
+		}
+
+		value = strings.TrimSpace(calendarUnescaper.Replace(value))
+		if value != "" {
+			out = append(out, value)
+		}
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func init() {
+	RegisterExtractor("text", []string{"text/*"}, extractText)
+	RegisterExtractor("text", []string{"text/html"}, extractHtml)
+	RegisterExtractor("pdf", []string{"application/pdf"}, extractPdf)
+	RegisterExtractor("docx", []string{"application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		extractDocx)
+	RegisterExtractor("xlsx", []string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+		extractXlsx)
+	RegisterExtractor("odt", []string{"application/vnd.oasis.opendocument.text"}, extractOdt)
+	RegisterExtractor("calendar", []string{"text/calendar", "application/ics"}, extractCalendar)
+	RegisterExtractor("vcard", []string{"text/vcard", "text/x-vcard", "text/directory"}, extractCalendar)
+}
+
+// mimeType returns media type without parameters.
+func mimeType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	return mediaType
+}
@@ -0,0 +1,159 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// zipFile reads file from zip archive. Files larger than decompressLimit are not read.
+func zipFile(archive *zip.Reader, name string) ([]byte, error) {
+	for _, file := range archive.File {
+		if file.Name == name {
+			fd, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer fd.Close()
+			data, err := readLimited(fd, decompressLimit())
+			if err != nil {
+				return nil, fmt.Errorf("read %s: %v", name, err)
+			}
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("file %s not found", name)
+}
+
+// xmlText returns character data from xml document. Paragraphs are elements that end a line.
+// If elements is not empty, only text inside these elements is returned. Element names are local names
+// without namespace.
+func xmlText(data []byte, paragraphs map[string]bool, elements map[string]bool) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	builder := strings.Builder{}
+	depth := 0
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return builder.String(), err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if elements[t.Name.Local] {
+				depth += 1
+			}
+		case xml.EndElement:
+			if elements[t.Name.Local] {
+				depth -= 1
+			}
+			if paragraphs[t.Name.Local] {
+				builder.WriteString("\n")
+			}
+		case xml.CharData:
+			if len(elements) == 0 || depth > 0 {
+				builder.Write(t)
+			}
+		}
+	}
+	return builder.String(), nil
+}
+
+func openZip(data []byte) (*zip.Reader, error) {
+	return zip.NewReader(bytes.NewReader(data), int64(len(data)))
+}
+
+// extractDocx extracts text from Word document.
+func extractDocx(data []byte) (string, error) {
+	archive, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+	document, err := zipFile(archive, "word/document.xml")
+	if err != nil {
+		return "", err
+	}
+	return xmlText(document, map[string]bool{"p": true, "tr": true}, map[string]bool{"t": true})
+}
+
+// extractOdt extracts text from OpenDocument text document.
+func extractOdt(data []byte) (string, error) {
+	archive, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+	content, err := zipFile(archive, "content.xml")
+	if err != nil {
+		return "", err
+	}
+	return xmlText(content, map[string]bool{"p": true, "h": true}, map[string]bool{"p": true, "h": true})
+}
+
+// extractXlsx extracts text cells from Excel workbook. Numbers are not extracted.
+func extractXlsx(data []byte) (string, error) {
+	archive, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+
+	var texts []string
+	// most strings are stored in shared strings.
+	if shared, err := zipFile(archive, "xl/sharedStrings.xml"); err == nil {
+		text, err := xmlText(shared, map[string]bool{"si": true}, map[string]bool{"t": true})
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, text)
+	}
+
+	var sheets []string
+	for _, file := range archive.File {
+		if path.Dir(file.Name) == "xl/worksheets" && strings.HasSuffix(file.Name, ".xml") {
+			sheets = append(sheets, file.Name)
+		}
+	}
+	sort.Strings(sheets)
+
+	// inline strings
+	for _, name := range sheets {
+		sheet, err := zipFile(archive, name)
+		if err != nil {
+			return "", err
+		}
+		text, err := xmlText(sheet, map[string]bool{"is": true}, map[string]bool{"is": true})
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
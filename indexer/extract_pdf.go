@@ -0,0 +1,227 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// extractPdf extracts text from pdf. This is a naive implementation: only text from uncompressed and
+// Flate-compressed content streams is extracted, and fonts with custom encodings are not supported.
+// Still, it works with most simple documents. Documents whose decompressed streams are larger than
+// decompressLimit in total are skipped.
+func extractPdf(data []byte) (string, error) {
+	builder := strings.Builder{}
+	offset := 0
+	remaining := decompressLimit()
+	for {
+		start := bytes.Index(data[offset:], []byte("stream"))
+		if start == -1 {
+			break
+		}
+		start += offset
+		// skip 'endstream'
+		if start >= 3 && string(data[start-3:start]) == "end" {
+			offset = start + len("stream")
+			continue
+		}
+
+		dictStart := bytes.LastIndex(data[offset:start], []byte("obj"))
+		dict := data[offset:start]
+		if dictStart != -1 {
+			dict = data[offset+dictStart : start]
+		}
+
+		contentStart := start + len("stream")
+		if contentStart < len(data) && data[contentStart] == '\r' {
+			contentStart++
+		}
+		if contentStart < len(data) && data[contentStart] == '\n' {
+			contentStart++
+		}
+		end := bytes.Index(data[contentStart:], []byte("endstream"))
+		if end == -1 {
+			break
+		}
+		content := data[contentStart : contentStart+end]
+		offset = contentStart + end + len("endstream")
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			reader, err := zlib.NewReader(bytes.NewReader(content))
+			if err != nil {
+				continue
+			}
+			// partially corrupted streams may still contain text
+			content, err = readLimited(reader, remaining)
+			reader.Close()
+			if err == errDecompressLimit {
+				return "", fmt.Errorf("pdf streams: %v", err)
+			}
+			remaining -= int64(len(content))
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			// images and other encodings
+			continue
+		}
+		builder.WriteString(pdfContentText(content))
+	}
+
+	return strings.TrimSpace(strings.Join(strings.FieldsFunc(builder.String(), func(r rune) bool {
+		return r == '\n'
+	}), "\n")), nil
+}
+
+// pdfContentText returns text shown with text operators in content stream.
+func pdfContentText(content []byte) string {
+	builder := strings.Builder{}
+	var operands []string
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == '(':
+			text, next := pdfLiteral(content, i)
+			operands = append(operands, text)
+			i = next
+		case c == '<' && i+1 < len(content) && content[i+1] == '<':
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end == -1 {
+				return builder.String()
+			}
+			operands = append(operands, pdfHex(content[i+1:i+end]))
+			i += end + 1
+		case c == '%':
+			end := bytes.IndexAny(content[i:], "\r\n")
+			if end == -1 {
+				return builder.String()
+			}
+			i += end
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '\'' || c == '"':
+			start := i
+			for i < len(content) && (content[i] >= 'A' && content[i] <= 'Z' || content[i] >= 'a' &&
+				content[i] <= 'z' || content[i] == '*' || content[i] == '\'' || content[i] == '"') {
+				i++
+			}
+			switch string(content[start:i]) {
+			case "Tj", "TJ":
+				builder.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				builder.WriteString("\n" + strings.Join(operands, ""))
+			case "T*", "ET":
+				builder.WriteString("\n")
+			case "Td", "TD", "Tm":
+				builder.WriteString(" ")
+			}
+			operands = operands[:0]
+		default:
+			i++
+		}
+	}
+	return builder.String()
+}
+
+// pdfLiteral parses literal string starting at content[start], which is '('.
+// Returns string and index after string.
+func pdfLiteral(content []byte, start int) (string, int) {
+	var out []byte
+	depth := 0
+	i := start
+	for ; i < len(content); i++ {
+		c := content[i]
+		if c == '\\' && i+1 < len(content) {
+			i++
+			switch e := content[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					value := 0
+					for j := 0; j < 3 && i < len(content) && content[i] >= '0' && content[i] <= '7'; j++ {
+						value = value*8 + int(content[i]-'0')
+						i++
+					}
+					i--
+					out = append(out, byte(value))
+				} else {
+					out = append(out, e)
+				}
+			}
+			continue
+		}
+		if c == '(' {
+			depth++
+			if depth == 1 {
+				continue
+			}
+		} else if c == ')' {
+			depth--
+			if depth == 0 {
+				return pdfString(out), i + 1
+			}
+		}
+		out = append(out, c)
+	}
+	return pdfString(out), i
+}
+
+// pdfHex decodes hex string.
+func pdfHex(data []byte) string {
+	data = bytes.Join(bytes.Fields(data), nil)
+	if len(data)%2 == 1 {
+		data = append(data, '0')
+	}
+	decoded := make([]byte, hex.DecodedLen(len(data)))
+	_, err := hex.Decode(decoded, data)
+	if err != nil {
+		return ""
+	}
+	return pdfString(decoded)
+}
+
+// pdfString decodes UTF-16BE string (with byte order mark) or PDFDocEncoding string, which is mostly latin1.
+func pdfString(data []byte) string {
+	if len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff {
+		data = data[2:]
+		chars := make([]uint16, len(data)/2)
+		for i := range chars {
+			chars[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		}
+		return string(utf16.Decode(chars))
+	}
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
@@ -0,0 +1,233 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+	"tryffel.net/go/meilindex/config"
+)
+
+// zipArchive creates zip archive with given files.
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	writer := zip.NewWriter(buf)
+	for name, content := range files {
+		fd, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = fd.Write([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := writer.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func pdfDocument(t *testing.T, content string, compress bool) []byte {
+	stream := []byte(content)
+	filter := ""
+	if compress {
+		buf := &bytes.Buffer{}
+		writer := zlib.NewWriter(buf)
+		writer.Write(stream)
+		writer.Close()
+		stream = buf.Bytes()
+		filter = " /Filter /FlateDecode"
+	}
+	return []byte(fmt.Sprintf("%%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n"+
+		"4 0 obj\n<< /Length %d%s >>\nstream\n%s\nendstream\nendobj\n%%%%EOF\n", len(stream), filter, stream))
+}
+
+func TestExtractors(t *testing.T) {
+	pdfContent := "BT /F1 12 Tf 72 712 Td (Quarterly \\(draft\\) report) Tj T* [(Re) -20 (venue)] TJ ET\n" +
+		"BT <FEFF00E4006C006C00E4> Tj ET"
+
+	tests := []struct {
+		name     string
+		mimeType string
+		file     string
+		data     []byte
+		want     []string
+	}{
+		{
+			name:     "plain text",
+			mimeType: "text/plain",
+			data:     []byte("meeting agenda"),
+			want:     []string{"meeting agenda"},
+		},
+		{
+			name:     "html",
+			mimeType: "text/html",
+			data:     []byte("<html><body><p>hello <b>world</b></p></body></html>"),
+			want:     []string{"hello", "world"},
+		},
+		{
+			name:     "calendar",
+			mimeType: "text/calendar",
+			data: []byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Project kickoff\r\n" +
+				"DESCRIPTION:Discuss budget\\, schedule\r\n  and risks\r\nLOCATION:Room 4\r\n" +
+				"ORGANIZER;CN=\"Alice Example\":mailto:alice@example.com\r\nDTSTART:20210201T100000Z\r\n" +
+				"END:VEVENT\r\nEND:VCALENDAR\r\n"),
+			want: []string{"Project kickoff", "Discuss budget, schedule and risks", "Room 4",
+				"Alice Example alice@example.com"},
+		},
+		{
+			name: "vcard",
+			file: "contact.vcf",
+			data: []byte("BEGIN:VCARD\nVERSION:3.0\nFN:Bob Builder\nN:Builder;Bob;;;\nTEL;TYPE=work:+358 40 123\n" +
+				"ORG:Construction Ltd\nEND:VCARD\n"),
+			want: []string{"Bob Builder", "Builder Bob", "+358 40 123", "Construction Ltd"},
+		},
+		{
+			name:     "docx",
+			mimeType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+			data: zipArchive(t, map[string]string{"word/document.xml": `<?xml version="1.0"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>First </w:t></w:r><w:r><w:t>paragraph</w:t></w:r></w:p>
+<w:p><w:r><w:t>Second paragraph</w:t></w:r></w:p></w:body></w:document>`}),
+			want: []string{"First paragraph\n", "Second paragraph"},
+		},
+		{
+			name:     "odt as octet stream",
+			mimeType: "application/octet-stream",
+			file:     "notes.odt",
+			data: zipArchive(t, map[string]string{"content.xml": `<?xml version="1.0"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" 
+xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0"><office:body><office:text>
+<text:h>Title</text:h><text:p>Body text</text:p></office:text></office:body></office:document-content>`}),
+			want: []string{"Title\n", "Body text"},
+		},
+		{
+			name:     "xlsx",
+			mimeType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+			data: zipArchive(t, map[string]string{
+				"xl/sharedStrings.xml": `<sst><si><t>Invoice</t></si><si><t>Total</t></si></sst>`,
+				"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row><c t="s"><v>0</v></c>` +
+					`<c t="inlineStr"><is><t>Inline cell</t></is></c></row></sheetData></worksheet>`,
+			}),
+			want: []string{"Invoice", "Total", "Inline cell"},
+		},
+		{
+			name:     "pdf",
+			mimeType: "application/pdf",
+			data:     pdfDocument(t, pdfContent, false),
+			want:     []string{"Quarterly (draft) report", "Revenue", "ällä"},
+		},
+		{
+			name:     "compressed pdf",
+			mimeType: "application/pdf",
+			data:     pdfDocument(t, pdfContent, true),
+			want:     []string{"Quarterly (draft) report", "Revenue", "ällä"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := findExtractor(tt.mimeType, tt.file)
+			if !ok {
+				t.Fatalf("no extractor found for %s", tt.mimeType)
+			}
+			got, err := e.extract(tt.data)
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("extracted text %q does not contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractAttachmentText(t *testing.T) {
+	defer func(conf *config.Config) { config.Conf = conf }(config.Conf)
+	config.Conf = &config.Config{Attachments: config.Attachments{
+		Types:       []string{"text", "custom"},
+		MaxSize:     20,
+		MaxTextSize: 27,
+	}}
+	RegisterExtractor("custom", []string{"application/x-custom"}, func(data []byte) (string, error) {
+		return strings.ToUpper(string(data)), nil
+	})
+
+	attachments := []Attachment{
+		{Name: "a.txt", MimeType: "text/plain", Data: []byte("first")},
+		{Name: "b.pdf", MimeType: "application/pdf", Data: pdfDocument(t, "BT (disabled) Tj ET", false)},
+		{Name: "c.txt", MimeType: "text/plain", Data: []byte("this attachment is too large")},
+		{Name: "d.bin", MimeType: "application/x-custom", Data: []byte("custom")},
+		{Name: "e.bin", MimeType: "application/zip", Data: []byte("unknown")},
+		{Name: "f.txt", MimeType: "text/plain", Data: []byte("truncated text")},
+	}
+
+	got := ExtractAttachmentText(attachments)
+	want := "first\n\nCUSTOM\n\ntruncated te"
+	if got != want {
+		t.Errorf("ExtractAttachmentText() = %q, want %q", got, want)
+	}
+}
+
+func TestExtractDecompressLimit(t *testing.T) {
+	defer func(conf *config.Config) { config.Conf = conf }(config.Conf)
+	config.Conf = &config.Config{Attachments: config.Attachments{MaxSize: 1000}}
+
+	// compresses to a few hundred bytes, but is much larger than limit when decompressed
+	large := "BT (" + strings.Repeat("a", 5000) + ") Tj ET"
+	small := "BT (hello) Tj ET"
+	docx := func(text string) string {
+		return `<w:document><w:body><w:p><w:r><w:t>` + text + `</w:t></w:r></w:p></w:body></w:document>`
+	}
+
+	tests := []struct {
+		name    string
+		extract Extractor
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "pdf", extract: extractPdf, data: pdfDocument(t, small, true), want: "hello"},
+		{name: "pdf too large", extract: extractPdf, data: pdfDocument(t, large, true), wantErr: true},
+		{name: "docx", extract: extractDocx, data: zipArchive(t, map[string]string{"word/document.xml": docx("hello")}),
+			want: "hello"},
+		{name: "docx too large", extract: extractDocx, wantErr: true,
+			data: zipArchive(t, map[string]string{"word/document.xml": docx(strings.Repeat("a", 5000))})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.extract(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.TrimSpace(got) != tt.want {
+				t.Errorf("extract() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
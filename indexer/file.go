@@ -27,12 +27,16 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"tryffel.net/go/meilindex/config"
 	"tryffel.net/go/meilindex/external"
 )
 
-// ReadFiles reads files and flushes batched mails to flushFunc
-func ReadFiles(file string, recursive bool, flushFunc func(mails []*Mail) error) ([]*Mail, error) {
+// ReadFiles reads files and flushes batched mails to flushFunc. If doneFunc is not nil, it is called with
+// folder of every file that was read to the end without errors, including empty files.
+func ReadFiles(file string, recursive bool, flushFunc func(mails []*Mail) error,
+	doneFunc func(folder string)) ([]*Mail, error) {
 	var files []external.MboxFile
 	var err error
 	if recursive {
@@ -69,6 +73,9 @@ func ReadFiles(file string, recursive bool, flushFunc func(mails []*Mail) error)
 			logrus.Error(err)
 			continue
 		}
+		if doneFunc != nil {
+			doneFunc(v.Name)
+		}
 		totalMails += indexed
 		mails = append(mails, mail...)
 		if len(mails) >= batchSize {
@@ -90,6 +97,13 @@ func ReadFiles(file string, recursive bool, flushFunc func(mails []*Mail) error)
 }
 
 func readFile(file, folder string, flushFunc func(mails []*Mail) error) ([]*Mail, int, error) {
+	mails, total, _, err := readFileFrom(file, folder, 0, flushFunc)
+	return mails, total, err
+}
+
+// readFileFrom reads mbox file from offset start, which must be at beginning of a message. In addition to
+// readFile, returns offset of the last message.
+func readFileFrom(file, folder string, start int64, flushFunc func(mails []*Mail) error) ([]*Mail, int, int64, error) {
 	batchSize := 1000
 	batch := 0
 	currentBatchSize := 0
@@ -97,7 +111,21 @@ func readFile(file, folder string, flushFunc func(mails []*Mail) error) ([]*Mail
 
 	fd, err := os.Open(file)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, start, err
+	}
+	_, err = fd.Seek(start, io.SeekStart)
+	if err != nil {
+		fd.Close()
+		return nil, 0, start, err
+	}
+
+	path, err := filepath.Abs(file)
+	if err != nil {
+		path = file
+	}
+	offsets, last, err := mboxOffsets(file, start)
+	if err != nil {
+		logrus.Warningf("find message offsets in %s: %v", file, err)
 	}
 
 	reader := mbox.NewReader(fd)
@@ -111,7 +139,8 @@ func readFile(file, folder string, flushFunc func(mails []*Mail) error) ([]*Mail
 			if err == io.EOF {
 				break
 			}
-			return mails, 0, err
+			fd.Close()
+			return mails, 0, last, err
 		}
 
 		if msg == nil {
@@ -125,6 +154,11 @@ func readFile(file, folder string, flushFunc func(mails []*Mail) error) ([]*Mail
 			var m *Mail
 			m, err = mailToMail(parsed)
 			m.Folder = folder
+			m.Flags = mboxFlags(parsed.Header)
+			m.Source = &Source{Type: SourceMbox, Path: path, Offset: -1}
+			if offset, ok := offsets[m.Id]; ok {
+				m.Source.Offset = offset
+			}
 
 			mails = append(mails, m)
 			currentBatchSize += 1
@@ -150,5 +184,59 @@ func readFile(file, folder string, flushFunc func(mails []*Mail) error) ([]*Mail
 		logrus.Infof("Flushed %d batches, %d mails", batch, totalMails)
 	}
 
-	return mails, totalMails, nil
+	return mails, totalMails, last, nil
+}
+
+// Thunderbird X-Mozilla-Status flags
+const (
+	mozillaRead      = 0x0001
+	mozillaReplied   = 0x0002
+	mozillaMarked    = 0x0004
+	mozillaExpunged  = 0x0008
+	mozillaForwarded = 0x1000
+)
+
+// mboxFlags parses mail flags from Thunderbird's X-Mozilla-Status header, or from Status and X-Status headers
+// used by e.g. mutt.
+func mboxFlags(h mail.Header) []string {
+	flags := []string{}
+	if status := h.Get("X-Mozilla-Status"); status != "" {
+		value, err := strconv.ParseUint(strings.TrimSpace(status), 16, 32)
+		if err != nil {
+			logrus.Debugf("parse X-Mozilla-Status '%s': %v", status, err)
+			return flags
+		}
+		for _, v := range []struct {
+			mask uint64
+			flag string
+		}{
+			{mozillaRead, FlagSeen},
+			{mozillaReplied, FlagAnswered},
+			{mozillaMarked, FlagFlagged},
+			{mozillaExpunged, FlagDeleted},
+			{mozillaForwarded, FlagPassed},
+		} {
+			if value&v.mask != 0 {
+				flags = append(flags, v.flag)
+			}
+		}
+		return flags
+	}
+
+	status := h.Get("Status") + h.Get("X-Status")
+	for _, v := range []struct {
+		char string
+		flag string
+	}{
+		{"R", FlagSeen},
+		{"A", FlagAnswered},
+		{"F", FlagFlagged},
+		{"T", FlagDraft},
+		{"D", FlagDeleted},
+	} {
+		if strings.Contains(status, v.char) {
+			flags = append(flags, v.flag)
+		}
+	}
+	return flags
 }
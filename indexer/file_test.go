@@ -0,0 +1,77 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"github.com/emersion/go-message/mail"
+	"reflect"
+	"testing"
+)
+
+func Test_mboxFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    []string
+	}{
+		{
+			name:    "no headers",
+			headers: map[string]string{},
+			want:    []string{},
+		},
+		{
+			name:    "thunderbird unread",
+			headers: map[string]string{"X-Mozilla-Status": "0000"},
+			want:    []string{},
+		},
+		{
+			name:    "thunderbird read and starred",
+			headers: map[string]string{"X-Mozilla-Status": "0005"},
+			want:    []string{FlagSeen, FlagFlagged},
+		},
+		{
+			name:    "thunderbird replied and forwarded",
+			headers: map[string]string{"X-Mozilla-Status": "1003"},
+			want:    []string{FlagSeen, FlagAnswered, FlagPassed},
+		},
+		{
+			name:    "mutt status",
+			headers: map[string]string{"Status": "RO", "X-Status": "AF"},
+			want:    []string{FlagSeen, FlagAnswered, FlagFlagged},
+		},
+		{
+			name:    "invalid mozilla status",
+			headers: map[string]string{"X-Mozilla-Status": "xyz", "Status": "RO"},
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var h mail.Header
+			for key, value := range tt.headers {
+				h.Set(key, value)
+			}
+			if got := mboxFlags(h); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mboxFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
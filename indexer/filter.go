@@ -21,18 +21,26 @@ package indexer
 
 import (
 	"fmt"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
-)
-
-var (
-	filterAfter     = regexp.MustCompile(`after=([0-9-]+)`)
-	filterBefore    = regexp.MustCompile(`before=([0-9-]+)`)
-	filterTimeRange = regexp.MustCompile(`time=\"([0-9-]+):([0-9-]+)\"`)
+	"unicode"
+	"unicode/utf8"
 )
 
 // Filter is structured filter from user to meilisearch.
+//
+// Filter language consists of conditions 'field=value', combined with AND, OR and NOT and grouped with parentheses.
+// Conditions next to each other without operator are combined with AND. Values can be quoted with " or '.
+// Supported operators are =, != and, for dates, >, >=, < and <=. In addition to indexed fields,
+// following special conditions are supported:
+//
+//	after=<date>, before=<date>: mails after / before date
+//	time="<date>:<date>": mails between dates
+//	has:attachment: mails with attachments
+//
+// Dates are either absolute: 2020, 2020-01 or 2020-01-02, or relative to current time:
+// today, yesterday, -7d (days), -2w (weeks), -3m (months), -1y (years).
 type Filter struct {
 	query string
 
@@ -40,52 +48,559 @@ type Filter struct {
 	Before time.Time
 }
 
-// NewFilter parses human-formatted filter into meilisearch filter.
-func NewFilter(query string) *Filter {
-	f := &Filter{
-		query: query,
+// FilterError is a syntax error in filter.
+type FilterError struct {
+	Query string
+	// Position is byte offset of error in query.
+	Position int
+	Message  string
+}
+
+// Column returns character position of error, starting from 0.
+func (e *FilterError) Column() int {
+	if e.Position > len(e.Query) {
+		return utf8.RuneCountInString(e.Query)
+	}
+	return utf8.RuneCountInString(e.Query[:e.Position])
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Column()+1)
+}
+
+// Caret returns query and a caret pointing to error position on the next line.
+func (e *FilterError) Caret() string {
+	return e.Query + "\n" + strings.Repeat(" ", e.Column()) + "^"
+}
+
+// NewFilter parses human-formatted filter into meilisearch filter. Relative dates are relative to current time.
+func NewFilter(query string) (*Filter, error) {
+	return ParseFilter(query, time.Now())
+}
+
+// ParseFilter parses human-formatted filter into meilisearch filter. Relative dates are relative to now.
+func ParseFilter(query string, now time.Time) (*Filter, error) {
+	tokens, err := tokenizeFilter(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{
+		query:  query,
+		tokens: tokens,
+		now:    now,
+		filter: &Filter{},
+	}
+	if p.peek().kind == tokenEnd {
+		return p.filter, nil
+	}
+	out, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := p.peek(); token.kind != tokenEnd {
+		return nil, p.errorf(token, "unexpected '%s'", token.text)
+	}
+	p.filter.query = out.text
+	return p.filter, nil
+}
+
+func (f *Filter) Query() string {
+	return f.query
+}
+
+type filterFieldType int
+
+const (
+	fieldString filterFieldType = iota
+	fieldBool
+	fieldDate
+)
+
+// filterFields are fields that can be used in filter.
+var filterFields = map[string]filterFieldType{
+	"account":        fieldString,
+	"folder":         fieldString,
+	"from":           fieldString,
+	"to":             fieldString,
+	"cc":             fieldString,
+	"bcc":            fieldString,
+	"from_address":   fieldString,
+	"to_address":     fieldString,
+	"cc_address":     fieldString,
+	"bcc_address":    fieldString,
+	"subject":        fieldString,
+	"message":        fieldString,
+	"id":             fieldString,
+	"uid":            fieldString,
+	"thread_id":      fieldString,
+	"in_reply_to":    fieldString,
+	"flags":          fieldString,
+	"attachments":    fieldString,
+	"seen":           fieldBool,
+	"flagged":        fieldBool,
+	"answered":       fieldBool,
+	"draft":          fieldBool,
+	"has_attachment": fieldBool,
+	"date":           fieldDate,
+}
+
+// filterAliases are alternative names for filterFields.
+var filterAliases = map[string]string{
+	"body":       "message",
+	"sender":     "from",
+	"recipient":  "to",
+	"mailbox":    "folder",
+	"dir":        "folder",
+	"flag":       "flags",
+	"tag":        "flags",
+	"thread":     "thread_id",
+	"message_id": "id",
+	"read":       "seen",
+	"starred":    "flagged",
+	"replied":    "answered",
+	"attachment": "attachments",
+}
+
+// special filter fields that are not indexed as such.
+const (
+	filterAfter  = "after"
+	filterBefore = "before"
+	filterTime   = "time"
+	filterHas    = "has"
+)
+
+type filterTokenKind int
+
+const (
+	tokenEnd filterTokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenOpen
+	tokenClose
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	// text is the value without quotes for strings.
+	text     string
+	position int
+}
+
+// tokenizeFilter splits filter into words, quoted strings, operators and parentheses.
+func tokenizeFilter(query string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: tokenOpen, text: "(", position: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: tokenClose, text: ")", position: i})
+			i++
+		case c == '=' || c == '<' || c == '>' || c == '!':
+			op := string(c)
+			if i+1 < len(query) && query[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &FilterError{Query: query, Position: i, Message: "expected '!='"}
+			}
+			tokens = append(tokens, filterToken{kind: tokenOperator, text: op, position: i})
+			i += len(op)
+		case c == '"' || c == '\'':
+			text, end, ok := readQuoted(query, i)
+			if !ok {
+				return nil, &FilterError{Query: query, Position: i, Message: "missing closing quote"}
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: text, position: i})
+			i = end
+		default:
+			start := i
+			for i < len(query) && !strings.ContainsRune(" \t\n()=<>!\"'", rune(query[i])) {
+				i++
+			}
+			tokens = append(tokens, filterToken{kind: tokenWord, text: query[start:i], position: start})
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEnd, text: "end of filter", position: len(query)}), nil
+}
+
+// readQuoted reads quoted string starting at query[start]. Backslash escapes next character.
+// Returns unquoted text and position after closing quote.
+func readQuoted(query string, start int) (string, int, bool) {
+	quote := query[start]
+	text := strings.Builder{}
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if i+1 < len(query) {
+				i++
+				text.WriteByte(query[i])
+			}
+		case quote:
+			return text.String(), i + 1, true
+		default:
+			text.WriteByte(query[i])
+		}
+	}
+	return "", 0, false
+}
+
+// filterExpr is parsed part of filter, formatted for Meilisearch.
+type filterExpr struct {
+	text string
+	// compound is true if text consists of several conditions joined with AND or OR,
+	// and must be grouped with parentheses when negated.
+	compound bool
+}
+
+type filterParser struct {
+	query  string
+	tokens []filterToken
+	pos    int
+	now    time.Time
+	filter *Filter
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+// keyword returns true if token is given keyword (AND, OR, NOT), case-insensitive.
+func (p *filterParser) keyword(token filterToken, keyword string) bool {
+	return token.kind == tokenWord && strings.EqualFold(token.text, keyword)
+}
+
+func (p *filterParser) errorf(token filterToken, format string, args ...interface{}) error {
+	return &FilterError{Query: p.query, Position: token.position, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseOr parses: and (OR and)*
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return left, err
+	}
+	for p.keyword(p.peek(), "OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return right, err
+		}
+		left = filterExpr{text: left.text + " OR " + right.text, compound: true}
+	}
+	return left, nil
+}
+
+// parseAnd parses: not ([AND] not)*
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return left, err
+	}
+	for {
+		token := p.peek()
+		if p.keyword(token, "AND") {
+			p.next()
+		} else if token.kind == tokenEnd || token.kind == tokenClose || p.keyword(token, "OR") {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return right, err
+		}
+		left = filterExpr{text: left.text + " AND " + right.text, compound: true}
 	}
+}
+
+// parseNot parses: NOT not | primary
+func (p *filterParser) parseNot() (filterExpr, error) {
+	if p.keyword(p.peek(), "NOT") {
+		p.next()
+		expr, err := p.parseNot()
+		if err != nil {
+			return expr, err
+		}
+		if expr.compound {
+			expr.text = "(" + expr.text + ")"
+		}
+		return filterExpr{text: "NOT " + expr.text}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses: '(' or ')' | condition
+func (p *filterParser) parsePrimary() (filterExpr, error) {
+	token := p.next()
+	switch {
+	case token.kind == tokenOpen:
+		if p.peek().kind == tokenClose {
+			return filterExpr{}, p.errorf(p.peek(), "empty parentheses")
+		}
+		expr, err := p.parseOr()
+		if err != nil {
+			return expr, err
+		}
+		if closing := p.next(); closing.kind != tokenClose {
+			return expr, p.errorf(closing, "expected ')'")
+		}
+		return filterExpr{text: "(" + expr.text + ")"}, nil
+	case token.kind == tokenWord && !p.keyword(token, "AND") && !p.keyword(token, "OR"):
+		return p.parseCondition(token)
+	case token.kind == tokenEnd:
+		return filterExpr{}, p.errorf(token, "expected condition")
+	default:
+		return filterExpr{}, p.errorf(token, "expected condition, got '%s'", token.text)
+	}
+}
 
-	// find either time range or after & before
-	if match := filterTimeRange.FindAllStringSubmatch(query, 2); len(match) > 0 {
-		f.After = parseDate(match[0][1])
-		f.Before = parseDate(match[0][2])
-		out := fmt.Sprintf("date>%d AND date<%d", f.After.Unix(), f.Before.Unix())
-		f.query = filterTimeRange.ReplaceAllString(f.query, out)
+// parseCondition parses: field operator value | field:value
+func (p *filterParser) parseCondition(field filterToken) (filterExpr, error) {
+	name := field.text
+	operator := filterToken{kind: tokenOperator, text: "=", position: field.position}
+	var value filterToken
+
+	if p.peek().kind == tokenOperator {
+		operator = p.next()
+		value = p.next()
+		if value.kind != tokenWord && value.kind != tokenString {
+			return filterExpr{}, p.errorf(value, "expected value after '%s%s'", name, operator.text)
+		}
+	} else if i := strings.Index(name, ":"); i > 0 && i < len(name)-1 {
+		// field:value, e.g. has:attachment
+		value = filterToken{kind: tokenWord, text: name[i+1:], position: field.position + i + 1}
+		name = name[:i]
 	} else {
-		if match := filterAfter.FindStringSubmatch(query); len(match) > 0 {
-			f.After = parseDate(match[1])
-			out := fmt.Sprintf("date>%d", f.After.Unix())
-			f.query = filterAfter.ReplaceAllString(f.query, out)
+		return filterExpr{}, p.errorf(p.peek(), "expected operator after '%s'", field.text)
+	}
+
+	name = strings.ToLower(name)
+	if alias, ok := filterAliases[name]; ok {
+		name = alias
+	}
+
+	switch name {
+	case filterAfter, filterBefore:
+		if operator.text != "=" {
+			return filterExpr{}, p.errorf(operator, "'%s' only supports '='", name)
+		}
+		start, _, err := p.parseDate(value, value.text)
+		if err != nil {
+			return filterExpr{}, err
+		}
+		if name == filterAfter {
+			p.filter.After = start
+			return filterExpr{text: fmt.Sprintf("date>%d", start.Unix())}, nil
+		}
+		p.filter.Before = start
+		return filterExpr{text: fmt.Sprintf("date<%d", start.Unix())}, nil
+	case filterTime:
+		if operator.text != "=" {
+			return filterExpr{}, p.errorf(operator, "'time' only supports '='")
+		}
+		parts := strings.SplitN(value.text, ":", 2)
+		if len(parts) != 2 {
+			return filterExpr{}, p.errorf(value, "expected time range '<date>:<date>'")
+		}
+		after, _, err := p.parseDate(value, parts[0])
+		if err != nil {
+			return filterExpr{}, err
+		}
+		before, _, err := p.parseDate(value, parts[1])
+		if err != nil {
+			return filterExpr{}, err
+		}
+		p.filter.After = after
+		p.filter.Before = before
+		return filterExpr{text: fmt.Sprintf("date>%d AND date<%d", after.Unix(), before.Unix()), compound: true}, nil
+	case filterHas:
+		if operator.text != "=" || !(value.text == "attachment" || value.text == "attachments") {
+			return filterExpr{}, p.errorf(value, "unknown value for 'has', expected 'has:attachment'")
+		}
+		return filterExpr{text: "has_attachment=true"}, nil
+	}
+
+	fieldType, ok := filterFields[name]
+	if !ok {
+		message := fmt.Sprintf("unknown field '%s'", field.text)
+		if suggestion := suggestFilterField(name); suggestion != "" {
+			message += fmt.Sprintf(", did you mean '%s'?", suggestion)
 		}
-		if match := filterBefore.FindStringSubmatch(query); len(match) > 0 {
-			f.Before = parseDate(match[1])
-			out := fmt.Sprintf("date<%d", f.Before.Unix())
-			f.query = filterBefore.ReplaceAllString(f.query, out)
+		return filterExpr{}, &FilterError{Query: p.query, Position: field.position, Message: message}
+	}
+
+	switch fieldType {
+	case fieldBool:
+		if operator.text != "=" && operator.text != "!=" {
+			return filterExpr{}, p.errorf(operator, "'%s' only supports '=' and '!='", name)
+		}
+		v := strings.ToLower(value.text)
+		if v != "true" && v != "false" {
+			return filterExpr{}, p.errorf(value, "'%s' must be true or false", name)
+		}
+		return filterExpr{text: name + operator.text + v}, nil
+	case fieldDate:
+		start, end, err := p.parseDate(value, value.text)
+		if err != nil {
+			return filterExpr{}, err
+		}
+		switch operator.text {
+		case "=":
+			return filterExpr{text: fmt.Sprintf("date>=%d AND date<%d", start.Unix(), end.Unix()), compound: true}, nil
+		case "!=":
+			return filterExpr{text: fmt.Sprintf("(date<%d OR date>=%d)", start.Unix(), end.Unix())}, nil
+		case ">":
+			return filterExpr{text: fmt.Sprintf("date>=%d", end.Unix())}, nil
+		case ">=":
+			return filterExpr{text: fmt.Sprintf("date>=%d", start.Unix())}, nil
+		case "<":
+			return filterExpr{text: fmt.Sprintf("date<%d", start.Unix())}, nil
+		default:
+			return filterExpr{text: fmt.Sprintf("date<%d", end.Unix())}, nil
 		}
+	default:
+		if operator.text != "=" && operator.text != "!=" {
+			return filterExpr{}, p.errorf(operator, "'%s' only supports '=' and '!='", name)
+		}
+		return filterExpr{text: name + operator.text + formatFilterValue(value)}, nil
+	}
+}
+
+// formatFilterValue formats value for Meilisearch, keeping unquoted values unquoted.
+func formatFilterValue(value filterToken) string {
+	if value.kind == tokenWord {
+		return value.text
 	}
-	return f
+	return `"` + strings.ReplaceAll(value.text, `"`, `\"`) + `"`
 }
 
-// parse datetime of format 2020-01-02 or just 2020
-func parseDate(date string) time.Time {
-	format := ""
-	dashes := strings.Count(date, "-")
-	if dashes == 0 {
+// parseDate parses absolute or relative date. For absolute dates, returns start and end of the period,
+// e.g. a whole month for '2020-01'. For relative dates, start and end are the same, except for
+// 'today' and 'yesterday'.
+func (p *filterParser) parseDate(token filterToken, date string) (time.Time, time.Time, error) {
+	now := p.now
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch strings.ToLower(date) {
+	case "now":
+		return now, now, nil
+	case "today":
+		return today, today.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), today, nil
+	}
+
+	if len(date) >= 3 && date[0] == '-' && unicode.IsLetter(rune(date[len(date)-1])) {
+		count, err := strconv.Atoi(date[1 : len(date)-1])
+		if err == nil && count >= 0 {
+			var ts time.Time
+			switch date[len(date)-1] {
+			case 'd':
+				ts = now.AddDate(0, 0, -count)
+			case 'w':
+				ts = now.AddDate(0, 0, -7*count)
+			case 'm':
+				ts = now.AddDate(0, -count, 0)
+			case 'y':
+				ts = now.AddDate(-count, 0, 0)
+			}
+			if !ts.IsZero() {
+				return ts, ts, nil
+			}
+		}
+		return now, now, p.errorf(token, "invalid relative date '%s', expected e.g. -7d, -2w, -3m or -1y", date)
+	}
+
+	var format string
+	var start time.Time
+	var err error
+	switch strings.Count(date, "-") {
+	case 0:
 		format = "2006"
-	} else if dashes == 1 {
+	case 1:
 		format = "2006-01"
-	} else if dashes == 2 {
+	case 2:
 		format = "2006-01-02"
 	}
+	if format != "" {
+		start, err = time.Parse(format, date)
+	}
+	if format == "" || err != nil {
+		return now, now, p.errorf(token, "invalid date '%s', expected e.g. 2020-01-02, 2020-01, 2020, "+
+			"today, yesterday or -7d", date)
+	}
+	switch format {
+	case "2006":
+		return start, start.AddDate(1, 0, 0), nil
+	case "2006-01":
+		return start, start.AddDate(0, 1, 0), nil
+	default:
+		return start, start.AddDate(0, 0, 1), nil
+	}
+}
 
-	ts, err := time.Parse(format, date)
-	if err != nil {
-		return time.Unix(0, 0)
+// suggestFilterField returns closest field name or alias for misspelled name, or empty if there is none.
+func suggestFilterField(name string) string {
+	best := ""
+	bestDistance := 3
+	check := func(candidate string) {
+		distance := editDistance(name, candidate)
+		if distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best = candidate
+			bestDistance = distance
+		}
 	}
-	return ts
+	for field := range filterFields {
+		check(field)
+	}
+	for alias := range filterAliases {
+		check(alias)
+	}
+	for _, special := range []string{filterAfter, filterBefore, filterTime, filterHas} {
+		check(special)
+	}
+	return best
 }
 
-func (f *Filter) Query() string {
-	return f.query
+// editDistance returns Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
 }
@@ -2,6 +2,7 @@ package indexer
 
 import (
 	"testing"
+	"time"
 )
 
 func TestFilter_Query(t *testing.T) {
@@ -40,13 +41,138 @@ func TestFilter_Query(t *testing.T) {
 			query: `from=a AND to="b@mail.com" AND after=2020-01`,
 			want:  `from=a AND to="b@mail.com" AND date>1577836800`,
 		},
+		{
+			name:  "time-range unquoted",
+			query: `time=2020-01-01:2020-01-31`,
+			want:  `date>1577836800 AND date<1580428800`,
+		},
+		{
+			name:  "negated time-range",
+			query: `folder=inbox AND NOT time="2020-01-01:2020-01-31"`,
+			want:  `folder=inbox AND NOT (date>1577836800 AND date<1580428800)`,
+		},
+		{
+			name:  "or, not & parentheses",
+			query: `(folder=inbox or folder="Sent Items") and not from='a "b"'`,
+			want:  `(folder=inbox OR folder="Sent Items") AND NOT from="a \"b\""`,
+		},
+		{
+			name:  "implicit and, aliases",
+			query: `mailbox=inbox starred=TRUE read!=true`,
+			want:  `folder=inbox AND flagged=true AND seen!=true`,
+		},
+		{
+			name:  "has attachment",
+			query: `has:attachment AND subject="report"`,
+			want:  `has_attachment=true AND subject="report"`,
+		},
+		{
+			name:  "relative dates",
+			query: `after=-7d before=yesterday`,
+			want:  `date>1614772800 AND date<1615248000`,
+		},
+		{
+			name:  "date month",
+			query: `date=2020-01 OR date>=today`,
+			want:  `date>=1577836800 AND date<1580515200 OR date>=1615334400`,
+		},
+		{
+			name:  "empty",
+			query: `  `,
+			want:  ``,
+		},
 	}
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			f := NewFilter(tt.query)
+			f, err := ParseFilter(tt.query, now)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
 			if got := f.Query(); got != tt.want {
 				t.Errorf("Query() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestFilter_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		message  string
+		position int
+	}{
+		{
+			name:     "unknown field",
+			query:    `folder=inbox AND fron=a`,
+			message:  "unknown field 'fron', did you mean 'from'?",
+			position: 17,
+		},
+		{
+			name:     "missing quote",
+			query:    `subject="report`,
+			message:  "missing closing quote",
+			position: 8,
+		},
+		{
+			name:     "missing parenthesis",
+			query:    `(folder=inbox OR folder=sent`,
+			message:  "expected ')'",
+			position: 28,
+		},
+		{
+			name:     "missing operator",
+			query:    `folder inbox`,
+			message:  "expected operator after 'folder'",
+			position: 7,
+		},
+		{
+			name:     "trailing and",
+			query:    `folder=inbox AND`,
+			message:  "expected condition",
+			position: 16,
+		},
+		{
+			name:     "invalid bool",
+			query:    `seen=yes`,
+			message:  "'seen' must be true or false",
+			position: 5,
+		},
+		{
+			name:     "invalid date",
+			query:    `after=last-week`,
+			message:  "invalid date 'last-week', expected e.g. 2020-01-02, 2020-01, 2020, today, yesterday or -7d",
+			position: 6,
+		},
+		{
+			name:     "comparison on string",
+			query:    `from>a`,
+			message:  "'from' only supports '=' and '!='",
+			position: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFilter(tt.query, time.Now())
+			filterErr, ok := err.(*FilterError)
+			if !ok {
+				t.Fatalf("ParseFilter() error = %v, want FilterError", err)
+			}
+			if filterErr.Message != tt.message {
+				t.Errorf("Message = %s, want %s", filterErr.Message, tt.message)
+			}
+			if filterErr.Position != tt.position {
+				t.Errorf("Position = %d, want %d", filterErr.Position, tt.position)
+			}
+		})
+	}
+}
+
+func TestFilterError_Caret(t *testing.T) {
+	err := &FilterError{Query: `from="Järvinen" AND x`, Position: 20, Message: "expected operator after 'x'"}
+	want := "from=\"Järvinen\" AND x\n                   ^"
+	if got := err.Caret(); got != want {
+		t.Errorf("Caret() = %q, want %q", got, want)
+	}
+}
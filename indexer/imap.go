@@ -31,6 +31,8 @@ import (
 	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
+	"net"
+	"sort"
 	"strings"
 	"time"
 )
@@ -41,32 +43,61 @@ type Imap struct {
 	TlsSkipVerification bool
 	Username            string
 	Password            string
+	// BatchSize is the max number of mails to fetch at once.
+	BatchSize int
+	// Account is set to all fetched mails.
+	Account string
 
 	client  *client.Client
 	mailbox *imap.MailboxStatus
+	// updates is signaled when selected mailbox changes.
+	updates chan struct{}
 }
 
+// Connect connects and logs in to server. If Tls is false, connection is upgraded with STARTTLS, and
+// connecting fails if server does not support it, since credentials would be sent in plain text.
 func (i *Imap) Connect() error {
-	var err error
+	host, _, err := net.SplitHostPort(i.Url)
+	if err != nil {
+		host = i.Url
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: i.TlsSkipVerification,
+	}
+
 	if i.Tls {
-		if i.TlsSkipVerification {
-			i.client, err = client.DialTLS(i.Url, &tls.Config{
-				InsecureSkipVerify: true,
-			})
-		} else {
-			i.client, err = client.DialTLS(i.Url, nil)
+		i.client, err = client.DialTLS(i.Url, tlsConfig)
+	} else {
+		i.client, err = client.Dial(i.Url)
+		if err == nil {
+			err = i.startTls(tlsConfig)
 		}
 	}
+	if err != nil {
+		return fmt.Errorf("connect server: %v", err)
+	}
 
+	err = i.client.Login(i.Username, i.Password)
 	if err != nil {
-		err = fmt.Errorf("connect server: %v", err)
-	} else {
-		err = i.client.Login(i.Username, i.Password)
-		if err != nil {
-			err = fmt.Errorf("login: %v", err)
-		}
+		err = fmt.Errorf("login: %v", err)
 	}
+	return err
+}
 
+// startTls upgrades plain connection to tls. Connection is closed on error.
+func (i *Imap) startTls(tlsConfig *tls.Config) error {
+	supported, err := i.client.SupportStartTLS()
+	if err == nil && !supported {
+		err = fmt.Errorf("server does not support STARTTLS, set 'tls: true' to use implicit tls")
+	}
+	if err == nil {
+		err = i.client.StartTLS(tlsConfig)
+	}
+	if err != nil {
+		i.client.Logout()
+		i.client = nil
+	}
 	return err
 }
 
@@ -77,7 +108,8 @@ func (i *Imap) Disconnect() error {
 	return nil
 }
 
-func (i *Imap) Mailboxes() []string {
+// Mailboxes lists all selectable mailboxes.
+func (i *Imap) Mailboxes() ([]string, error) {
 	mailboxes := make(chan *imap.MailboxInfo, 10)
 	done := make(chan error, 1)
 	go func() {
@@ -86,14 +118,84 @@ func (i *Imap) Mailboxes() []string {
 
 	results := make([]string, 0)
 
-	num := 0
 	for m := range mailboxes {
-		results = append(results, m.Name)
-		num += 1
+		selectable := true
+		for _, attr := range m.Attributes {
+			if attr == imap.NoSelectAttr {
+				selectable = false
+			}
+		}
+		if selectable {
+			results = append(results, m.Name)
+		}
 	}
 
-	return results
+	err := <-done
+	if err != nil {
+		return results, fmt.Errorf("list mailboxes: %v", err)
+	}
+	return results, nil
+}
 
+// FilterMailboxes returns mailboxes that match any include pattern and do not match any exclude pattern.
+// Empty include list matches all mailboxes. Patterns support wildcards '*' and '?', which also match
+// hierarchy delimiters. Matching is case-insensitive.
+func FilterMailboxes(mailboxes []string, include, exclude []string) []string {
+	out := make([]string, 0, len(mailboxes))
+	for _, mailbox := range mailboxes {
+		included := len(include) == 0
+		for _, pattern := range include {
+			if matchPattern(pattern, mailbox) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			continue
+		}
+		excluded := false
+		for _, pattern := range exclude {
+			if matchPattern(pattern, mailbox) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			out = append(out, mailbox)
+		}
+	}
+	return out
+}
+
+// matchPattern matches name against simple glob pattern. Unlike path.Match, '[' has no special meaning,
+// since it's commonly used in folder names, e.g. '[Gmail]/All Mail'.
+func matchPattern(pattern, name string) bool {
+	p := []rune(strings.ToLower(pattern))
+	n := []rune(strings.ToLower(name))
+
+	// index of last '*' in pattern and position in name it was matched at
+	star, match := -1, 0
+	pi, ni := 0, 0
+	for ni < len(n) {
+		if pi < len(p) && (p[pi] == '?' || p[pi] == n[ni]) {
+			pi++
+			ni++
+		} else if pi < len(p) && p[pi] == '*' {
+			star = pi
+			match = ni
+			pi++
+		} else if star >= 0 {
+			pi = star + 1
+			match++
+			ni = match
+		} else {
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
 }
 
 func (i *Imap) SelectMailbox(name string) error {
@@ -108,48 +210,208 @@ func (i *Imap) SelectMailbox(name string) error {
 	return nil
 }
 
-func (i *Imap) FetchMail() ([]*Mail, error) {
-	messages := make(chan *imap.Message, i.mailbox.Messages)
-	done := make(chan error, 1)
+// FetchMail fetches mails from selected mailbox. Only mails that are newer than state.LastUid are fetched.
+// If mailbox uid validity differs from state, all mails are fetched. Mails are fetched in batches of
+// BatchSize and each batch is flushed to flushFunc as soon as it has been parsed. State is updated after
+// every batch that was flushed without error, so flushFunc must not return before mails are indexed.
+// Returns number of mails fetched.
+func (i *Imap) FetchMail(state *ImapFolderState, flushFunc func(mails []*Mail) error) (int, error) {
+	if state.validate(i.mailbox.UidValidity) {
+		logrus.Warningf("Uid validity changed for mailbox %s, reindexing all mails", i.mailbox.Name)
+	}
+
+	if i.mailbox.Messages == 0 {
+		return 0, nil
+	}
+
+	uids, err := i.newUids(state.LastUid)
+	if err != nil {
+		return 0, err
+	}
+	if len(uids) == 0 {
+		return 0, nil
+	}
+	logrus.Infof("Fetch %d new mails", len(uids))
+
+	batchSize := i.BatchSize
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+
+	folder := imapFolderName(i.mailbox.Name)
+	total := 0
+	for start := 0; start < len(uids); start += batchSize {
+		stop := start + batchSize
+		if stop > len(uids) {
+			stop = len(uids)
+		}
+
+		mails, err := i.fetchBatch(uids[start:stop], folder)
+		if err != nil {
+			return total, err
+		}
+
+		err = flushFunc(mails)
+		if err != nil {
+			return total, fmt.Errorf("flush mails: %v", err)
+		}
+		total += len(mails)
+		state.LastUid = uids[stop-1]
+		logrus.Infof("Fetched %d / %d mails", stop, len(uids))
+	}
+	return total, nil
+}
 
+// newUids returns sorted list of uids that are larger than lastUid.
+func (i *Imap) newUids(lastUid uint32) ([]uint32, error) {
+	// last uid: '*' matches highest uid in mailbox
 	sequence := &imap.SeqSet{}
-	start := 1
-	stop := i.mailbox.Messages
+	sequence.AddRange(lastUid+1, 0)
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = sequence
+
+	found, err := i.client.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("search uids: %v", err)
+	}
 
-	//stop = 10
+	// range n:* always returns at least the last message, even if it has been already indexed.
+	uids := make([]uint32, 0, len(found))
+	for _, uid := range found {
+		if uid > lastUid {
+			uids = append(uids, uid)
+		}
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids, nil
+}
 
-	sequence.AddRange(stop, uint32(start))
+// fetchBatch fetches and parses given uids. Messages are parsed while they are being received.
+func (i *Imap) fetchBatch(uids []uint32, folder string) ([]*Mail, error) {
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+
+	sequence := &imap.SeqSet{}
+	sequence.AddNum(uids...)
 	section := &imap.BodySectionName{}
 
 	go func() {
-		done <- i.client.Fetch(sequence, []imap.FetchItem{section.FetchItem(), imap.FetchUid}, messages)
+		done <- i.client.UidFetch(sequence, []imap.FetchItem{section.FetchItem(), imap.FetchUid, imap.FetchFlags}, messages)
 	}()
-	<-done
 
-	mails := make([]*Mail, len(messages))
-
-	folder := i.mailbox.Name
-	if folder == "INBOX" {
-		folder = "Inbox"
-	}
-
-	for i := 0; i < len(mails); i++ {
-		msg := <-messages
-		parsed, err := mail.CreateReader(msg.GetBody(section))
+	mails := make([]*Mail, 0, len(uids))
+	for msg := range messages {
+		body := msg.GetBody(section)
+		if body == nil {
+			logrus.Warningf("(skip) mail %d has no body", msg.Uid)
+			continue
+		}
+		parsed, err := mail.CreateReader(body)
 		if err != nil {
 			logrus.Errorf("parse mail: %v", err)
+			continue
 		}
 
 		m, err := mailToMail(parsed)
 		m.Folder = folder
-
-		mails[i] = m
+		m.Account = i.Account
+		m.Flags = imapFlags(msg.Flags)
+		m.Source = &Source{
+			Type:        SourceImap,
+			Account:     i.Account,
+			Offset:      -1,
+			Mailbox:     i.mailbox.Name,
+			Uid:         msg.Uid,
+			UidValidity: i.mailbox.UidValidity,
+		}
+		mails = append(mails, m)
 	}
 
+	err := <-done
+	if err != nil {
+		return mails, fmt.Errorf("fetch mails: %v", err)
+	}
 	return mails, nil
 }
 
+// CollectMailIds adds ids of all mails in selected mailbox to seen, and marks the folder complete. Ids are
+// only added if all of them were fetched successfully.
+func (i *Imap) CollectMailIds(seen *SeenMails) error {
+	folder := imapFolderName(i.mailbox.Name)
+	if i.mailbox.Messages == 0 {
+		seen.CompleteFolder(i.Account, folder)
+		return nil
+	}
+
+	messages := make(chan *imap.Message, 100)
+	done := make(chan error, 1)
+
+	sequence := &imap.SeqSet{}
+	sequence.AddRange(1, 0)
+
+	go func() {
+		done <- i.client.Fetch(sequence, []imap.FetchItem{imap.FetchEnvelope}, messages)
+	}()
+
+	ids := make([]string, 0, i.mailbox.Messages)
+	for msg := range messages {
+		if msg.Envelope == nil {
+			continue
+		}
+		// message id is stored without angle brackets
+		id := strings.TrimSuffix(strings.TrimPrefix(msg.Envelope.MessageId, "<"), ">")
+		ids = append(ids, id)
+	}
+
+	err := <-done
+	if err != nil {
+		return fmt.Errorf("fetch mail ids: %v", err)
+	}
+
+	seen.CompleteFolder(i.Account, folder)
+	for _, id := range ids {
+		seen.Add(i.Account, folder, id)
+	}
+	return nil
+}
+
+// imapFlagNames maps imap flags to mail flags.
+var imapFlagNames = map[string]string{
+	imap.SeenFlag:     FlagSeen,
+	imap.AnsweredFlag: FlagAnswered,
+	imap.FlaggedFlag:  FlagFlagged,
+	imap.DraftFlag:    FlagDraft,
+	imap.DeletedFlag:  FlagDeleted,
+	"$Forwarded":      FlagPassed,
+}
+
+// imapFlags converts imap flags to mail flags. Unknown flags are ignored.
+func imapFlags(flags []string) []string {
+	out := []string{}
+	for _, v := range flags {
+		if flag, ok := imapFlagNames[v]; ok {
+			out = append(out, flag)
+		}
+	}
+	return out
+}
+
+// imapFolderName maps imap mailbox name to Mail.Folder.
+func imapFolderName(mailbox string) string {
+	if mailbox == "INBOX" {
+		return "Inbox"
+	}
+	return mailbox
+}
+
 func mailToMail(m *mail.Reader) (*Mail, error) {
+	out, err := readMessage(m)
+	out.AttachmentText = ExtractAttachmentText(out.Attachments)
+	return out, err
+}
+
+// readMessage reads headers, body and attachments of message.
+func readMessage(m *mail.Reader) (*Mail, error) {
 	var err error
 	h := m.Header
 	date, err := h.Date()
@@ -159,22 +421,29 @@ func mailToMail(m *mail.Reader) (*Mail, error) {
 	} else {
 
 	}
-	from := h.Get("From")
-	to := h.Get("To")
-	cc := h.Get("Cc")
 	out := &Mail{
-
-		To:        stripdAddressNames(to),
-		Cc:        stripdAddressNames(cc),
-		Timestamp: date,
-		Subject:   h.Get("Subject"),
+		ToAddresses:  parseAddressList(h.Get("To")),
+		CcAddresses:  parseAddressList(h.Get("Cc")),
+		BccAddresses: parseAddressList(h.Get("Bcc")),
+		Timestamp:    date,
+		Subject:      h.Get("Subject"),
 	}
-	if address := stripdAddressNames(from); len(address) >= 1 {
-		out.From = address[0]
+	out.To = displayNames(out.ToAddresses)
+	out.Cc = displayNames(out.CcAddresses)
+	out.Bcc = displayNames(out.BccAddresses)
+	if from := parseAddressList(h.Get("From")); len(from) >= 1 {
+		out.FromAddress = from[0]
+		out.From = from[0].DisplayName()
 	}
 
 	out.Uid, err = h.MessageID()
 	out.Id, err = h.MessageID()
+	if inReplyTo, err := h.MsgIDList("In-Reply-To"); err == nil && len(inReplyTo) > 0 {
+		out.InReplyTo = inReplyTo[0]
+	}
+	if references, err := h.MsgIDList("References"); err == nil {
+		out.References = references
+	}
 
 	s, err := h.Subject()
 	if err == nil {
@@ -196,7 +465,7 @@ func mailToMail(m *mail.Reader) (*Mail, error) {
 			}
 		}
 
-		switch part.Header.(type) {
+		switch header := part.Header.(type) {
 		case *mail.InlineHeader:
 			inlineHeaders += 1
 			contentType := part.Header.Get("Content-Type")
@@ -206,8 +475,16 @@ func mailToMail(m *mail.Reader) (*Mail, error) {
 					logrus.Warningf("(skip) read plain body: %v", err)
 				} else {
 					out.Body = string(body)
+					out.Parts = append(out.Parts, MimePart{MimeType: mimeType(contentType), Size: len(body)})
 				}
 			} else {
+				b, err := ioutil.ReadAll(part.Body)
+				if err != nil {
+					logrus.Errorf("read message part: %v", err)
+					continue
+				}
+				out.Parts = append(out.Parts, MimePart{MimeType: mimeType(contentType), Size: len(b)})
+
 				// plain text already exists
 				if out.Body != "" {
 					continue
@@ -215,16 +492,14 @@ func mailToMail(m *mail.Reader) (*Mail, error) {
 
 				// accept only 1st inline header
 				if inlineHeaders == 1 {
-					out.Body, err = html2text.FromReader(part.Body, html2text.Options{
+					out.Body, err = html2text.FromString(string(b), html2text.Options{
 						PrettyTables: false,
 					})
 				} else {
-					b, err := ioutil.ReadAll(part.Body)
-					if err != nil {
-						logrus.Errorf("read message attachment: %v", err)
-					} else {
-						out.Attachments = append(out.Attachments, b)
-					}
+					out.Attachments = append(out.Attachments, Attachment{
+						MimeType: mimeType(contentType),
+						Data:     b,
+					})
 				}
 
 				if err != nil {
@@ -236,12 +511,21 @@ func mailToMail(m *mail.Reader) (*Mail, error) {
 			}
 		case *mail.AttachmentHeader:
 			contentType := part.Header.Get("Content-Type")
-			out.AttachmentNames = append(out.AttachmentNames, ParseAttachments(contentType))
+			name := ParseAttachments(contentType)
+			if filename, err := header.Filename(); err == nil && filename != "" {
+				name = filename
+			}
+			out.AttachmentNames = append(out.AttachmentNames, name)
 			b, err := ioutil.ReadAll(part.Body)
 			if err != nil {
 				logrus.Warningf("(skip) read message attachment: %v", err)
 			} else {
-				out.Attachments = append(out.Attachments, b)
+				out.Attachments = append(out.Attachments, Attachment{
+					Name:     name,
+					MimeType: mimeType(contentType),
+					Data:     b,
+				})
+				out.Parts = append(out.Parts, MimePart{MimeType: mimeType(contentType), Name: name, Size: len(b)})
 			}
 
 		}
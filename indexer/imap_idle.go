@@ -0,0 +1,153 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+	"sync"
+	"time"
+)
+
+// idleCommand is imap IDLE command, RFC 2177.
+type idleCommand struct{}
+
+func (cmd *idleCommand) Command() *imap.Command {
+	return &imap.Command{Name: "IDLE"}
+}
+
+// idleResponse handles IDLE continuation request and sends DONE once stop is closed. Done must be closed
+// when command has completed, since server may end IDLE before stop is closed.
+type idleResponse struct {
+	stop    <-chan struct{}
+	done    <-chan struct{}
+	replies chan []byte
+	started bool
+}
+
+func (r *idleResponse) Replies() <-chan []byte {
+	return r.replies
+}
+
+func (r *idleResponse) Handle(resp imap.Resp) error {
+	if _, ok := resp.(*imap.ContinuationReq); ok && !r.started {
+		r.started = true
+		go func() {
+			select {
+			case <-r.stop:
+				r.replies <- []byte("DONE\r\n")
+			case <-r.done:
+			}
+		}()
+		return nil
+	}
+	return responses.ErrUnhandled
+}
+
+// idle runs IDLE command until stop is closed.
+func (i *Imap) idle(stop <-chan struct{}) error {
+	done := make(chan struct{})
+	defer close(done)
+	res := &idleResponse{
+		stop:    stop,
+		done:    done,
+		replies: make(chan []byte, 1),
+	}
+	status, err := i.client.Execute(&idleCommand{}, res)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// watchUpdates starts listening mailbox updates from server. New mails are signaled to i.updates.
+func (i *Imap) watchUpdates() {
+	if i.updates != nil {
+		return
+	}
+	i.updates = make(chan struct{}, 1)
+	updates := make(chan client.Update, 10)
+	i.client.Updates = updates
+
+	go func() {
+		for update := range updates {
+			if _, ok := update.(*client.MailboxUpdate); ok {
+				select {
+				case i.updates <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+}
+
+// WaitUpdate waits until selected mailbox changes, timeout is reached or stop is closed. If server supports IDLE,
+// it is used to receive updates, else mailbox is polled after timeout. Returns true if mailbox might have new mails.
+// Timeout should be less than 29 minutes, since servers may close idle connections after that.
+func (i *Imap) WaitUpdate(stop <-chan struct{}, timeout time.Duration) (bool, error) {
+	i.watchUpdates()
+
+	supportsIdle, err := i.client.Support("IDLE")
+	if err != nil {
+		return false, err
+	}
+	if !supportsIdle {
+		select {
+		case <-stop:
+			return false, nil
+		case <-time.After(timeout):
+			return true, i.client.Noop()
+		}
+	}
+
+	idleStop := make(chan struct{})
+	var once sync.Once
+	stopIdle := func() {
+		once.Do(func() { close(idleStop) })
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- i.idle(idleStop)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	changed := false
+	for {
+		select {
+		case <-i.updates:
+			changed = true
+			stopIdle()
+		case <-timer.C:
+			stopIdle()
+		case <-stop:
+			stopIdle()
+		case err := <-done:
+			if mailbox := i.client.Mailbox(); mailbox != nil {
+				i.mailbox = mailbox
+			}
+			return changed, err
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"fmt"
+	"tryffel.net/go/meilindex/config"
+)
+
+// ImapFolderState is sync state for single imap folder.
+type ImapFolderState struct {
+	UidValidity uint32 `json:"uid_validity"`
+	// LastUid is the highest uid that has been indexed.
+	LastUid uint32 `json:"last_uid"`
+}
+
+// validate checks state against server's uid validity. If validity has changed, previously stored
+// uids are no longer valid and folder must be fully reindexed. Returns true if state was reset.
+func (f *ImapFolderState) validate(uidValidity uint32) bool {
+	if f.UidValidity == uidValidity {
+		return false
+	}
+	reset := f.UidValidity != 0
+	f.UidValidity = uidValidity
+	f.LastUid = 0
+	return reset
+}
+
+// ImapSyncState contains sync state for all accounts and folders. It is persisted to a file
+// between runs.
+type ImapSyncState struct {
+	file string
+	// Folders is a map of account -> folder -> state.
+	Folders map[string]map[string]*ImapFolderState `json:"folders"`
+}
+
+// LoadImapSyncState reads sync state from file. If file does not exist, empty state is returned.
+func LoadImapSyncState(file string) (*ImapSyncState, error) {
+	state := &ImapSyncState{
+		file:    file,
+		Folders: map[string]map[string]*ImapFolderState{},
+	}
+
+	err := config.LoadJsonFile(file, state)
+	if err != nil {
+		return state, fmt.Errorf("load sync state: %v", err)
+	}
+	if state.Folders == nil {
+		state.Folders = map[string]map[string]*ImapFolderState{}
+	}
+	return state, nil
+}
+
+// Folder returns state for given account and folder. If there's no state, new one is created.
+func (s *ImapSyncState) Folder(account, folder string) *ImapFolderState {
+	folders, ok := s.Folders[account]
+	if !ok {
+		folders = map[string]*ImapFolderState{}
+		s.Folders[account] = folders
+	}
+
+	state, ok := folders[folder]
+	if !ok {
+		state = &ImapFolderState{}
+		folders[folder] = state
+	}
+	return state
+}
+
+// Reset clears state for given account, forcing full reindex on next run.
+func (s *ImapSyncState) Reset(account string) {
+	delete(s.Folders, account)
+}
+
+// Save writes state to file.
+func (s *ImapSyncState) Save() error {
+	err := config.SaveJsonFile(s.file, s)
+	if err != nil {
+		return fmt.Errorf("save sync state: %v", err)
+	}
+	return nil
+}
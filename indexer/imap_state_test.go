@@ -0,0 +1,110 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImapFolderState_validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		state       ImapFolderState
+		uidValidity uint32
+		wantReset   bool
+		wantLastUid uint32
+	}{
+		{
+			name:        "new folder",
+			state:       ImapFolderState{},
+			uidValidity: 10,
+			wantReset:   false,
+			wantLastUid: 0,
+		},
+		{
+			name:        "unchanged",
+			state:       ImapFolderState{UidValidity: 10, LastUid: 200},
+			uidValidity: 10,
+			wantReset:   false,
+			wantLastUid: 200,
+		},
+		{
+			name:        "validity changed",
+			state:       ImapFolderState{UidValidity: 10, LastUid: 200},
+			uidValidity: 11,
+			wantReset:   true,
+			wantLastUid: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.validate(tt.uidValidity); got != tt.wantReset {
+				t.Errorf("validate() = %v, want %v", got, tt.wantReset)
+			}
+			if tt.state.LastUid != tt.wantLastUid {
+				t.Errorf("LastUid = %d, want %d", tt.state.LastUid, tt.wantLastUid)
+			}
+			if tt.state.UidValidity != tt.uidValidity {
+				t.Errorf("UidValidity = %d, want %d", tt.state.UidValidity, tt.uidValidity)
+			}
+		})
+	}
+}
+
+func TestImapSyncState_Save(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+
+	state, err := LoadImapSyncState(file)
+	if err != nil {
+		t.Fatalf("load missing state: %v", err)
+	}
+
+	folder := state.Folder("me@imap", "INBOX")
+	folder.UidValidity = 5
+	folder.LastUid = 1234
+	err = state.Save()
+	if err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+
+	loaded, err := LoadImapSyncState(file)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+
+	got := loaded.Folder("me@imap", "INBOX")
+	if *got != *folder {
+		t.Errorf("loaded state = %v, want %v", *got, *folder)
+	}
+
+	loaded.Reset("me@imap")
+	if got := loaded.Folder("me@imap", "INBOX"); got.LastUid != 0 {
+		t.Errorf("state after reset = %v, want empty", *got)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/mail"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterMailboxes(t *testing.T) {
+	mailboxes := []string{"INBOX", "Archive", "Archive/2020", "Trash", "Spam", "[Gmail]/All Mail", "[Gmail]/Sent Mail"}
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "all",
+			want: mailboxes,
+		},
+		{
+			name:    "exclude",
+			exclude: []string{"trash", "Spam", "[Gmail]/All Mail"},
+			want:    []string{"INBOX", "Archive", "Archive/2020", "[Gmail]/Sent Mail"},
+		},
+		{
+			name:    "include wildcard",
+			include: []string{"Archive*", "inbox"},
+			want:    []string{"INBOX", "Archive", "Archive/2020"},
+		},
+		{
+			name:    "include and exclude",
+			include: []string{"[Gmail]/*"},
+			exclude: []string{"*/All ?ail"},
+			want:    []string{"[Gmail]/Sent Mail"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterMailboxes(mailboxes, tt.include, tt.exclude); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FilterMailboxes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_imapFlags(t *testing.T) {
+	got := imapFlags([]string{"\\Seen", "\\Flagged", "\\Recent", "$Forwarded", "$Junk"})
+	want := []string{FlagSeen, FlagFlagged, FlagPassed}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imapFlags() = %v, want %v", got, want)
+	}
+}
+
+func Test_mailToMailParts(t *testing.T) {
+	raw := "From: Alice <alice@example.com>\r\n" +
+		"To: bob@example.com\r\n" +
+		"Subject: Report\r\n" +
+		"Date: Mon, 01 Mar 2021 10:00:00 +0000\r\n" +
+		"Message-ID: <report@example.com>\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		"See attached.\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/csv; name=\"report, march.csv\"\r\n" +
+		"Content-Disposition: attachment; filename=\"report, march.csv\"\r\n" +
+		"\r\n" +
+		"a,b\r\n" +
+		"--b1--\r\n"
+
+	reader, err := mail.CreateReader(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("create reader: %v", err)
+	}
+	got, err := mailToMail(reader)
+	if err != nil {
+		t.Fatalf("mailToMail() error = %v", err)
+	}
+
+	wantParts := []MimePart{
+		{MimeType: "text/plain", Size: len("See attached.")},
+		{MimeType: "text/csv", Name: "report, march.csv", Size: len("a,b")},
+	}
+	if !reflect.DeepEqual(got.Parts, wantParts) {
+		t.Errorf("mailToMail() parts = %v, want %v", got.Parts, wantParts)
+	}
+	if !reflect.DeepEqual(got.AttachmentNames, []string{"report, march.csv"}) {
+		t.Errorf("mailToMail() attachment names = %v, want %v", got.AttachmentNames, []string{"report, march.csv"})
+	}
+}
+
+func Test_idleResponse(t *testing.T) {
+	t.Run("stop", func(t *testing.T) {
+		stop := make(chan struct{})
+		res := &idleResponse{stop: stop, done: make(chan struct{}), replies: make(chan []byte, 1)}
+		if err := res.Handle(&imap.ContinuationReq{}); err != nil {
+			t.Fatalf("Handle() error = %v", err)
+		}
+		close(stop)
+		select {
+		case reply := <-res.Replies():
+			if string(reply) != "DONE\r\n" {
+				t.Errorf("reply = %q, want DONE", reply)
+			}
+		case <-time.After(time.Second):
+			t.Error("DONE not sent after stop")
+		}
+	})
+	t.Run("server ends idle", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+		done := make(chan struct{})
+		res := &idleResponse{stop: make(chan struct{}), done: done, replies: make(chan []byte, 1)}
+		if err := res.Handle(&imap.ContinuationReq{}); err != nil {
+			t.Fatalf("Handle() error = %v", err)
+		}
+		close(done)
+		for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > before {
+			t.Errorf("goroutines = %d after idle ended, want %d", n, before)
+		}
+	})
+}
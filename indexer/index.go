@@ -22,9 +22,11 @@ package indexer
 
 import (
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"github.com/meilisearch/meilisearch-go"
 	"github.com/sirupsen/logrus"
+	"net"
 	"net/http"
 	"runtime"
 	"strings"
@@ -35,15 +37,27 @@ import (
 
 // NewMeilisearch creates new connection.
 func NewMeiliSearch() (*Meilisearch, error) {
+	m := NewMeiliSearchClient()
+	err := m.Connect()
+	return m, err
+}
+
+// NewMeiliSearchClient creates new client from config without connecting to server. Call Connect before
+// using client to make sure server is reachable and index exists.
+func NewMeiliSearchClient() *Meilisearch {
 	m := &Meilisearch{
 		Url:           config.Conf.Meilisearch.Url,
 		Index:         config.Conf.Meilisearch.Index,
 		ApiKey:        config.Conf.Meilisearch.ApiKey,
 		maxNumPushers: runtime.NumCPU(),
 	}
+	m.client = m.newClient()
+	m.threader = NewThreader(m)
+	if config.Conf.Attachments.Cache {
+		m.attachments = NewAttachmentCache(config.Conf.Attachments.CacheDir)
+	}
 	m.pushDone = make(chan bool, m.maxNumPushers)
-	err := m.Connect()
-	return m, err
+	return m
 }
 
 // Meilisearch is a connector to Meilisearch.
@@ -57,25 +71,31 @@ type Meilisearch struct {
 	numPushers    int
 	maxNumPushers int
 	pushDone      chan bool
+	threader      *Threader
+	sorted        sortedHits
+	// attachments is nil if attachment cache is disabled.
+	attachments *AttachmentCache
 }
 
-// Connect creates a connection to meilisearch instance and initializes index if neccessary.
-func (m *Meilisearch) Connect() error {
-	m.client = meilisearch.NewClient(meilisearch.Config{
-		Host:   m.Url,
-		APIKey: m.ApiKey,
-	})
-
-	m.client = meilisearch.NewClientWithCustomHTTPClient(meilisearch.Config{
+func (m *Meilisearch) newClient() *meilisearch.Client {
+	return meilisearch.NewClientWithCustomHTTPClient(meilisearch.Config{
 		Host:   m.Url,
 		APIKey: m.ApiKey,
 	}, http.Client{
 		Timeout: 10 * time.Second,
 	})
+}
+
+// Connect creates a connection to meilisearch instance and initializes index if neccessary.
+// Client is only created if it does not exist yet, so Connect does not modify a client that is in use.
+func (m *Meilisearch) Connect() error {
+	if m.client == nil {
+		m.client = m.newClient()
+	}
 
 	version, err := m.ServerVersion()
 	if err != nil {
-		return fmt.Errorf("get server version: %v", err)
+		return fmt.Errorf("get server version: %w", err)
 	}
 
 	logrus.Infof("Meilisearch version: %s", version)
@@ -95,7 +115,7 @@ func (m *Meilisearch) Connect() error {
 	}
 
 	if err != nil {
-		return fmt.Errorf("get indexes: %v", err)
+		return fmt.Errorf("get indexes: %w", err)
 	}
 
 	if !indexExists {
@@ -108,12 +128,53 @@ func (m *Meilisearch) Connect() error {
 	}
 
 	if err != nil {
-		return fmt.Errorf("create index: %v", err)
+		return fmt.Errorf("create index: %w", err)
 	}
 
+	err = m.updateFilterableAttributes()
+	if err != nil {
+		return fmt.Errorf("update filterable attributes: %w", err)
+	}
 	return nil
 }
 
+// filterableAttributes are registered as attributes for faceting, so that they can be used for filtering.
+var filterableAttributes = []string{"account", "folder", "flags", "seen", "flagged", "answered", "draft", "thread_id",
+	"id", "thread_subject", "from_address", "to_address", "cc_address", "bcc_address", "has_attachment"}
+
+// updateFilterableAttributes registers filterableAttributes to index, if they are not already registered.
+// Changing attributes causes Meilisearch to rebuild index, so they are only updated when missing.
+func (m *Meilisearch) updateFilterableAttributes() error {
+	current, err := m.client.Settings(m.Index).GetAttributesForFaceting()
+	if err != nil {
+		return err
+	}
+
+	attributes := []string{}
+	if current != nil {
+		attributes = append(attributes, *current...)
+	}
+	existing := map[string]bool{}
+	for _, v := range attributes {
+		existing[v] = true
+	}
+
+	missing := false
+	for _, v := range filterableAttributes {
+		if !existing[v] {
+			attributes = append(attributes, v)
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+
+	logrus.Infof("Update filterable attributes: %v", attributes)
+	_, err = m.client.Settings(m.Index).UpdateAttributesForFaceting(attributes)
+	return err
+}
+
 func (m *Meilisearch) ServerVersion() (string, error) {
 	v, err := m.client.Version().Get()
 	if err != nil {
@@ -130,6 +191,7 @@ func (m *Meilisearch) ServerVersion() (string, error) {
 // IndexMailBackground runs multiple goroutines (num of cpus) to push mails to meilisearch.
 // If all goroutines are busy, this call blocks as long as some goroutine is available.
 func (m *Meilisearch) IndexMailBackground(mail []*Mail) error {
+	m.thread(mail)
 	started := time.Now()
 	for {
 		ready := time.Now()
@@ -185,9 +247,21 @@ func (m *Meilisearch) WaitIndexComplete() {
 }
 
 func (m *Meilisearch) IndexMail(mails []*Mail) error {
+	m.thread(mails)
 	return m.indexMail(mails, false)
 }
 
+// thread sets thread ids to mails. Mails are threaded in the order they are pushed.
+func (m *Meilisearch) thread(mails []*Mail) {
+	m.lock.Lock()
+	if m.threader == nil {
+		m.threader = NewThreader(m)
+	}
+	threader := m.threader
+	m.lock.Unlock()
+	threader.Thread(mails)
+}
+
 // IndexMail indexes new mail or updates existing mails.
 func (m *Meilisearch) indexMail(mail []*Mail, background bool) error {
 
@@ -197,21 +271,47 @@ func (m *Meilisearch) indexMail(mail []*Mail, background bool) error {
 
 	for i, v := range mail {
 		v.Sanitize()
+		if m.attachments != nil {
+			err := m.attachments.Store(v)
+			if err != nil {
+				logrus.Warningf("store attachments of mail %s: %v", v.Id, err)
+			}
+		}
 		doc := map[string]interface{}{}
 		doc["id"] = v.Id
 		doc["date"] = v.Timestamp.Unix()
 		doc["from"] = v.From
 		doc["to"] = v.To
 		doc["cc"] = v.Cc
+		doc["bcc"] = v.Bcc
+		doc["from_address"] = strings.ToLower(v.FromAddress.Address)
+		doc["to_address"] = emailAddresses(v.ToAddresses)
+		doc["cc_address"] = emailAddresses(v.CcAddresses)
+		doc["bcc_address"] = emailAddresses(v.BccAddresses)
 		doc["subject"] = v.Subject
 		doc["message"] = v.Body
 		doc["folder"] = v.Folder
-		doc["attachments"] = strings.Join(v.AttachmentNames, ",")
+		doc["account"] = v.Account
+		doc["attachments"] = v.AttachmentNames
+		doc["parts"] = v.Parts
+		doc["source"] = v.Source
+		doc["has_attachment"] = len(v.AttachmentNames) > 0
+		doc["attachment_text"] = v.AttachmentText
+		flags := v.Flags
+		if flags == nil {
+			flags = []string{}
+		}
+		doc["flags"] = flags
+		doc["seen"] = v.HasFlag(FlagSeen)
+		doc["flagged"] = v.HasFlag(FlagFlagged)
+		doc["answered"] = v.HasFlag(FlagAnswered)
+		doc["draft"] = v.HasFlag(FlagDraft)
+		doc["in_reply_to"] = v.InReplyTo
+		doc["references"] = v.References
+		doc["thread_id"] = v.ThreadId
+		doc["thread_subject"], _ = normalizeSubject(v.Subject)
+		doc["uid"] = documentUid(v.Uid)
 		documents[i] = doc
-
-		// email ids can be too complex for meilisearch. Use md5 as a unique id for mail.
-		hash := md5.Sum([]byte(v.Uid))
-		doc["uid"] = fmt.Sprintf("%x", hash)
 	}
 
 	res, err := m.client.Documents(m.Index).AddOrReplace(documents)
@@ -234,6 +334,42 @@ func (m *Meilisearch) indexMail(mail []*Mail, background bool) error {
 	return nil
 }
 
+// ErrorMessage returns short message for error returned by Meilisearch client. If server responded with an error,
+// its message is returned, and if server could not be reached, the underlying network error is returned.
+func ErrorMessage(err error) string {
+	if err == nil {
+		return ""
+	}
+	var meiliErr *meilisearch.Error
+	if errors.As(err, &meiliErr) {
+		if meiliErr.MeilisearchMessage != "" {
+			return meiliErr.MeilisearchMessage
+		}
+		if meiliErr.OriginError != nil {
+			return meiliErr.OriginError.Error()
+		}
+	}
+	return err.Error()
+}
+
+// IsNetworkError returns true if err was caused by failing to reach server, e.g. connection was refused
+// or request timed out.
+func IsNetworkError(err error) bool {
+	var meiliErr *meilisearch.Error
+	if errors.As(err, &meiliErr) && meiliErr.StatusCode == 0 && meiliErr.OriginError != nil {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// documentUid returns meilisearch document id for mail uid.
+// Email ids can be too complex for meilisearch. Use md5 as a unique id for mail.
+func documentUid(uid string) string {
+	hash := md5.Sum([]byte(uid))
+	return fmt.Sprintf("%x", hash)
+}
+
 // RankingRules returns a list of ranking rules. First rule is the most important, last is least important.
 func (m *Meilisearch) RankingRules() (*[]string, error) {
 
@@ -273,14 +409,13 @@ func (m *Meilisearch) SetSynonyms(synonyms *map[string][]string) error {
 }
 
 func (m *Meilisearch) Stats() ServerStats {
+	serverStats := ServerStats{}
 	stats, err := m.client.Stats().Get(m.Index)
 	if err != nil {
 		logrus.Errorf("get stats: %v", err)
-	}
-
-	serverStats := ServerStats{
-		NumDocuments: stats.NumberOfDocuments,
-		Indexing:     stats.IsIndexing,
+	} else {
+		serverStats.NumDocuments = stats.NumberOfDocuments
+		serverStats.Indexing = stats.IsIndexing
 	}
 
 	version, err := m.ServerVersion()
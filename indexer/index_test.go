@@ -0,0 +1,79 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorMessage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message":"Invalid API key: secret","errorCode":"invalid_token",` +
+			`"errorType":"authentication_error","errorLink":"https://docs.meilisearch.com/errors#invalid_token"}`))
+	}))
+	defer server.Close()
+
+	m := &Meilisearch{Url: server.URL, Index: "mail", ApiKey: "secret"}
+	err := m.Connect()
+	if err == nil {
+		t.Fatal("Connect() error = nil, want error")
+	}
+	if got := ErrorMessage(err); got != "Invalid API key: secret" {
+		t.Errorf("ErrorMessage(Connect()) = %q, want %q", got, "Invalid API key: secret")
+	}
+
+	_, err = m.Search("foo", "", SearchOptions{Limit: 10})
+	if err == nil {
+		t.Fatal("Search() error = nil, want error")
+	}
+	if got := ErrorMessage(err); got != "Invalid API key: secret" {
+		t.Errorf("ErrorMessage(Search()) = %q, want %q", got, "Invalid API key: secret")
+	}
+	if IsNetworkError(err) {
+		t.Errorf("IsNetworkError(Search()) = true for server error")
+	}
+
+	// server is not reachable
+	server.Close()
+	_, err = m.Search("foo", "", SearchOptions{Limit: 10})
+	if err == nil {
+		t.Fatal("Search() error = nil, want error")
+	}
+	if got := ErrorMessage(err); !strings.Contains(got, "connect") {
+		t.Errorf("ErrorMessage(Search()) = %q, want connection error", got)
+	}
+	if !IsNetworkError(err) {
+		t.Errorf("IsNetworkError(Search()) = false for connection error")
+	}
+
+	if got := ErrorMessage(errors.New("other error")); got != "other error" {
+		t.Errorf("ErrorMessage() = %q, want %q", got, "other error")
+	}
+	if got := ErrorMessage(nil); got != "" {
+		t.Errorf("ErrorMessage(nil) = %q, want empty", got)
+	}
+}
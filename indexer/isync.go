@@ -22,46 +22,58 @@ package indexer
 
 import (
 	"fmt"
-	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
 	"github.com/sirupsen/logrus"
-	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"tryffel.net/go/meilindex/config"
 	"tryffel.net/go/meilindex/external"
 )
 
-func ReadVerbatimDir(path string, flushFunc func(mails []*Mail) error) error {
-	var files []external.MboxFile
-	var err error
-	files, err = external.VerbatimFiles(path)
+// ReadVerbatimDir reads all mails in maildir (e.g. isync) directory and flushes batched mails to flushFunc.
+// If doneFunc is not nil, it is called with every folder whose mails were all read, including empty folders.
+func ReadVerbatimDir(path string, flushFunc func(mails []*Mail) error, doneFunc func(folder string)) error {
+	dirs, err := external.MaildirDirs(strings.TrimSuffix(path, "/"), "")
 	if err != nil {
 		return err
 	}
+	var files []external.MboxFile
+	var folders []string
+	for _, dir := range dirs {
+		if !dir.Mails {
+			continue
+		}
+		found, err := external.MaildirFiles(dir.Path, dir.Folder)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+		folders = append(folders, dir.Folder)
+	}
 	if len(files) == 0 {
-		logrus.Warning("Did not find any suitable Mbox files")
-		return err
+		logrus.Warning("Did not find any mails in maildir")
+	} else {
+		logrus.Infof("Found %d mails", len(files))
 	}
-	logrus.Infof("Found %d folders", len(files))
 
 	batchSize := config.Conf.File.BatchSize
-
 	mails := []*Mail{}
-
 	totalMails := 0
+	failed := map[string]bool{}
 
-	// read file and send email batches if batch is full. If smaller, return array of mails.
-	// Try to always push reasonable batch size, even if single file contains less mails. Not batching
-	// small files increases indexing time significantly.
-	for i, v := range files {
-		logrus.Infof("Indexing (%d / %d): %s", i, len(files), v.Name)
-		mail, indexed, err := readVerbatimFile(v.File, v.Name, flushFunc)
+	for _, v := range files {
+		mail, err := readMaildirFile(v.File, v.Name)
 		if err != nil {
-			logrus.Error(err)
+			logrus.Warningf("(skip) %s: %v", v.File, err)
+			// file could not be read (e.g. it was renamed), so it may still exist. Mails that can not
+			// be parsed do not prevent pruning.
+			if _, ok := err.(*os.PathError); ok {
+				failed[v.Name] = true
+			}
 			continue
 		}
-		totalMails += indexed
-		mails = append(mails, mail...)
+		mails = append(mails, mail)
 		if len(mails) >= batchSize {
 			err = flushFunc(mails)
 			if err != nil {
@@ -74,72 +86,86 @@ func ReadVerbatimDir(path string, flushFunc func(mails []*Mail) error) error {
 
 	if len(mails) > 0 {
 		err = flushFunc(mails)
+		if err != nil {
+			logrus.Error(err)
+		}
 		totalMails += len(mails)
 	}
-	logrus.Infof("Successfully indexed %d mails from %d files", totalMails, len(files))
+	if totalMails > 0 {
+		logrus.Infof("Successfully indexed %d mails from %d files", totalMails, len(files))
+	}
+
+	if doneFunc != nil {
+		// each folder has both 'cur' and 'new'
+		done := map[string]bool{}
+		for _, folder := range folders {
+			if !failed[folder] && !done[folder] {
+				done[folder] = true
+				doneFunc(folder)
+			}
+		}
+	}
 	return nil
 }
 
-func readVerbatimFile(file, folder string, flushFunc func(mails []*Mail) error) ([]*Mail, int, error) {
-	batchSize := 1000
-	batch := 0
-	currentBatchSize := 0
-	totalMails := 0
-
+// readMaildirFile reads single mail from maildir file. Flags are parsed from file name.
+func readMaildirFile(file, folder string) (*Mail, error) {
 	fd, err := os.Open(file)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
+	defer fd.Close()
 
-	msg, err := message.Read(fd)
+	reader, err := mail.CreateReader(fd)
 	if err != nil {
-		return nil, 0, fmt.Errorf("read file: %v", err)
-
+		return nil, fmt.Errorf("parse mail: %v", err)
 	}
 
-	var mails []*Mail
-
+	m, err := mailToMail(reader)
 	if err != nil {
-		if err == io.EOF {
-			return nil, 0, nil
-		}
-		return mails, 0, err
+		logrus.Warningf("(skip) parse mail: %v", err)
 	}
 
-	if msg == nil {
-		return nil, 0, nil
+	uniqueName, flags := parseMaildirName(filepath.Base(file))
+	if m.Uid == "" {
+		m.Uid = uniqueName
 	}
-
-	parsed, err := mail.CreateReader(fd)
+	m.Folder = folder
+	m.Flags = flags
+	path, err := filepath.Abs(file)
 	if err != nil {
-		logrus.Warningf("(skip) parse mail: %v", err)
-	} else {
-		var m *Mail
-		m, err = mailToMail(parsed)
-		m.Folder = folder
-
-		mails = append(mails, m)
-		currentBatchSize += 1
-		if currentBatchSize == batchSize {
-			batch += 1
-			totalMails += currentBatchSize
-			err := flushFunc(mails)
-			if err != nil {
-				logrus.Errorf("Flush email batch: %v", err)
-			}
-			mails = []*Mail{}
-			currentBatchSize = 0
-		}
+		path = file
 	}
+	m.Source = &Source{Type: SourceMaildir, Path: path, Offset: -1}
+	return m, nil
+}
 
-	err = fd.Close()
-	if err != nil {
-		logrus.Errorf("close file: %v", err)
-	}
+// maildirFlags maps maildir info flags to mail flags.
+var maildirFlags = map[rune]string{
+	'D': FlagDraft,
+	'F': FlagFlagged,
+	'P': FlagPassed,
+	'R': FlagAnswered,
+	'S': FlagSeen,
+	'T': FlagDeleted,
+}
 
-	if batch > 0 {
-		logrus.Infof("Flushed %d batches, %d mails", batch, totalMails)
+// parseMaildirName splits maildir file name into unique name and flags, e.g.
+// '1612345678.R123.host:2,FS' -> '1612345678.R123.host', [flagged seen]. Isync may also use ';' as delimiter.
+func parseMaildirName(name string) (string, []string) {
+	i := strings.LastIndex(name, ":2,")
+	if i == -1 {
+		i = strings.LastIndex(name, ";2,")
+	}
+	if i == -1 {
+		return name, []string{}
 	}
 
-	return mails, totalMails, nil
+	flags := []string{}
+	for _, r := range name[i+3:] {
+		if flag, ok := maildirFlags[r]; ok {
+			flags = append(flags, flag)
+		}
+	}
+	return name[:i], flags
 }
@@ -0,0 +1,206 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"tryffel.net/go/meilindex/config"
+)
+
+func Test_parseMaildirName(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		wantName  string
+		wantFlags []string
+	}{
+		{
+			name:      "new mail",
+			file:      "1612345678.M1P2.host",
+			wantName:  "1612345678.M1P2.host",
+			wantFlags: []string{},
+		},
+		{
+			name:      "no flags",
+			file:      "1612345678.M1P2.host:2,",
+			wantName:  "1612345678.M1P2.host",
+			wantFlags: []string{},
+		},
+		{
+			name:      "flags",
+			file:      "1612345678.M1P2.host,U=12:2,FRS",
+			wantName:  "1612345678.M1P2.host,U=12",
+			wantFlags: []string{FlagFlagged, FlagAnswered, FlagSeen},
+		},
+		{
+			name:      "semicolon delimiter",
+			file:      "1612345678.M1P2.host;2,DT",
+			wantName:  "1612345678.M1P2.host",
+			wantFlags: []string{FlagDraft, FlagDeleted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotName, gotFlags := parseMaildirName(tt.file)
+			if gotName != tt.wantName {
+				t.Errorf("parseMaildirName() name = %v, want %v", gotName, tt.wantName)
+			}
+			if !reflect.DeepEqual(gotFlags, tt.wantFlags) {
+				t.Errorf("parseMaildirName() flags = %v, want %v", gotFlags, tt.wantFlags)
+			}
+		})
+	}
+}
+
+// maildirFixture is a mail that is written to generated maildir.
+type maildirFixture struct {
+	dir     string
+	file    string
+	id      string
+	subject string
+	folder  string
+	flags   []string
+}
+
+func writeMaildirFixture(t *testing.T, root string, mail maildirFixture) {
+	dir := filepath.Join(root, mail.dir)
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		err := os.MkdirAll(filepath.Join(filepath.Dir(dir), sub), 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	idHeader := ""
+	if mail.id != "" {
+		idHeader = fmt.Sprintf("Message-ID: <%s>\r\n", mail.id)
+	}
+	content := fmt.Sprintf("From: Sender <sender@example.com>\r\n"+
+		"To: receiver@example.com\r\n"+
+		"Subject: %s\r\n"+
+		"Date: Mon, 01 Feb 2021 10:00:00 +0000\r\n"+
+		"%s"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+
+		"Body of %s\r\n", mail.subject, idHeader, mail.subject)
+
+	err := ioutil.WriteFile(filepath.Join(dir, mail.file), []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadVerbatimDir(t *testing.T) {
+	defer func(conf *config.Config) { config.Conf = conf }(config.Conf)
+	config.Conf = &config.Config{File: config.File{BatchSize: 2}}
+
+	root, err := ioutil.TempDir("", "meilindex-maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	fixtures := []maildirFixture{
+		{dir: "cur", file: "1.M1.host:2,S", id: "1@example.com", subject: "read",
+			folder: "Inbox", flags: []string{FlagSeen}},
+		{dir: "new", file: "2.M2.host", id: "2@example.com", subject: "unread",
+			folder: "Inbox", flags: []string{}},
+		{dir: ".Work.Projects/cur", file: "3.M3.host:2,FR", id: "3@example.com", subject: "maildir++",
+			folder: "Work/Projects", flags: []string{FlagFlagged, FlagAnswered}},
+		{dir: "Archive/2020/cur", file: "4.M4.host:2,", id: "", subject: "no message id",
+			folder: "Archive/2020", flags: []string{}},
+	}
+	for _, v := range fixtures {
+		writeMaildirFixture(t, root, v)
+	}
+	// mails in tmp are not complete yet
+	err = ioutil.WriteFile(filepath.Join(root, "tmp", "5.M5.host"), []byte("Subject: partial\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// empty folders are complete as well
+	err = os.MkdirAll(filepath.Join(root, "Empty", "new"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mails []*Mail
+	var done []string
+	flushes := 0
+	err = ReadVerbatimDir(root, func(batch []*Mail) error {
+		flushes += 1
+		mails = append(mails, batch...)
+		return nil
+	}, func(folder string) {
+		done = append(done, folder)
+	})
+	if err != nil {
+		t.Fatalf("ReadVerbatimDir() error: %v", err)
+	}
+	sort.Strings(done)
+	if want := []string{"Archive/2020", "Empty", "Inbox", "Work/Projects"}; !reflect.DeepEqual(done, want) {
+		t.Errorf("complete folders = %v, want %v", done, want)
+	}
+	if flushes != 2 {
+		t.Errorf("flushed %d batches, want 2", flushes)
+	}
+	if len(mails) != len(fixtures) {
+		t.Fatalf("got %d mails, want %d", len(mails), len(fixtures))
+	}
+
+	sort.Slice(mails, func(i, j int) bool { return mails[i].Subject < mails[j].Subject })
+	sort.Slice(fixtures, func(i, j int) bool { return fixtures[i].subject < fixtures[j].subject })
+
+	for i, want := range fixtures {
+		got := mails[i]
+		t.Run(want.subject, func(t *testing.T) {
+			if got.Subject != want.subject {
+				t.Errorf("subject = %s, want %s", got.Subject, want.subject)
+			}
+			if got.Folder != want.folder {
+				t.Errorf("folder = %s, want %s", got.Folder, want.folder)
+			}
+			if !reflect.DeepEqual(got.Flags, want.flags) {
+				t.Errorf("flags = %v, want %v", got.Flags, want.flags)
+			}
+			if want := (Address{Name: "Sender", Address: "sender@example.com"}); got.FromAddress != want {
+				t.Errorf("from = %v, want %v", got.FromAddress, want)
+			}
+			if got.Body != "Body of "+want.subject+"\r\n" {
+				t.Errorf("body = %q", got.Body)
+			}
+			wantUid := want.id
+			if wantUid == "" {
+				wantUid, _ = parseMaildirName(want.file)
+			}
+			if got.Uid != wantUid {
+				t.Errorf("uid = %s, want %s", got.Uid, wantUid)
+			}
+		})
+	}
+}
@@ -22,6 +22,9 @@ package indexer
 
 import (
 	"fmt"
+	"github.com/emersion/go-message/charset"
+	"mime"
+	netmail "net/mail"
 	"regexp"
 	"strings"
 	"time"
@@ -31,22 +34,90 @@ type Mail struct {
 	// Uid is hash calculated from id. Uid contains only ascii characters.
 	Uid string `json:"uid"`
 	// Original message id
-	Id              string    `json:"id"`
-	From            string    `json:"from"`
-	To              []string  `json:"to"`
-	Cc              []string  `json:"cc"`
-	Subject         string    `json:"subject"`
-	Body            string    `json:"body"`
-	Timestamp       time.Time `json:"date"`
-	Folder          string    `json:"folder"`
-	Attachments     [][]byte
-	AttachmentNames []string
+	Id           string    `json:"id"`
+	From         string    `json:"from"`
+	To           []string  `json:"to"`
+	Cc           []string  `json:"cc"`
+	Bcc          []string  `json:"bcc"`
+	FromAddress  Address   `json:"from_address"`
+	ToAddresses  []Address `json:"to_addresses"`
+	CcAddresses  []Address `json:"cc_addresses"`
+	BccAddresses []Address `json:"bcc_addresses"`
+	Subject      string    `json:"subject"`
+	Body         string    `json:"body"`
+	Timestamp    time.Time `json:"date"`
+	Folder       string    `json:"folder"`
+	Account      string    `json:"account"`
+	Flags        []string  `json:"flags"`
+	InReplyTo    string    `json:"in_reply_to"`
+	References   []string  `json:"references"`
+	ThreadId     string    `json:"thread_id"`
+	// Attachments are only set when reading mails from source, they are not stored in index.
+	Attachments     []Attachment `json:"-"`
+	AttachmentNames []string     `json:"attachments"`
+	// AttachmentText is text extracted from attachments.
+	AttachmentText string `json:"attachment_text"`
+	// Parts lists mime parts of the message, including body and attachments.
+	Parts []MimePart `json:"parts"`
+	// Source is the original location of mail.
+	Source *Source `json:"source"`
+}
+
+// Attachment is a file attached to mail.
+type Attachment struct {
+	Name     string
+	MimeType string
+	Data     []byte
+}
+
+// MimePart describes single part of a message.
+type MimePart struct {
+	MimeType string `json:"mime_type"`
+	// Name is the file name of attachment, empty for body parts.
+	Name string `json:"name"`
+	// Size is the size of decoded content in bytes.
+	Size int `json:"size"`
+}
+
+// String returns part as 'name (mime type, size)'.
+func (p MimePart) String() string {
+	size := fmt.Sprintf("%d B", p.Size)
+	if p.Size >= 1024*1024 {
+		size = fmt.Sprintf("%.1f MB", float64(p.Size)/(1024*1024))
+	} else if p.Size >= 1024 {
+		size = fmt.Sprintf("%.1f kB", float64(p.Size)/1024)
+	}
+	if p.Name == "" {
+		return fmt.Sprintf("%s, %s", p.MimeType, size)
+	}
+	return fmt.Sprintf("%s (%s, %s)", p.Name, p.MimeType, size)
+}
+
+// Mail flags
+const (
+	FlagSeen     = "seen"
+	FlagAnswered = "answered"
+	FlagFlagged  = "flagged"
+	FlagDraft    = "draft"
+	FlagDeleted  = "deleted"
+	FlagPassed   = "passed"
+)
+
+// HasFlag returns true if mail has given flag.
+func (m *Mail) HasFlag(flag string) bool {
+	for _, v := range m.Flags {
+		if v == flag {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *Mail) String() string {
 	return fmt.Sprintf(
 		`
 id: %s,
+account: %s,
 folder: %s
 date: %s
 from: %s,
@@ -56,7 +127,20 @@ subject: %s,
 
 %s
 
-`, m.Id, m.Folder, m.DateTime(), m.From, m.To, m.Cc, m.Subject, m.Body)
+`, m.Id, m.Account, m.Folder, m.DateTime(), m.From, m.To, m.Cc, m.Subject, m.Body)
+}
+
+// WithAccount returns flushFunc that sets account to all mails before passing them to flushFunc.
+func WithAccount(account string, flushFunc func(mails []*Mail) error) func(mails []*Mail) error {
+	return func(mails []*Mail) error {
+		for _, mail := range mails {
+			mail.Account = account
+			if mail.Source != nil {
+				mail.Source.Account = account
+			}
+		}
+		return flushFunc(mails)
+	}
 }
 
 // Date returns date part of timestamp
@@ -119,37 +203,87 @@ func (m *Mail) HighlightedFrom() string {
 	return from
 }
 
-var addressNames = regexp.MustCompile(`\"([^'\"]+)\"\s<([\w.]+@[a-zA-Z.]+)>`)
-var plainAddress = regexp.MustCompile(`([\w.]+@[a-zA-Z.]+)`)
-var escapedNames = regexp.MustCompile(`\"'([^\"]+)'\"\s<([\w.]+@[a-zA-Z.]+)>`)
+// Address is mail address with optional display name.
+type Address struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// DisplayName returns name, or address if there's no name.
+func (a Address) DisplayName() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Address
+}
+
+func (a Address) String() string {
+	if a.Name != "" && a.Address != "" {
+		return fmt.Sprintf("%s <%s>", a.Name, a.Address)
+	}
+	return a.DisplayName()
+}
 
-// strip multiple addresses and possible names to names-only list
-func stripdAddressNames(address string) []string {
-	var out []string
-	// Catch "person" <address>
-	matches := addressNames.FindAllStringSubmatch(address, -1)
-	if len(matches) > 0 {
-		for _, v := range matches {
-			out = append(out, v[1])
+// displayNames returns display names of addresses.
+func displayNames(addresses []Address) []string {
+	out := make([]string, len(addresses))
+	for i, v := range addresses {
+		out[i] = v.DisplayName()
+	}
+	return out
+}
+
+// emailAddresses returns email addresses of addresses. Addresses without email are skipped.
+func emailAddresses(addresses []Address) []string {
+	out := make([]string, 0, len(addresses))
+	for _, v := range addresses {
+		if v.Address != "" {
+			out = append(out, strings.ToLower(v.Address))
 		}
-		return out
 	}
-	// catch "'sender'" <address>
-	matches = escapedNames.FindAllStringSubmatch(address, -1)
-	if len(matches) > 0 {
-		for _, v := range matches {
-			out = append(out, v[1])
+	return out
+}
+
+var addressParser = &netmail.AddressParser{WordDecoder: &mime.WordDecoder{CharsetReader: charset.Reader}}
+
+// emailPattern matches email address in malformed address lists.
+var emailPattern = regexp.MustCompile(`[\w.+\-'=]+@[\w\-]+(\.[\w\-]+)*`)
+
+// parseAddressList parses address list header value, e.g. '"Person A" <a@mail.com>, b@mail.com'.
+// Encoded names are decoded. If the list is not valid, addresses are searched with regular expression.
+func parseAddressList(list string) []Address {
+	if strings.TrimSpace(list) == "" {
+		return []Address{}
+	}
+
+	parsed, err := addressParser.ParseList(list)
+	if err == nil {
+		out := make([]Address, len(parsed))
+		for i, v := range parsed {
+			out[i] = Address{Name: trimName(v.Name), Address: v.Address}
 		}
 		return out
 	}
 
-	// catch address
-	matches = plainAddress.FindAllStringSubmatch(address, -1)
-	if len(matches) > 0 {
-		for _, v := range matches {
-			out = append(out, v[1])
+	var out []Address
+	for _, part := range strings.Split(list, ",") {
+		address := Address{}
+		if match := emailPattern.FindStringIndex(part); match != nil {
+			address.Address = part[match[0]:match[1]]
+			part = part[:match[0]] + part[match[1]:]
+		}
+		if name, err := addressParser.WordDecoder.DecodeHeader(part); err == nil {
+			part = name
+		}
+		address.Name = trimName(strings.Trim(strings.TrimSpace(part), "<>"))
+		if address.Name != "" || address.Address != "" {
+			out = append(out, address)
 		}
-		return out
 	}
-	return []string{address}
+	return out
+}
+
+// trimName removes whitespace and quotes around name, e.g. "'Person'" -> Person.
+func trimName(name string) string {
+	return strings.Trim(strings.TrimSpace(name), `"' `)
 }
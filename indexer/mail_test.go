@@ -25,35 +25,69 @@ import (
 	"testing"
 )
 
-func Test_stripdAddressNames(t *testing.T) {
+func Test_parseAddressList(t *testing.T) {
 	tests := []struct {
 		name    string
 		address string
-		want    []string
+		want    []Address
 	}{
 		{
+			name:    "name",
 			address: `"Mickey Mouse" <mickey.mouse@gmail.com>`,
-			want:    []string{"Mickey Mouse"},
+			want:    []Address{{Name: "Mickey Mouse", Address: "mickey.mouse@gmail.com"}},
 		},
 		{
+			name:    "multiple names",
 			address: `"Person B" <person.b@gmail.com>, "Person C" <person.c@gmail.com>`,
-			want:    []string{"Person B", "Person C"},
+			want: []Address{
+				{Name: "Person B", Address: "person.b@gmail.com"},
+				{Name: "Person C", Address: "person.c@gmail.com"},
+			},
 		},
 		{
+			name:    "addresses",
 			address: "person.b@gmail.com, person.c@gmail.com",
-			want:    []string{"person.b@gmail.com", "person.c@gmail.com"},
+			want:    []Address{{Address: "person.b@gmail.com"}, {Address: "person.c@gmail.com"}},
 		},
 		{
+			name:    "escaped names",
 			address: `"'Person B'" <person.b@gmail.com>, "'Person C'" <person.c@gmail.com>`,
-			want:    []string{"Person B", "Person C"},
+			want: []Address{
+				{Name: "Person B", Address: "person.b@gmail.com"},
+				{Name: "Person C", Address: "person.c@gmail.com"},
+			},
+		},
+		{
+			name:    "plus, dash and digits",
+			address: `Alice <alice+news@mail-1.corp2.com>, bob-smith99@sub.example-3.org`,
+			want: []Address{
+				{Name: "Alice", Address: "alice+news@mail-1.corp2.com"},
+				{Address: "bob-smith99@sub.example-3.org"},
+			},
+		},
+		{
+			name:    "encoded name",
+			address: `=?utf-8?q?J=C3=A4rvinen?= <jarvinen@example.fi>`,
+			want:    []Address{{Name: "Järvinen", Address: "jarvinen@example.fi"}},
+		},
+		{
+			name:    "malformed",
+			address: `Person, With Comma <person@example.com>`,
+			want: []Address{
+				{Name: "Person"},
+				{Name: "With Comma", Address: "person@example.com"},
+			},
+		},
+		{
+			name:    "empty",
+			address: " ",
+			want:    []Address{},
 		},
-
-		// TODO: Add test cases.
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := stripdAddressNames(tt.address); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("stripdAddressNames() = %v, want %v", got, tt.want)
+			if got := parseAddressList(tt.address); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAddressList() = %v, want %v", got, tt.want)
 			}
 		})
 	}
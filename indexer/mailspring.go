@@ -44,6 +44,18 @@ func (p *mailSpringPerson) String() string {
 	return p.Email
 }
 
+func (p *mailSpringPerson) Address() Address {
+	return Address{Name: p.Name, Address: p.Email}
+}
+
+func mailSpringAddresses(persons []mailSpringPerson) []Address {
+	out := make([]Address, len(persons))
+	for i, v := range persons {
+		out[i] = v.Address()
+	}
+	return out
+}
+
 type mailSpringMessageData struct {
 	Date   int64 `json:"date"`
 	Folder struct {
@@ -73,6 +85,12 @@ type mailSpringMail struct {
 	Subject string                 `db:"subject"`
 	Data    *mailSpringMessageData `db:"data"`
 	Body    sql.NullString         `db:"body"`
+	Unread  bool                   `db:"unread"`
+	Starred bool                   `db:"starred"`
+	Draft   bool                   `db:"draft"`
+	// ThreadId is Mailspring's thread id.
+	ThreadId  sql.NullString `db:"threadId"`
+	InReplyTo sql.NullString `db:"replyToHeaderMessageId"`
 }
 
 func (msMail *mailSpringMail) ToMail() *Mail {
@@ -83,33 +101,35 @@ func (msMail *mailSpringMail) ToMail() *Mail {
 		Body:      msMail.Body.String,
 		Timestamp: time.Unix(msMail.Data.Date, 0),
 		Folder:    msMail.Data.Folder.Path,
+		Flags:     []string{},
+		InReplyTo: msMail.InReplyTo.String,
 	}
-
-	if len(msMail.Data.From) > 0 {
-		if msMail.Data.From[0].Name != "" {
-			mail.From = msMail.Data.From[0].Name
-		} else {
-			mail.From = msMail.Data.From[0].Email
-		}
+	if msMail.ThreadId.String != "" {
+		mail.ThreadId = threadId("mailspring:" + msMail.ThreadId.String)
 	}
 
-	mail.To = make([]string, len(msMail.Data.To))
-	for i, v := range msMail.Data.To {
-		if v.Name != "" {
-			mail.To[i] = v.Name
-		} else {
-			mail.To[i] = v.Email
-		}
+	if !msMail.Unread {
+		mail.Flags = append(mail.Flags, FlagSeen)
 	}
-	mail.Cc = make([]string, len(msMail.Data.CC))
-	for i, v := range msMail.Data.CC {
-		if v.Name != "" {
-			mail.Cc[i] = v.Name
-		} else {
-			mail.Cc[i] = v.Email
-		}
+	if msMail.Starred {
+		mail.Flags = append(mail.Flags, FlagFlagged)
+	}
+	if msMail.Draft {
+		mail.Flags = append(mail.Flags, FlagDraft)
+	}
+
+	if len(msMail.Data.From) > 0 {
+		mail.FromAddress = msMail.Data.From[0].Address()
+		mail.From = mail.FromAddress.DisplayName()
 	}
 
+	mail.ToAddresses = mailSpringAddresses(msMail.Data.To)
+	mail.CcAddresses = mailSpringAddresses(msMail.Data.CC)
+	mail.BccAddresses = mailSpringAddresses(msMail.Data.Bcc)
+	mail.To = displayNames(mail.ToAddresses)
+	mail.Cc = displayNames(mail.CcAddresses)
+	mail.Bcc = displayNames(mail.BccAddresses)
+
 	if msMail.Body.String != "" {
 		plainText, err := html2text.FromString(msMail.Body.String, html2text.Options{
 			PrettyTables: false,
@@ -123,7 +143,10 @@ func (msMail *mailSpringMail) ToMail() *Mail {
 	return mail
 }
 
-func ReadMailspring(file string, recursive bool, flushFunc func(mails []*Mail) error) ([]*Mail, error) {
+// ReadMailspring reads all mails from Mailspring database and flushes them to flushFunc one page at a time.
+// If doneFunc is not nil, it is called with every folder after all mails have been read.
+func ReadMailspring(file string, recursive bool, flushFunc func(mails []*Mail) error,
+	doneFunc func(folder string)) ([]*Mail, error) {
 	logrus.Infof("open mailspring database %s", file)
 	db, err := sqlx.Open("sqlite3", fmt.Sprintf("%s?mode=ro", file))
 	if err != nil {
@@ -154,6 +177,11 @@ select
        m.headerMessageId,
        m.subject,
        m.data,
+       m.unread,
+       m.starred,
+       m.draft,
+       m.threadId,
+       m.replyToHeaderMessageId,
        body.value as body
 from Message as m
 left join MessageBody as body on m.id = body.id
@@ -163,6 +191,8 @@ offset ?;
 
 `
 
+	var folders []string
+	seenFolders := map[string]bool{}
 	for page := 0; page < pages; page++ {
 		err = db.Select(&rawMails, mailSql, batchSize, page*batchSize)
 		if err != nil {
@@ -173,6 +203,11 @@ offset ?;
 
 		for i, v := range rawMails {
 			mails[i] = v.ToMail()
+			mails[i].Source = &Source{Type: SourceMailspring, Path: file, Offset: -1, Id: v.Id}
+			if !seenFolders[mails[i].Folder] {
+				seenFolders[mails[i].Folder] = true
+				folders = append(folders, mails[i].Folder)
+			}
 		}
 
 		err = flushFunc(mails)
@@ -180,5 +215,12 @@ offset ?;
 			logrus.Errorf("flush mails (page %d): %v", page, err)
 		}
 	}
+
+	// all mails were read, but empty folders are not known
+	if doneFunc != nil {
+		for _, folder := range folders {
+			doneFunc(folder)
+		}
+	}
 	return nil, nil
 }
@@ -0,0 +1,172 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"fmt"
+	"github.com/meilisearch/meilisearch-go"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// seenFolder identifies folder in account.
+type seenFolder struct {
+	account string
+	folder  string
+}
+
+// SeenMails records mails that still exist in mail source, grouped by account and folder.
+// Only folders that have been completely read are considered when pruning index, so that
+// mails are not removed if reading a folder fails halfway.
+type SeenMails struct {
+	lock     sync.Mutex
+	folders  map[seenFolder]map[string]bool
+	complete map[seenFolder]bool
+}
+
+func NewSeenMails() *SeenMails {
+	return &SeenMails{
+		folders:  map[seenFolder]map[string]bool{},
+		complete: map[seenFolder]bool{},
+	}
+}
+
+// Add marks mail with given uid (Mail.Uid) as existing in account's folder.
+func (s *SeenMails) Add(account, folder, uid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.addFolder(account, folder)[documentUid(uid)] = true
+}
+
+// CompleteFolder marks folder as completely read, even if it does not contain any mails.
+// Mails in complete folders that have not been added are pruned.
+func (s *SeenMails) CompleteFolder(account, folder string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.addFolder(account, folder)
+	s.complete[seenFolder{account: account, folder: folder}] = true
+}
+
+func (s *SeenMails) addFolder(account, folder string) map[string]bool {
+	key := seenFolder{account: account, folder: folder}
+	uids, ok := s.folders[key]
+	if !ok {
+		uids = map[string]bool{}
+		s.folders[key] = uids
+	}
+	return uids
+}
+
+// Folders returns sorted list of complete folders, formatted as 'account/folder'.
+func (s *SeenMails) Folders() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	folders := make([]string, 0, len(s.complete))
+	for key := range s.complete {
+		folders = append(folders, key.account+"/"+key.folder)
+	}
+	sort.Strings(folders)
+	return folders
+}
+
+// Wrap returns flushFunc that records all mails before passing them to flushFunc.
+func (s *SeenMails) Wrap(flushFunc func(mails []*Mail) error) func(mails []*Mail) error {
+	return func(mails []*Mail) error {
+		for _, mail := range mails {
+			s.Add(mail.Account, mail.Folder, mail.Uid)
+		}
+		return flushFunc(mails)
+	}
+}
+
+// stale returns true if document is in complete folder, but it was not seen in source.
+func (s *SeenMails) stale(account, folder, documentUid string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := seenFolder{account: account, folder: folder}
+	if !s.complete[key] {
+		return false
+	}
+	return !s.folders[key][documentUid]
+}
+
+// Prune deletes mails from index that are in folders seen by SeenMails, but which were not seen themselves,
+// e.g. they have been deleted or moved in mail source. If dryRun is true, nothing is deleted.
+// Returns list of deleted (or to-be-deleted) mails.
+func (m *Meilisearch) Prune(seen *SeenMails, dryRun bool) ([]*Mail, error) {
+	pageSize := int64(1000)
+	var stale []*Mail
+	var uids []string
+
+	for offset := int64(0); ; offset += pageSize {
+		var documents []map[string]interface{}
+		err := m.client.Documents(m.Index).List(meilisearch.ListDocumentsRequest{
+			Offset:               offset,
+			Limit:                pageSize,
+			AttributesToRetrieve: []string{"uid", "id", "account", "folder", "subject", "date"},
+		}, &documents)
+		if err != nil {
+			return stale, fmt.Errorf("list documents: %v", err)
+		}
+
+		for _, doc := range documents {
+			uid := getString("uid", doc)
+			account := getString("account", doc)
+			folder := getString("folder", doc)
+			if !seen.stale(account, folder, uid) {
+				continue
+			}
+			stale = append(stale, &Mail{
+				Uid:       uid,
+				Id:        getString("id", doc),
+				Account:   account,
+				Folder:    folder,
+				Subject:   getString("subject", doc),
+				Timestamp: time.Unix(getInt("date", doc), 0),
+			})
+			uids = append(uids, uid)
+		}
+
+		if int64(len(documents)) < pageSize {
+			break
+		}
+	}
+
+	if dryRun || len(uids) == 0 {
+		return stale, nil
+	}
+
+	for start := 0; start < len(uids); start += int(pageSize) {
+		stop := start + int(pageSize)
+		if stop > len(uids) {
+			stop = len(uids)
+		}
+		res, err := m.client.Documents(m.Index).Deletes(uids[start:stop])
+		if err != nil {
+			return stale, fmt.Errorf("delete documents: %v", err)
+		}
+		logrus.Debug("Meilisearch update id: ", res.UpdateID)
+	}
+	logrus.Infof("Deleted %d mails", len(uids))
+	return stale, nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeenMails_stale(t *testing.T) {
+	seen := NewSeenMails()
+	flushed := 0
+	flush := seen.Wrap(func(mails []*Mail) error {
+		flushed += len(mails)
+		return nil
+	})
+
+	err := flush([]*Mail{
+		{Uid: "a@mail", Account: "work", Folder: "Inbox"},
+		{Uid: "b@mail", Account: "work", Folder: "Inbox"},
+		{Uid: "c@mail", Account: "work", Folder: "Archive"},
+		// folder was not read completely
+		{Uid: "g@mail", Account: "work", Folder: "Drafts"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen.CompleteFolder("work", "Inbox")
+	seen.CompleteFolder("work", "Archive")
+	seen.CompleteFolder("work", "Empty")
+
+	if flushed != 4 {
+		t.Errorf("flushed %d mails, want 4", flushed)
+	}
+
+	if want := []string{"work/Archive", "work/Empty", "work/Inbox"}; !reflect.DeepEqual(seen.Folders(), want) {
+		t.Errorf("Folders() = %v, want %v", seen.Folders(), want)
+	}
+
+	tests := []struct {
+		name    string
+		account string
+		folder  string
+		uid     string
+		want    bool
+	}{
+		{name: "seen", account: "work", folder: "Inbox", uid: "a@mail", want: false},
+		{name: "moved", account: "work", folder: "Inbox", uid: "c@mail", want: true},
+		{name: "deleted", account: "work", folder: "Archive", uid: "d@mail", want: true},
+		{name: "empty folder", account: "work", folder: "Empty", uid: "e@mail", want: true},
+		{name: "unseen folder", account: "work", folder: "Sent", uid: "f@mail", want: false},
+		{name: "incomplete folder", account: "work", folder: "Drafts", uid: "h@mail", want: false},
+		{name: "unseen account", account: "home", folder: "Inbox", uid: "g@mail", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := seen.stale(tt.account, tt.folder, documentUid(tt.uid)); got != tt.want {
+				t.Errorf("stale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeenMails_WrapWithAccount(t *testing.T) {
+	seen := NewSeenMails()
+	// file sources do not set account, so WithAccount must run before mails are recorded
+	flush := WithAccount("work", seen.Wrap(func(mails []*Mail) error {
+		return nil
+	}))
+
+	err := flush([]*Mail{
+		{Uid: "a@mail", Folder: "Inbox"},
+		{Uid: "b@mail", Folder: "Inbox"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen.CompleteFolder("work", "Inbox")
+
+	if want := []string{"work/Inbox"}; !reflect.DeepEqual(seen.Folders(), want) {
+		t.Errorf("Folders() = %v, want %v", seen.Folders(), want)
+	}
+	if seen.stale("work", "Inbox", documentUid("a@mail")) {
+		t.Errorf("stale() = true for seen mail")
+	}
+	if !seen.stale("work", "Inbox", documentUid("c@mail")) {
+		t.Errorf("stale() = false for deleted mail")
+	}
+}
@@ -21,107 +21,447 @@
 package indexer
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/meilisearch/meilisearch-go"
-	"github.com/mgutz/ansi"
 	"github.com/sirupsen/logrus"
+	"net/http"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
-	"tryffel.net/go/meilindex/config"
 )
 
-var queryPattern = `([+-])?([a-zA-Z]+):(\w+|'[\w ]+')`
+// queryPattern matches inline search operators in query, e.g. 'from:alice', '-subject:"foo bar"' or '+is:unread'.
+var queryPattern = `(^|\s)([+-]?)([a-zA-Z]+):("[^"]*"|'[^']*'|[^\s"']+)`
 var queryRegex = regexp.MustCompile(queryPattern)
 
-func SearchMail(query string, filter string) {
-	ms := Meilisearch{
-		Url:    config.Conf.Meilisearch.Url,
-		Index:  config.Conf.Meilisearch.Index,
-		ApiKey: config.Conf.Meilisearch.ApiKey,
+// SearchQuery is a query with inline search operators extracted to filter.
+type SearchQuery struct {
+	// Text is the remaining free-text query.
+	Text string
+	// Filter is Meilisearch filter for operators, or empty.
+	Filter string
+}
+
+// queryOperators maps inline operators to filter conditions. Value is always quoted. Meilisearch filters only
+// match whole values, so operators that should match part of a field return empty condition, and their value
+// is searched as text instead:
+// from, to and cc match whole email address if value contains '@', else value is searched as text.
+// subject value is searched as text. Negated operators can not be searched as text, and match whole field.
+var queryOperators = map[string]func(value string, negated bool) (string, error){
+	"from":    addressCondition("from"),
+	"to":      addressCondition("to"),
+	"cc":      addressCondition("cc"),
+	"subject": textCondition("subject"),
+	"folder":  fieldCondition("folder"),
+	"account": fieldCondition("account"),
+	"before":  fieldCondition("before"),
+	"after":   fieldCondition("after"),
+	"has": func(value string, negated bool) (string, error) {
+		return "has:" + value, nil
+	},
+	"is": func(value string, negated bool) (string, error) {
+		switch strings.ToLower(value) {
+		case "unread":
+			return "seen=false", nil
+		case "read":
+			return "seen=true", nil
+		case "flagged", "starred":
+			return "flagged=true", nil
+		case "answered", "replied":
+			return "answered=true", nil
+		case "draft":
+			return "draft=true", nil
+		}
+		return "", fmt.Errorf("unknown value for 'is', expected one of unread, read, flagged, starred, " +
+			"answered, replied or draft")
+	},
+}
+
+func quoteFilterValue(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+func fieldCondition(field string) func(value string, negated bool) (string, error) {
+	return func(value string, negated bool) (string, error) {
+		return field + "=" + quoteFilterValue(value), nil
 	}
+}
 
-	err := ms.Connect()
-	if err != nil {
-		logrus.Error(err)
+// textCondition searches value as text, or excludes exact field value if negated.
+func textCondition(field string) func(value string, negated bool) (string, error) {
+	return func(value string, negated bool) (string, error) {
+		if negated {
+			return field + "=" + quoteFilterValue(value), nil
+		}
+		return "", nil
 	}
+}
 
-	mails, _, err := ms.Query(query, filter)
-	if err != nil {
-		logrus.Error(err)
-	} else {
-		yellow := ansi.ColorCode("yellow+i:black")
-		reset := ansi.ColorCode("reset")
-		for i := 0; i < len(mails); i++ {
-			mail := mails[i]
-			if strings.Contains(mail.Body, "<em>") {
-				mail.Body = strings.Replace(mail.Body, "<em>", yellow, -1)
-				mail.Body = strings.Replace(mail.Body, "</em>", reset, -1)
+// addressCondition matches whole email address, or searches name as text.
+func addressCondition(field string) func(value string, negated bool) (string, error) {
+	return func(value string, negated bool) (string, error) {
+		if strings.Contains(value, "@") {
+			return field + "_address=" + quoteFilterValue(strings.ToLower(value)), nil
+		}
+		return textCondition(field)(value, negated)
+	}
+}
+
+// ParseQuery extracts gmail-style operators from query: from:, to:, cc:, subject:, folder:, account:,
+// has:attachment, is:unread (read, flagged, answered, draft), before: and after:. Operators prefixed with '-'
+// are negated. Values with spaces must be quoted, e.g. subject:"weekly report". Values of subject: and
+// of address operators without '@' are moved to text, see queryOperators.
+// Unknown operators are kept in text. Relative dates are relative to now.
+func ParseQuery(query string, now time.Time) (*SearchQuery, error) {
+	result := &SearchQuery{}
+	var conditions []string
+	text := strings.Builder{}
+	last := 0
+
+	for _, match := range queryRegex.FindAllStringSubmatchIndex(query, -1) {
+		start := match[4]
+		sign := query[match[4]:match[5]]
+		operator := strings.ToLower(query[match[6]:match[7]])
+		value := query[match[8]:match[9]]
+		conditionFunc, ok := queryOperators[operator]
+		if !ok {
+			continue
+		}
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+			value = value[1 : len(value)-1]
+		}
+
+		condition, err := conditionFunc(value, sign == "-")
+		if err == nil && condition == "" {
+			text.WriteString(query[last:start])
+			text.WriteString(" " + value + " ")
+			last = match[1]
+			continue
+		}
+		if err == nil {
+			if sign == "-" {
+				condition = "NOT " + condition
 			}
+			var filter *Filter
+			filter, err = ParseFilter(condition, now)
+			if err == nil {
+				condition = filter.Query()
+			} else if filterErr, ok := err.(*FilterError); ok {
+				err = fmt.Errorf("%s", filterErr.Message)
+			}
+		}
+		if err != nil {
+			return nil, &FilterError{
+				Query:    query,
+				Position: match[8],
+				Message:  fmt.Sprintf("%s:%s: %v", operator, value, err),
+			}
+		}
+		conditions = append(conditions, condition)
+		text.WriteString(query[last:start])
+		last = match[1]
+	}
+	text.WriteString(query[last:])
 
-			mail.Subject = ansi.Blue + mail.Subject + ansi.Reset
-			fmt.Println("-----------------")
-			fmt.Printf("%d %s", i, mail.String())
+	result.Text = strings.Join(strings.Fields(text.String()), " ")
+	result.Filter = strings.Join(conditions, " AND ")
+	return result, nil
+}
+
+// CombineFilters combines non-empty Meilisearch filters with AND.
+func CombineFilters(filters ...string) string {
+	var parts []string
+	for _, v := range filters {
+		if strings.TrimSpace(v) != "" {
+			parts = append(parts, v)
 		}
 	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	for i, v := range parts {
+		parts[i] = "(" + v + ")"
+	}
+	return strings.Join(parts, " AND ")
 }
 
 func (m *Meilisearch) Query(query, filter string) ([]*Mail, int, error) {
+	res, err := m.Search(query, filter, SearchOptions{Limit: 100, Highlight: true})
+	if err != nil {
+		return nil, -1, err
+	}
+	return res.Mails, res.ProcessingTimeMs, nil
+}
 
-	//yellow := ansi.ColorCode("yellow+i:black")
-	//reset := ansi.ColorCode("reset")
+// SearchOptions configures search.
+type SearchOptions struct {
+	// Limit is the max number of mails to return.
+	Limit int
+	// Offset is the number of mails to skip.
+	Offset int
+	// Highlight marks matches in body, subject and sender with <em> tags.
+	Highlight bool
+	// Sort is one of SortOrders. Default is relevance.
+	Sort string
+}
+
+// SearchResult contains mails matching search.
+type SearchResult struct {
+	Mails []*Mail
+	// TotalHits is the estimated number of all matching mails. When sorting by date, it is the number of
+	// mails that were sorted.
+	TotalHits        int
+	ProcessingTimeMs int
+	// Truncated is true if more mails matched than can be sorted by date. Only the first TotalHits mails
+	// by relevance are sorted and returned.
+	Truncated bool
+}
 
+// Search returns mails matching query and filter. Empty query matches all mails.
+func (m *Meilisearch) Search(query, filter string, opts SearchOptions) (*SearchResult, error) {
+	req := meilisearch.SearchRequest{
+		Query:             query,
+		Limit:             int64(opts.Limit),
+		Offset:            int64(opts.Offset),
+		Filters:           filter,
+		PlaceholderSearch: query == "",
+	}
+	if opts.Highlight {
+		req.AttributesToHighlight = []string{"message", "subject", "from"}
+	}
+	if err := ValidateSort(opts.Sort); err != nil {
+		return nil, err
+	}
+	if opts.Sort == SortDateDesc || opts.Sort == SortDateAsc {
+		return m.searchSorted(req, opts.Sort)
+	}
+
+	res, err := m.client.Search(m.Index).Search(req)
+	if err != nil {
+		return nil, err
+	}
+	return &SearchResult{
+		Mails:            parseHits(res.Hits),
+		TotalHits:        int(res.NbHits),
+		ProcessingTimeMs: int(res.ProcessingTimeMs),
+	}, nil
+}
+
+// SearchContext runs Search and returns ctx.Err() if ctx is done before search completes.
+// Meilisearch client does not support cancelling requests, so a cancelled request still completes
+// in the background and its result is discarded.
+func (m *Meilisearch) SearchContext(ctx context.Context, query, filter string, opts SearchOptions) (*SearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		res *SearchResult
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := m.Search(query, filter, opts)
+		done <- result{res: res, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-done:
+		return r.res, r.err
+	}
+}
+
+// SearchAll pushes all mails matching query and filter to pushFunc, one page at a time. Page size is
+// opts.Limit, and first page starts from opts.Offset. Returns number of mails found.
+func (m *Meilisearch) SearchAll(query, filter string, opts SearchOptions, pushFunc func(mails []*Mail) error) (int, error) {
+	if opts.Limit <= 0 {
+		return 0, fmt.Errorf("page size must be positive")
+	}
+	total := 0
+	for {
+		res, err := m.Search(query, filter, opts)
+		if err != nil {
+			return total, err
+		}
+		if res.Truncated && total == 0 {
+			logrus.Warningf("Only first %d mails by relevance are sorted by date, refine query to get the rest",
+				res.TotalHits)
+		}
+		if len(res.Mails) == 0 {
+			return total, nil
+		}
+		err = pushFunc(res.Mails)
+		if err != nil {
+			return total, err
+		}
+		total += len(res.Mails)
+		if len(res.Mails) < opts.Limit {
+			return total, nil
+		}
+		opts.Offset += len(res.Mails)
+	}
+}
+
+// Thread returns all mails in thread, sorted by date, oldest first.
+func (m *Meilisearch) Thread(threadId string) ([]*Mail, error) {
 	res, err := m.client.Search(m.Index).Search(meilisearch.SearchRequest{
-		Query:                 query,
-		Limit:                 100,
-		AttributesToHighlight: []string{"message", "subject", "from"},
-		Filters:               filter,
+		Query:             "",
+		Limit:             1000,
+		Filters:           fmt.Sprintf("thread_id=\"%s\"", threadId),
+		PlaceholderSearch: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mails := parseHits(res.Hits)
+	sort.SliceStable(mails, func(i, j int) bool {
+		return mails[i].Timestamp.Before(mails[j].Timestamp)
 	})
+	return mails, nil
+}
 
+// threadLookupSize is the max number of message ids to look up in single request.
+const threadLookupSize = 50
+
+// ThreadIds returns message id -> thread id for indexed mails with given message ids.
+func (m *Meilisearch) ThreadIds(ids []string) (map[string]string, error) {
+	threads := map[string]string{}
+	for start := 0; start < len(ids); start += threadLookupSize {
+		end := start + threadLookupSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		conditions := make([]string, 0, end-start)
+		for _, id := range ids[start:end] {
+			conditions = append(conditions, "id="+quoteFilterValue(id))
+		}
+		res, err := m.client.Search(m.Index).Search(meilisearch.SearchRequest{
+			Query:                "",
+			Limit:                1000,
+			Filters:              strings.Join(conditions, " OR "),
+			PlaceholderSearch:    true,
+			AttributesToRetrieve: []string{"id", "thread_id"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range res.Hits {
+			doc, _ := hit.(map[string]interface{})
+			id := getString("id", doc)
+			thread := getString("thread_id", doc)
+			if id != "" && thread != "" {
+				threads[id] = thread
+			}
+		}
+	}
+	return threads, nil
+}
+
+// SubjectThreadId returns thread id of an indexed mail with normalized subject, preferring thread starters
+// over replies. Returns empty string if no mail was found.
+func (m *Meilisearch) SubjectThreadId(subject string) (string, error) {
+	res, err := m.client.Search(m.Index).Search(meilisearch.SearchRequest{
+		Query:                "",
+		Limit:                100,
+		Filters:              "thread_subject=" + quoteFilterValue(subject),
+		PlaceholderSearch:    true,
+		AttributesToRetrieve: []string{"subject", "thread_id"},
+	})
 	if err != nil {
-		return nil, -1, err
+		return "", err
+	}
+	thread := ""
+	for _, hit := range res.Hits {
+		doc, _ := hit.(map[string]interface{})
+		id := getString("thread_id", doc)
+		if id == "" {
+			continue
+		}
+		if _, isReply := normalizeSubject(getString("subject", doc)); !isReply {
+			return id, nil
+		}
+		if thread == "" {
+			thread = id
+		}
+	}
+	return thread, nil
+}
+
+// Mail returns single mail by its document uid. If no document is found, uid is treated as message id.
+func (m *Meilisearch) Mail(uid string) (*Mail, error) {
+	doc := map[string]interface{}{}
+	err := m.client.Documents(m.Index).Get(uid, &doc)
+	var meiliErr *meilisearch.Error
+	if errors.As(err, &meiliErr) && meiliErr.StatusCode == http.StatusNotFound {
+		err = m.client.Documents(m.Index).Get(documentUid(uid), &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get mail %s: %w", uid, err)
 	}
+	return parseHits([]interface{}{doc})[0], nil
+}
 
-	result := make([]*Mail, len(res.Hits))
+// parseHits converts search hits to mails. Highlighted fields are used if they exist.
+func parseHits(hits []interface{}) []*Mail {
+	result := make([]*Mail, len(hits))
 
-	for i, v := range res.Hits {
-		isMap, ok := v.(map[string]interface{})
+	for i, v := range hits {
+		isMap, _ := v.(map[string]interface{})
 		formatted := isMap["_formatted"]
-		mail := &Mail{}
-		if ok {
-			if isFormatted, ok := formatted.(map[string]interface{}); ok {
-				body := getString("message", isFormatted)
-				if body != "" {
-					mail.Body = body
-				}
-				subject := getString("subject", isFormatted)
-				if subject != "" {
-					mail.Subject = subject
-				}
-				from := getString("from", isFormatted)
-				if from != "" {
-					mail.From = from
-				}
+		mail := &Mail{
+			Body:    getString("message", isMap),
+			Subject: getString("subject", isMap),
+			From:    getString("from", isMap),
+		}
+		if isFormatted, ok := formatted.(map[string]interface{}); ok {
+			body := getString("message", isFormatted)
+			if body != "" {
+				mail.Body = body
+			}
+			subject := getString("subject", isFormatted)
+			if subject != "" {
+				mail.Subject = subject
+			}
+			from := getString("from", isFormatted)
+			if from != "" {
+				mail.From = from
 			}
-		} else {
-			mail.Body = getString("message", isMap)
-			mail.Subject = getString("subject", isMap)
-			mail.From = getString("from", isMap)
 		}
 		mail.Uid = getString("uid", isMap)
 		mail.Id = getString("id", isMap)
 		mail.To = getStringArray("to", isMap)
 		mail.Cc = getStringArray("cc", isMap)
+		mail.Bcc = getStringArray("bcc", isMap)
+		mail.FromAddress = Address{Name: getString("from", isMap), Address: getString("from_address", isMap)}
+		if mail.FromAddress.Name == mail.FromAddress.Address {
+			mail.FromAddress.Name = ""
+		}
+		mail.ToAddresses = zipAddresses(mail.To, getStringArray("to_address", isMap))
+		mail.CcAddresses = zipAddresses(mail.Cc, getStringArray("cc_address", isMap))
+		mail.BccAddresses = zipAddresses(mail.Bcc, getStringArray("bcc_address", isMap))
 		mail.Folder = getString("folder", isMap)
+		mail.Account = getString("account", isMap)
 		mail.Timestamp = time.Unix(getInt("date", isMap), 0)
-		mail.AttachmentNames = getStringArray("attachments", isMap)
+		mail.AttachmentNames = getAttachmentNames(isMap)
+		mail.Parts = getParts(isMap)
+		mail.Source = getSource(isMap)
+		// mails indexed without flags have no flags field
+		if _, ok := isMap["flags"]; ok {
+			mail.Flags = getStringArray("flags", isMap)
+		}
+		mail.InReplyTo = getString("in_reply_to", isMap)
+		mail.References = getStringArray("references", isMap)
+		mail.ThreadId = getString("thread_id", isMap)
 		result[i] = mail
 
 	}
-	//println(mail.String())
-	//println("\n\n")
-	//p//rintln("===============================================")
-	return result, int(res.ProcessingTimeMs), nil
+	return result
 }
 
 func getString(key string, container map[string]interface{}) string {
@@ -166,3 +506,68 @@ func getStringArray(key string, container map[string]interface{}) []string {
 	}
 	return out
 }
+
+// getAttachmentNames returns attachment names. Older versions stored names as comma-separated string.
+func getAttachmentNames(container map[string]interface{}) []string {
+	if names, ok := container["attachments"].(string); ok {
+		if names == "" {
+			return []string{}
+		}
+		return strings.Split(names, ",")
+	}
+	return getStringArray("attachments", container)
+}
+
+// getParts returns mime parts of mail, or nil if mail was indexed without parts.
+func getParts(container map[string]interface{}) []MimePart {
+	arr, ok := container["parts"].([]interface{})
+	if !ok {
+		return nil
+	}
+	parts := make([]MimePart, 0, len(arr))
+	for _, v := range arr {
+		if part, ok := v.(map[string]interface{}); ok {
+			parts = append(parts, MimePart{
+				MimeType: getString("mime_type", part),
+				Name:     getString("name", part),
+				Size:     int(getInt("size", part)),
+			})
+		}
+	}
+	return parts
+}
+
+// getSource returns source of mail, or nil if mail was indexed before sources were recorded.
+func getSource(container map[string]interface{}) *Source {
+	source, ok := container["source"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	out := &Source{
+		Type:        getString("type", source),
+		Account:     getString("account", source),
+		Path:        getString("path", source),
+		Offset:      -1,
+		Mailbox:     getString("mailbox", source),
+		Uid:         uint32(getInt("uid", source)),
+		UidValidity: uint32(getInt("uid_validity", source)),
+		Id:          getString("id", source),
+	}
+	if _, ok := source["offset"]; ok {
+		out.Offset = getInt("offset", source)
+	}
+	return out
+}
+
+// zipAddresses combines display names and email addresses. Names are only used if every address has a name,
+// since addresses without email are not stored.
+func zipAddresses(names, emails []string) []Address {
+	out := make([]Address, len(emails))
+	for i, v := range emails {
+		out[i] = Address{Address: v}
+		if len(names) == len(emails) && !strings.EqualFold(names[i], v) {
+			out[i].Name = names[i]
+		}
+	}
+	return out
+}
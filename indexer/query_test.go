@@ -0,0 +1,185 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package indexer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantText   string
+		wantFilter string
+		wantErr    string
+	}{
+		{
+			name:       "no operators",
+			query:      "quarterly report",
+			wantText:   "quarterly report",
+			wantFilter: "",
+		},
+		{
+			name:       "sender",
+			query:      "report from:Alice@Example.com to:bob",
+			wantText:   "report bob",
+			wantFilter: `from_address="alice@example.com"`,
+		},
+		{
+			name:       "partial values are searched as text",
+			query:      `subject:invoice from:alice cc:"Bob Smith" -to:carol 2021`,
+			wantText:   "invoice alice Bob Smith 2021",
+			wantFilter: `NOT to="carol"`,
+		},
+		{
+			name:       "quoted and negated",
+			query:      `-subject:"weekly report" budget +folder:'Sent Items' -is:read`,
+			wantText:   "budget",
+			wantFilter: `NOT subject="weekly report" AND folder="Sent Items" AND NOT seen=true`,
+		},
+		{
+			name:       "attachment and dates",
+			query:      "has:attachment invoice after:2020-01 before:today",
+			wantText:   "invoice",
+			wantFilter: `has_attachment=true AND date>1577836800 AND date<1615334400`,
+		},
+		{
+			name:       "unknown operators are text",
+			query:      "see http://example.com re:meeting is:unread",
+			wantText:   "see http://example.com re:meeting",
+			wantFilter: `seen=false`,
+		},
+		{
+			name:    "invalid is",
+			query:   "report is:important",
+			wantErr: "is:important: unknown value for 'is', expected one of unread, read, flagged, starred, answered, replied or draft",
+		},
+		{
+			name:    "invalid date",
+			query:   "report before:soon",
+			wantErr: "before:soon: invalid date 'soon', expected e.g. 2020-01-02, 2020-01, 2020, today, yesterday or -7d",
+		},
+	}
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseQuery(tt.query, now)
+			if tt.wantErr != "" {
+				filterErr, ok := err.(*FilterError)
+				if !ok || filterErr.Message != tt.wantErr {
+					t.Errorf("ParseQuery() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			if got.Text != tt.wantText {
+				t.Errorf("Text = %s, want %s", got.Text, tt.wantText)
+			}
+			if got.Filter != tt.wantFilter {
+				t.Errorf("Filter = %s, want %s", got.Filter, tt.wantFilter)
+			}
+		})
+	}
+}
+
+func TestCombineFilters(t *testing.T) {
+	tests := []struct {
+		filters []string
+		want    string
+	}{
+		{filters: []string{"", " "}, want: ""},
+		{filters: []string{"seen=false", ""}, want: "seen=false"},
+		{filters: []string{"a=1 OR b=2", "seen=false"}, want: "(a=1 OR b=2) AND (seen=false)"},
+	}
+	for _, tt := range tests {
+		if got := CombineFilters(tt.filters...); got != tt.want {
+			t.Errorf("CombineFilters(%v) = %s, want %s", tt.filters, got, tt.want)
+		}
+	}
+}
+
+func TestMeilisearch_SearchContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := &Meilisearch{}
+	res, err := m.SearchContext(ctx, "foo", "", SearchOptions{Limit: 10})
+	if err != context.Canceled {
+		t.Errorf("SearchContext() error = %v, want %v", err, context.Canceled)
+	}
+	if res != nil {
+		t.Errorf("SearchContext() result = %v, want nil", res)
+	}
+}
+
+func Test_parseHitsAttachments(t *testing.T) {
+	hits := []interface{}{
+		map[string]interface{}{
+			"uid":         "1",
+			"attachments": []interface{}{"report, march.csv", "logo.png"},
+			"parts": []interface{}{
+				map[string]interface{}{"mime_type": "text/plain", "name": "", "size": float64(12)},
+				map[string]interface{}{"mime_type": "text/csv", "name": "report, march.csv", "size": float64(2048)},
+			},
+		},
+		// indexed with older version
+		map[string]interface{}{
+			"uid":         "2",
+			"attachments": "a.pdf,b.pdf",
+		},
+		map[string]interface{}{
+			"uid":         "3",
+			"attachments": "",
+		},
+	}
+
+	mails := parseHits(hits)
+	tests := []struct {
+		wantNames []string
+		wantParts []MimePart
+	}{
+		{
+			wantNames: []string{"report, march.csv", "logo.png"},
+			wantParts: []MimePart{
+				{MimeType: "text/plain", Size: 12},
+				{MimeType: "text/csv", Name: "report, march.csv", Size: 2048},
+			},
+		},
+		{wantNames: []string{"a.pdf", "b.pdf"}},
+		{wantNames: []string{}},
+	}
+	for i, tt := range tests {
+		if !reflect.DeepEqual(mails[i].AttachmentNames, tt.wantNames) {
+			t.Errorf("mail %d attachment names = %v, want %v", i, mails[i].AttachmentNames, tt.wantNames)
+		}
+		if !reflect.DeepEqual(mails[i].Parts, tt.wantParts) {
+			t.Errorf("mail %d parts = %v, want %v", i, mails[i].Parts, tt.wantParts)
+		}
+	}
+	if got := mails[0].Parts[1].String(); got != "report, march.csv (text/csv, 2.0 kB)" {
+		t.Errorf("MimePart.String() = %q, want %q", got, "report, march.csv (text/csv, 2.0 kB)")
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package indexer
+
+import (
+	"fmt"
+	"github.com/meilisearch/meilisearch-go"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Sort orders
+const (
+	SortRelevance = "relevance"
+	SortDateDesc  = "date:desc"
+	SortDateAsc   = "date:asc"
+)
+
+// SortOrders lists supported sort orders.
+var SortOrders = []string{SortRelevance, SortDateDesc, SortDateAsc}
+
+// SortDescription returns human-readable description of sort order.
+func SortDescription(order string) string {
+	switch order {
+	case SortDateDesc:
+		return "newest first"
+	case SortDateAsc:
+		return "oldest first"
+	default:
+		return "by relevance"
+	}
+}
+
+// ValidateSort returns error if order is not supported. Empty order is relevance.
+func ValidateSort(order string) error {
+	if order == "" {
+		return nil
+	}
+	for _, v := range SortOrders {
+		if v == order {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sort order '%s', supported orders: %s", order, strings.Join(SortOrders, ", "))
+}
+
+// sortMaxHits is the max number of hits that are sorted by date. Remaining hits are not returned, and
+// result is marked as truncated.
+const sortMaxHits = 10000
+
+// sortPageSize is page size when fetching hits to sort.
+const sortPageSize = 1000
+
+// sortedHit is a minimal search hit used for sorting.
+type sortedHit struct {
+	uid  string
+	date int64
+}
+
+// sortedHits caches last sorted result, so that consecutive pages do not need to fetch all hits again.
+type sortedHits struct {
+	lock sync.Mutex
+	key  string
+	hits []sortedHit
+	// total is the number of all matching mails.
+	total int
+}
+
+// Meilisearch (before v0.23) can only sort with index-wide ranking rules. Thus sorting by date is done here:
+// uid and date of all matching mails are fetched and sorted, and requested page of mails is then fetched by uid.
+func (m *Meilisearch) searchSorted(req meilisearch.SearchRequest, order string) (*SearchResult, error) {
+	hits, total, err := m.sortedHits(req, order)
+	if err != nil {
+		return nil, err
+	}
+	// pages after sorted hits would be empty, so report only hits that were sorted.
+	truncated := total > len(hits)
+	total = len(hits)
+
+	start := int(req.Offset)
+	if start > len(hits) {
+		start = len(hits)
+	}
+	stop := start + int(req.Limit)
+	if stop > len(hits) {
+		stop = len(hits)
+	}
+	page := hits[start:stop]
+	if len(page) == 0 {
+		return &SearchResult{Mails: []*Mail{}, TotalHits: total, Truncated: truncated}, nil
+	}
+
+	uids := make([]string, len(page))
+	position := make(map[string]int, len(page))
+	for i, v := range page {
+		uids[i] = fmt.Sprintf("uid=\"%s\"", v.uid)
+		position[v.uid] = i
+	}
+	req.Filters = strings.Join(uids, " OR ")
+	req.Offset = 0
+	req.Limit = int64(len(page))
+
+	res, err := m.client.Search(m.Index).Search(req)
+	if err != nil {
+		return nil, err
+	}
+	mails := parseHits(res.Hits)
+	sort.SliceStable(mails, func(i, j int) bool {
+		return position[mails[i].Uid] < position[mails[j].Uid]
+	})
+	return &SearchResult{
+		Mails:            mails,
+		TotalHits:        total,
+		ProcessingTimeMs: int(res.ProcessingTimeMs),
+		Truncated:        truncated,
+	}, nil
+}
+
+// sortedHits returns hits for request sorted by order, and estimated number of all hits. At most sortMaxHits
+// hits are returned.
+func (m *Meilisearch) sortedHits(req meilisearch.SearchRequest, order string) ([]sortedHit, int, error) {
+	m.sorted.lock.Lock()
+	defer m.sorted.lock.Unlock()
+
+	key := fmt.Sprintf("%s\x00%s\x00%s", req.Query, req.Filters, order)
+	if m.sorted.key == key && req.Offset > 0 {
+		return m.sorted.hits, m.sorted.total, nil
+	}
+
+	var hits []sortedHit
+	total := 0
+	for offset := 0; offset < sortMaxHits; offset += sortPageSize {
+		res, err := m.client.Search(m.Index).Search(meilisearch.SearchRequest{
+			Query:                req.Query,
+			Offset:               int64(offset),
+			Limit:                sortPageSize,
+			Filters:              req.Filters,
+			AttributesToRetrieve: []string{"uid", "date"},
+			PlaceholderSearch:    req.PlaceholderSearch,
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		total = int(res.NbHits)
+		for _, v := range res.Hits {
+			hit, _ := v.(map[string]interface{})
+			hits = append(hits, sortedHit{uid: getString("uid", hit), date: getInt("date", hit)})
+		}
+		if len(res.Hits) < sortPageSize {
+			break
+		}
+	}
+
+	sortHits(hits, order)
+	if total < len(hits) {
+		total = len(hits)
+	}
+	m.sorted.key = key
+	m.sorted.hits = hits
+	m.sorted.total = total
+	return hits, total, nil
+}
+
+// sortHits sorts hits by order. Hits with same date keep their relevance order.
+func sortHits(hits []sortedHit, order string) {
+	sort.SliceStable(hits, func(i, j int) bool {
+		if order == SortDateAsc {
+			return hits[i].date < hits[j].date
+		}
+		return hits[i].date > hits[j].date
+	})
+}
@@ -0,0 +1,134 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/meilisearch/meilisearch-go"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func Test_sortHits(t *testing.T) {
+	hits := func() []sortedHit {
+		return []sortedHit{{"a", 20}, {"b", 10}, {"c", 30}, {"d", 20}}
+	}
+	tests := []struct {
+		name  string
+		order string
+		want  []string
+	}{
+		{
+			name:  "newest first",
+			order: SortDateDesc,
+			want:  []string{"c", "a", "d", "b"},
+		},
+		{
+			name:  "oldest first",
+			order: SortDateAsc,
+			want:  []string{"b", "a", "d", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hits()
+			sortHits(got, tt.order)
+			uids := make([]string, len(got))
+			for i, v := range got {
+				uids[i] = v.uid
+			}
+			if !reflect.DeepEqual(uids, tt.want) {
+				t.Errorf("sortHits() = %v, want %v", uids, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSort(t *testing.T) {
+	for _, order := range append(SortOrders, "") {
+		if err := ValidateSort(order); err != nil {
+			t.Errorf("ValidateSort(%s): %v", order, err)
+		}
+	}
+	if err := ValidateSort("date"); err == nil {
+		t.Error("ValidateSort(date): expected error")
+	}
+}
+
+func TestMeilisearch_searchSortedTruncated(t *testing.T) {
+	const matching = 25000
+	uidPattern := regexp.MustCompile(`uid="m(\d+)"`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Offset  int
+			Limit   int
+			Filters string
+		}{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		hits := []map[string]interface{}{}
+		if matches := uidPattern.FindAllStringSubmatch(req.Filters, -1); len(matches) > 0 {
+			for _, v := range matches {
+				hits = append(hits, map[string]interface{}{"uid": "m" + v[1], "subject": v[1]})
+			}
+		} else {
+			for i := req.Offset; i < req.Offset+req.Limit && i < matching; i++ {
+				hits = append(hits, map[string]interface{}{"uid": fmt.Sprintf("m%d", i), "date": i})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"hits": hits, "nbHits": matching})
+	}))
+	defer server.Close()
+
+	m := &Meilisearch{Index: "mail", client: meilisearch.NewClient(meilisearch.Config{Host: server.URL})}
+	tests := []struct {
+		name      string
+		offset    int
+		wantFirst string
+		wantCount int
+	}{
+		{name: "first page", offset: 0, wantFirst: "m9999", wantCount: 10},
+		{name: "last sorted page", offset: sortMaxHits - 5, wantFirst: "m4", wantCount: 5},
+		{name: "past sorted hits", offset: sortMaxHits, wantCount: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := m.Search("", "", SearchOptions{Limit: 10, Offset: tt.offset, Sort: SortDateDesc})
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			if res.TotalHits != sortMaxHits || !res.Truncated {
+				t.Errorf("Search() TotalHits = %d, Truncated = %v, want %d, true", res.TotalHits, res.Truncated,
+					sortMaxHits)
+			}
+			if len(res.Mails) != tt.wantCount {
+				t.Fatalf("Search() returned %d mails, want %d", len(res.Mails), tt.wantCount)
+			}
+			if tt.wantCount > 0 && res.Mails[0].Uid != tt.wantFirst {
+				t.Errorf("Search() first mail = %s, want %s", res.Mails[0].Uid, tt.wantFirst)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/mail"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Source types
+const (
+	SourceMbox       = "mbox"
+	SourceMaildir    = "maildir"
+	SourceImap       = "imap"
+	SourceMailspring = "mailspring"
+)
+
+// ErrNoRawMessage is returned when original message cannot be read from source.
+var ErrNoRawMessage = errors.New("raw message not available")
+
+// Source describes where mail was read from, so that the original message can be read again.
+type Source struct {
+	// Type is one of mbox, maildir, imap or mailspring.
+	Type    string `json:"type"`
+	Account string `json:"account"`
+	// Path is mbox file, maildir file or mailspring database.
+	Path string `json:"path,omitempty"`
+	// Offset is position of the 'From ' line of message in mbox file, or -1 if it is not known.
+	Offset int64 `json:"offset"`
+	// Mailbox, Uid and UidValidity identify imap message.
+	Mailbox     string `json:"mailbox,omitempty"`
+	Uid         uint32 `json:"uid,omitempty"`
+	UidValidity uint32 `json:"uid_validity,omitempty"`
+	// Id is mailspring message id.
+	Id string `json:"id,omitempty"`
+}
+
+// String returns human-readable location of mail.
+func (s *Source) String() string {
+	switch s.Type {
+	case SourceMbox:
+		return fmt.Sprintf("mbox %s at offset %d", s.Path, s.Offset)
+	case SourceMaildir:
+		return fmt.Sprintf("maildir %s", s.Path)
+	case SourceImap:
+		return fmt.Sprintf("imap %s/%s uid %d (validity %d)", s.Account, s.Mailbox, s.Uid, s.UidValidity)
+	case SourceMailspring:
+		return fmt.Sprintf("mailspring %s id %s", s.Path, s.Id)
+	}
+	return "unknown"
+}
+
+// ReadRaw reads original RFC822 message from mbox file or maildir. Imap messages must be fetched with
+// Imap.FetchRaw, and mailspring does not store raw messages at all.
+func ReadRaw(source *Source) ([]byte, error) {
+	if source == nil {
+		return nil, fmt.Errorf("%w: source not recorded, reindex mail", ErrNoRawMessage)
+	}
+	switch source.Type {
+	case SourceMbox:
+		if source.Offset < 0 {
+			return nil, fmt.Errorf("%w: offset in mbox not known", ErrNoRawMessage)
+		}
+		return readMboxMessage(source.Path, source.Offset)
+	case SourceMaildir:
+		return readMaildirMessage(source.Path)
+	case SourceImap:
+		return nil, fmt.Errorf("imap messages must be fetched from server")
+	}
+	return nil, fmt.Errorf("%w: source type '%s'", ErrNoRawMessage, source.Type)
+}
+
+// ReadAttachments parses attachments from original message. Attachments are named like in AttachmentCache.
+func ReadAttachments(raw []byte) ([]Attachment, error) {
+	reader, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse message: %v", err)
+	}
+	// errors from headers do not affect attachments
+	message, _ := readMessage(reader)
+	for i := range message.Attachments {
+		message.Attachments[i].Name = attachmentFileName(message.Attachments[i].Name, i)
+	}
+	return message.Attachments, nil
+}
+
+// mboxOffsets scans mbox file from offset start, which must be at beginning of a message, and returns offsets
+// of messages by their message id, and offset of the last message.
+// Message starts with a 'From ' line at beginning of file or after an empty line.
+func mboxOffsets(file string, start int64) (map[string]int64, int64, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, start, err
+	}
+	defer fd.Close()
+	_, err = fd.Seek(start, io.SeekStart)
+	if err != nil {
+		return nil, start, err
+	}
+
+	offsets := map[string]int64{}
+	reader := bufio.NewReader(fd)
+	offset := start
+	last := start
+	prevEmpty := true
+	inHeader := false
+	inId := false
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			trimmed := strings.TrimRight(line, "\r\n")
+			if prevEmpty && strings.HasPrefix(line, "From ") {
+				last = offset
+				inHeader = true
+				inId = false
+			} else if inHeader {
+				isContinuation := strings.HasPrefix(trimmed, " ") || strings.HasPrefix(trimmed, "\t")
+				id := ""
+				if trimmed == "" {
+					inHeader = false
+				} else if inId && isContinuation {
+					id = trimmed
+				} else if strings.HasPrefix(strings.ToLower(trimmed), "message-id:") {
+					id = trimmed[len("message-id:"):]
+					inId = true
+				} else {
+					inId = false
+				}
+
+				id = strings.Trim(id, " \t<>")
+				if id != "" {
+					inId = false
+					if _, ok := offsets[id]; !ok {
+						offsets[id] = last
+					}
+				}
+			}
+			prevEmpty = trimmed == ""
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return offsets, last, err
+		}
+	}
+	return offsets, last, nil
+}
+
+// isMboxMessage returns true if a message starts at offset in mbox file.
+func isMboxMessage(file string, offset int64) bool {
+	fd, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+	line := make([]byte, len("From "))
+	_, err = fd.ReadAt(line, offset)
+	return err == nil && string(line) == "From "
+}
+
+// readMboxMessage reads message starting at offset from mbox file. The 'From ' line is not included.
+func readMboxMessage(file string, offset int64) ([]byte, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	_, err = fd.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(fd)
+	first, err := reader.ReadString('\n')
+	if !strings.HasPrefix(first, "From ") {
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("no message at offset %d, mbox has changed", offset)
+	}
+
+	buf := &bytes.Buffer{}
+	prevEmpty := false
+	for {
+		line, err := reader.ReadString('\n')
+		if prevEmpty && strings.HasPrefix(line, "From ") {
+			break
+		}
+		buf.WriteString(line)
+		prevEmpty = strings.TrimRight(line, "\r\n") == ""
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// empty line before next message belongs to mbox format
+	data := buf.Bytes()
+	if bytes.HasSuffix(data, []byte("\r\n\r\n")) {
+		data = data[:len(data)-2]
+	} else if bytes.HasSuffix(data, []byte("\n\n")) {
+		data = data[:len(data)-1]
+	}
+	return data, nil
+}
+
+// readMaildirMessage reads message file. Maildir stores flags in file name, so if file has been renamed
+// since indexing, a file with same unique name is searched from cur and new directories.
+func readMaildirMessage(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err == nil || !os.IsNotExist(err) {
+		return data, err
+	}
+
+	uniqueName, _ := parseMaildirName(filepath.Base(file))
+	dir := filepath.Dir(filepath.Dir(file))
+	for _, sub := range []string{"cur", "new"} {
+		files, readErr := ioutil.ReadDir(filepath.Join(dir, sub))
+		if readErr != nil {
+			continue
+		}
+		for _, v := range files {
+			if name, _ := parseMaildirName(v.Name()); name == uniqueName {
+				return ioutil.ReadFile(filepath.Join(dir, sub, v.Name()))
+			}
+		}
+	}
+	return nil, err
+}
+
+// FetchRaw fetches original message from imap server. Mailbox is selected if needed, and uid validity
+// is checked to make sure uid still refers to same message. Message is not marked as seen.
+func (i *Imap) FetchRaw(source *Source) ([]byte, error) {
+	if i.mailbox == nil || i.mailbox.Name != source.Mailbox {
+		err := i.SelectMailbox(source.Mailbox)
+		if err != nil {
+			return nil, fmt.Errorf("select mailbox: %v", err)
+		}
+	}
+	if source.UidValidity != 0 && i.mailbox.UidValidity != source.UidValidity {
+		return nil, fmt.Errorf("uid validity of mailbox %s has changed, reindex mailbox", source.Mailbox)
+	}
+
+	sequence := &imap.SeqSet{}
+	sequence.AddNum(source.Uid)
+	section := &imap.BodySectionName{Peek: true}
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- i.client.UidFetch(sequence, []imap.FetchItem{section.FetchItem()}, messages)
+	}()
+
+	var data []byte
+	var err error
+	for msg := range messages {
+		if body := msg.GetBody(section); body != nil {
+			data, err = ioutil.ReadAll(body)
+		}
+	}
+	if fetchErr := <-done; fetchErr != nil {
+		return nil, fmt.Errorf("fetch mail: %v", fetchErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read mail: %v", err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("mail %d not found in mailbox %s", source.Uid, source.Mailbox)
+	}
+	return data, nil
+}
@@ -0,0 +1,218 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testMbox = `From alice@example.com Mon Jan  4 10:00:00 2021
+Message-ID: <1@example.com>
+Subject: first
+
+hello
+>From the start
+
+From bob@example.com Mon Jan  4 11:00:00 2021
+Subject: second
+Message-ID:
+ <2@example.com>
+
+see below
+From here on, not a new message
+
+From carol@example.com Mon Jan  4 12:00:00 2021
+Message-Id: <3@example.com>
+Subject: third
+
+bye
+`
+
+func TestReadMboxMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "Inbox")
+	if err := ioutil.WriteFile(file, []byte(testMbox), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	offsets, last, err := mboxOffsets(file, 0)
+	if err != nil {
+		t.Fatalf("mboxOffsets() error = %v", err)
+	}
+	want := map[string]int64{"1@example.com": 0, "2@example.com": 115, "3@example.com": 250}
+	if !reflect.DeepEqual(offsets, want) || last != 250 {
+		t.Errorf("mboxOffsets() = %v, %d, want %v, 250", offsets, last, want)
+	}
+	// continue from second message
+	offsets, last, err = mboxOffsets(file, 115)
+	if err != nil {
+		t.Fatalf("mboxOffsets() error = %v", err)
+	}
+	want = map[string]int64{"2@example.com": 115, "3@example.com": 250}
+	if !reflect.DeepEqual(offsets, want) || last != 250 {
+		t.Errorf("mboxOffsets(115) = %v, %d, want %v, 250", offsets, last, want)
+	}
+	if !isMboxMessage(file, 115) || isMboxMessage(file, 116) {
+		t.Errorf("isMboxMessage() does not match message start")
+	}
+
+	tests := []struct {
+		name    string
+		offset  int64
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "first",
+			offset: 0,
+			want:   "Message-ID: <1@example.com>\nSubject: first\n\nhello\n>From the start\n",
+		},
+		{
+			name:   "body with from line",
+			offset: 115,
+			want: "Subject: second\nMessage-ID:\n <2@example.com>\n\nsee below\n" +
+				"From here on, not a new message\n",
+		},
+		{
+			name:   "last",
+			offset: 250,
+			want:   "Message-Id: <3@example.com>\nSubject: third\n\nbye\n",
+		},
+		{
+			name:    "invalid offset",
+			offset:  10,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadRaw(&Source{Type: SourceMbox, Path: file, Offset: tt.offset})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadRaw() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ReadRaw() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMaildirMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meilindex-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	message := []byte("Subject: test\n\nbody\n")
+	// mail was indexed as new, and has since been read
+	indexed := filepath.Join(dir, "new", "1610000000.1234.host")
+	if err := ioutil.WriteFile(filepath.Join(dir, "cur", "1610000000.1234.host:2,S"), message, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadRaw(&Source{Type: SourceMaildir, Path: indexed})
+	if err != nil {
+		t.Fatalf("ReadRaw() error = %v", err)
+	}
+	if string(got) != string(message) {
+		t.Errorf("ReadRaw() = %q, want %q", got, message)
+	}
+
+	_, err = ReadRaw(&Source{Type: SourceMaildir, Path: filepath.Join(dir, "cur", "missing:2,S")})
+	if !os.IsNotExist(err) {
+		t.Errorf("ReadRaw() missing file error = %v", err)
+	}
+}
+
+func TestReadAttachments(t *testing.T) {
+	message := "From: alice@example.com\r\n" +
+		"Subject: report\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b\"\r\n" +
+		"\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"see attached\r\n" +
+		"--b\r\n" +
+		"Content-Type: text/csv\r\n" +
+		"Content-Disposition: attachment; filename=\"../report.csv\"\r\n" +
+		"\r\n" +
+		"a,b\r\n" +
+		"--b--\r\n"
+
+	got, err := ReadAttachments([]byte(message))
+	if err != nil {
+		t.Fatalf("ReadAttachments() error = %v", err)
+	}
+	want := []Attachment{{Name: "report.csv", MimeType: "text/csv", Data: []byte("a,b")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAttachments() = %v, want %v", got, want)
+	}
+}
+
+func Test_getSource(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  map[string]interface{}
+		want *Source
+	}{
+		{
+			name: "not recorded",
+			doc:  map[string]interface{}{},
+		},
+		{
+			name: "mbox",
+			doc: map[string]interface{}{"source": map[string]interface{}{
+				"type": "mbox", "account": "work", "path": "/mail/Inbox", "offset": float64(0)}},
+			want: &Source{Type: SourceMbox, Account: "work", Path: "/mail/Inbox"},
+		},
+		{
+			name: "imap",
+			doc: map[string]interface{}{"source": map[string]interface{}{
+				"type": "imap", "account": "work", "offset": float64(-1), "mailbox": "INBOX",
+				"uid": float64(42), "uid_validity": float64(7)}},
+			want: &Source{Type: SourceImap, Account: "work", Offset: -1, Mailbox: "INBOX", Uid: 42, UidValidity: 7},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getSource(tt.doc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getSource() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,280 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// replyPrefix matches reply and forward prefixes in subject, e.g. 'Re: ', 'Fwd: ', 'RE[2]: ' or 'Aw: '.
+var replyPrefix = regexp.MustCompile(`(?i)^\s*((re|fwd?|aw|sv|vs)\s*(\[\d+\]|\(\d+\))?\s*:\s*)+`)
+
+// normalizeSubject strips reply prefixes and whitespace from subject. Returns normalized subject and
+// whether subject had reply prefix.
+func normalizeSubject(subject string) (string, bool) {
+	stripped := replyPrefix.ReplaceAllString(subject, "")
+	isReply := stripped != subject
+	return strings.ToLower(strings.Join(strings.Fields(stripped), " ")), isReply
+}
+
+// Threader groups mails into conversations with a simplified JWZ threading algorithm,
+// see https://www.jwz.org/doc/threading.html.
+// Parent of each mail is read from In-Reply-To and References headers, and thread root is the topmost
+// ancestor. Mails without any references are grouped by normalized subject, if they look like replies
+// and do not start a thread themselves.
+// Threader remembers all mails it has seen, so mails that are indexed in separate batches
+// get the same thread id. Ancestors and subjects that threader has not seen are looked up from index,
+// so that mails indexed in separate runs get the same thread id too.
+type Threader struct {
+	lock   sync.Mutex
+	lookup ThreadLookup
+	// parents contains message id -> parent message id
+	parents map[string]string
+	// replied contains message ids that have replies
+	replied map[string]bool
+	// threads contains message id -> thread id for threaded mails and ancestors looked up from index.
+	// Thread id is empty if ancestor was not found from index.
+	threads map[string]string
+	// subjects contains normalized subject -> thread id
+	subjects map[string]string
+	// starters contains subjects whose root is a thread starter, not a reply.
+	starters map[string]bool
+	// lookedUp contains subjects that have been looked up from index.
+	lookedUp map[string]bool
+}
+
+// ThreadLookup finds thread ids of already indexed mails.
+type ThreadLookup interface {
+	// ThreadIds returns message id -> thread id for indexed mails with given message ids.
+	ThreadIds(ids []string) (map[string]string, error)
+	// SubjectThreadId returns thread id of an indexed mail with normalized subject, or empty string
+	// if there is none. Thread starters are preferred over replies.
+	SubjectThreadId(subject string) (string, error)
+}
+
+// NewThreader creates new threader. If lookup is nil, only mails threaded with this threader are
+// considered.
+func NewThreader(lookup ThreadLookup) *Threader {
+	return &Threader{
+		lookup:   lookup,
+		parents:  map[string]string{},
+		replied:  map[string]bool{},
+		threads:  map[string]string{},
+		subjects: map[string]string{},
+		starters: map[string]bool{},
+		lookedUp: map[string]bool{},
+	}
+}
+
+// Thread sets ThreadId to all mails that do not have it already.
+func (t *Threader) Thread(mails []*Mail) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, mail := range mails {
+		if mail.ThreadId == "" && mail.Id != "" {
+			t.link(mail)
+		}
+	}
+	t.lookupAncestors(mails)
+
+	// Subjects of thread starters must be known before replies without references are grouped.
+	var replies []*Mail
+	for _, mail := range mails {
+		if mail.ThreadId != "" {
+			t.remember(mail)
+			continue
+		}
+		if mail.Id != "" && t.parents[mail.Id] != "" {
+			mail.ThreadId = t.ancestorThread(mail.Id)
+			t.remember(mail)
+			continue
+		}
+
+		subject, isReply := normalizeSubject(mail.Subject)
+		if mail.Id == "" || (isReply && !t.replied[mail.Id]) {
+			replies = append(replies, mail)
+			continue
+		}
+		mail.ThreadId = threadId(mail.Id)
+		if subject != "" && !t.starters[subject] {
+			t.subjects[subject] = mail.ThreadId
+			t.starters[subject] = true
+		}
+		t.remember(mail)
+	}
+
+	t.lookupSubjects(replies)
+	for _, mail := range replies {
+		subject, _ := normalizeSubject(mail.Subject)
+		thread, ok := t.subjects[subject]
+		if !ok || subject == "" {
+			root := mail.Id
+			if root == "" {
+				root = "subject:" + subject
+			}
+			thread = threadId(root)
+			if subject != "" {
+				t.subjects[subject] = thread
+			}
+		}
+		mail.ThreadId = thread
+		t.remember(mail)
+	}
+}
+
+// remember records thread id of mail, so that replies in later batches join the same thread.
+func (t *Threader) remember(mail *Mail) {
+	if mail.Id != "" {
+		t.threads[mail.Id] = mail.ThreadId
+	}
+}
+
+// ancestorThread returns thread id of the closest ancestor of id whose thread is known, or thread id of
+// topmost ancestor.
+func (t *Threader) ancestorThread(id string) string {
+	for parent := t.parents[id]; parent != ""; parent = t.parents[parent] {
+		if thread := t.threads[parent]; thread != "" {
+			return thread
+		}
+	}
+	return threadId(t.root(id))
+}
+
+// lookupAncestors looks up thread ids of ancestors that are not in mails and whose thread is not known yet.
+func (t *Threader) lookupAncestors(mails []*Mail) {
+	if t.lookup == nil {
+		return
+	}
+	inBatch := map[string]bool{}
+	for _, mail := range mails {
+		inBatch[mail.Id] = true
+	}
+	var ids []string
+	for _, mail := range mails {
+		if mail.ThreadId != "" || mail.Id == "" {
+			continue
+		}
+		for parent := t.parents[mail.Id]; parent != ""; parent = t.parents[parent] {
+			thread, ok := t.threads[parent]
+			if thread != "" {
+				break
+			}
+			if !ok && !inBatch[parent] {
+				ids = append(ids, parent)
+				// mark as unknown until looked up, this also removes duplicates.
+				t.threads[parent] = ""
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	threads, err := t.lookup.ThreadIds(ids)
+	if err != nil {
+		logrus.Warningf("lookup threads of %d mails: %v", len(ids), err)
+		for _, id := range ids {
+			delete(t.threads, id)
+		}
+		return
+	}
+	for _, id := range ids {
+		t.threads[id] = threads[id]
+	}
+}
+
+// lookupSubjects looks up thread ids of subjects of replies that are not known yet.
+func (t *Threader) lookupSubjects(replies []*Mail) {
+	if t.lookup == nil {
+		return
+	}
+	for _, mail := range replies {
+		subject, _ := normalizeSubject(mail.Subject)
+		if subject == "" || t.lookedUp[subject] {
+			continue
+		}
+		if _, ok := t.subjects[subject]; ok {
+			continue
+		}
+		thread, err := t.lookup.SubjectThreadId(subject)
+		if err != nil {
+			logrus.Warningf("lookup thread of subject '%s': %v", subject, err)
+			return
+		}
+		t.lookedUp[subject] = true
+		if thread != "" {
+			t.subjects[subject] = thread
+		}
+	}
+}
+
+// link records parent relations from mail's references. References are ordered from thread root to
+// direct parent. In-Reply-To is used as parent, if references are missing.
+func (t *Threader) link(mail *Mail) {
+	refs := mail.References
+	if mail.InReplyTo != "" && (len(refs) == 0 || refs[len(refs)-1] != mail.InReplyTo) {
+		refs = append(refs[:len(refs):len(refs)], mail.InReplyTo)
+	}
+
+	for i := 1; i < len(refs); i++ {
+		if _, ok := t.parents[refs[i]]; !ok {
+			t.setParent(refs[i], refs[i-1])
+		}
+	}
+	if len(refs) > 0 {
+		// mail's own references are authoritative for its parent.
+		t.setParent(mail.Id, refs[len(refs)-1])
+	}
+}
+
+// setParent sets parent of child, unless it would create a loop.
+func (t *Threader) setParent(child, parent string) {
+	if child == parent {
+		return
+	}
+	for id := parent; id != ""; id = t.parents[id] {
+		if id == child {
+			return
+		}
+	}
+	t.parents[child] = parent
+	t.replied[parent] = true
+}
+
+// root returns topmost ancestor of id.
+func (t *Threader) root(id string) string {
+	for {
+		parent, ok := t.parents[id]
+		if !ok || parent == "" {
+			return id
+		}
+		id = parent
+	}
+}
+
+// threadId returns thread id for root message id. Like document uid, it contains only ascii characters.
+func threadId(root string) string {
+	return documentUid(root)
+}
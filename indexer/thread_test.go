@@ -0,0 +1,154 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import "testing"
+
+func Test_normalizeSubject(t *testing.T) {
+	tests := []struct {
+		subject     string
+		want        string
+		wantIsReply bool
+	}{
+		{subject: "Meeting notes", want: "meeting notes", wantIsReply: false},
+		{subject: "Re: Meeting notes", want: "meeting notes", wantIsReply: true},
+		{subject: "RE: Fwd:  Meeting   notes ", want: "meeting notes", wantIsReply: true},
+		{subject: "Re[2]: Meeting notes", want: "meeting notes", wantIsReply: true},
+		{subject: "AW: Meeting notes", want: "meeting notes", wantIsReply: true},
+		{subject: "Return policy", want: "return policy", wantIsReply: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			got, isReply := normalizeSubject(tt.subject)
+			if got != tt.want {
+				t.Errorf("normalizeSubject() = %v, want %v", got, tt.want)
+			}
+			if isReply != tt.wantIsReply {
+				t.Errorf("normalizeSubject() isReply = %v, want %v", isReply, tt.wantIsReply)
+			}
+		})
+	}
+}
+
+func TestThreader_Thread(t *testing.T) {
+	root := &Mail{Id: "root@mail", Subject: "Plans"}
+	reply := &Mail{Id: "reply@mail", Subject: "Re: Plans", InReplyTo: "root@mail"}
+	replyToReply := &Mail{Id: "reply2@mail", Subject: "Re: Plans",
+		InReplyTo: "reply@mail", References: []string{"root@mail", "reply@mail"}}
+	// parent is not indexed, but references contain thread root
+	missingParent := &Mail{Id: "reply4@mail", Subject: "Re: Plans",
+		References: []string{"root@mail", "reply3@mail"}}
+	// broken client, no references
+	noReferences := &Mail{Id: "reply5@mail", Subject: "RE: plans"}
+	other := &Mail{Id: "other@mail", Subject: "Other plans"}
+	noId := &Mail{Subject: "Other plans"}
+	preset := &Mail{Id: "preset@mail", Subject: "Plans", ThreadId: "preset"}
+
+	threader := NewThreader(nil)
+	// thread in two batches, replies first
+	threader.Thread([]*Mail{replyToReply, other})
+	threader.Thread([]*Mail{missingParent, noReferences, root, reply, noId, preset})
+
+	want := threadId("root@mail")
+	for _, mail := range []*Mail{root, reply, replyToReply, missingParent, noReferences} {
+		if mail.ThreadId != want {
+			t.Errorf("mail %s: thread id = %s, want %s", mail.Id, mail.ThreadId, want)
+		}
+	}
+
+	if other.ThreadId != threadId("other@mail") {
+		t.Errorf("unrelated mail: thread id = %s, want %s", other.ThreadId, threadId("other@mail"))
+	}
+	if noId.ThreadId != other.ThreadId {
+		t.Errorf("mail without id: thread id = %s, want %s", noId.ThreadId, other.ThreadId)
+	}
+	if preset.ThreadId != "preset" {
+		t.Errorf("preset thread id changed to %s", preset.ThreadId)
+	}
+}
+
+func TestThreader_loop(t *testing.T) {
+	a := &Mail{Id: "a@mail", References: []string{"b@mail"}}
+	b := &Mail{Id: "b@mail", References: []string{"a@mail"}}
+
+	threader := NewThreader(nil)
+	threader.Thread([]*Mail{a, b})
+	if a.ThreadId != b.ThreadId {
+		t.Errorf("thread ids differ: %s, %s", a.ThreadId, b.ThreadId)
+	}
+}
+
+// threadIndex is an in-memory ThreadLookup.
+type threadIndex []*Mail
+
+func (t threadIndex) ThreadIds(ids []string) (map[string]string, error) {
+	threads := map[string]string{}
+	for _, id := range ids {
+		for _, mail := range t {
+			if mail.Id == id {
+				threads[id] = mail.ThreadId
+			}
+		}
+	}
+	return threads, nil
+}
+
+func (t threadIndex) SubjectThreadId(subject string) (string, error) {
+	thread := ""
+	for _, mail := range t {
+		normalized, isReply := normalizeSubject(mail.Subject)
+		if normalized != subject {
+			continue
+		}
+		if !isReply {
+			return mail.ThreadId, nil
+		}
+		if thread == "" {
+			thread = mail.ThreadId
+		}
+	}
+	return thread, nil
+}
+
+func TestThreader_separateRuns(t *testing.T) {
+	root := &Mail{Id: "root@mail", Subject: "Plans"}
+	reply := &Mail{Id: "reply@mail", Subject: "Re: Plans", InReplyTo: "root@mail"}
+	// indexed in later runs, each with a new threader
+	replyToReply := &Mail{Id: "reply2@mail", Subject: "Re: Plans", InReplyTo: "reply@mail"}
+	noReferences := &Mail{Id: "reply3@mail", Subject: "RE: plans"}
+	other := &Mail{Id: "other@mail", Subject: "Re: Other plans", InReplyTo: "missing@mail"}
+
+	index := threadIndex{}
+	for _, batch := range [][]*Mail{{root, reply}, {replyToReply}, {noReferences, other}} {
+		NewThreader(&index).Thread(batch)
+		index = append(index, batch...)
+	}
+
+	want := threadId("root@mail")
+	for _, mail := range []*Mail{root, reply, replyToReply, noReferences} {
+		if mail.ThreadId != want {
+			t.Errorf("mail %s: thread id = %s, want %s", mail.Id, mail.ThreadId, want)
+		}
+	}
+	if want := threadId("missing@mail"); other.ThreadId != want {
+		t.Errorf("mail with missing parent: thread id = %s, want %s", other.ThreadId, want)
+	}
+}
@@ -0,0 +1,394 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"tryffel.net/go/meilindex/external"
+)
+
+const (
+	// imapIdleTimeout is the max time to idle before restarting IDLE. Servers may drop connections
+	// that have been idle for 30 minutes.
+	imapIdleTimeout = 25 * time.Minute
+	// imapRetryInterval is the time to wait before reconnecting after an error.
+	imapRetryInterval = time.Minute
+	// fileDebounce is the time to wait after last change before file is read. Mail clients
+	// usually write files in several steps.
+	fileDebounce = 2 * time.Second
+)
+
+// Watcher keeps index up to date by watching mail sources for changes and indexing new or changed mails.
+type Watcher struct {
+	flushFunc func(mails []*Mail) error
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	// lock serializes indexing and saving sync state between sources.
+	lock sync.Mutex
+}
+
+// NewWatcher creates new watcher that pushes mails to flushFunc.
+func NewWatcher(flushFunc func(mails []*Mail) error) *Watcher {
+	return &Watcher{
+		flushFunc: flushFunc,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Stop stops all watches and waits for them to return.
+func (w *Watcher) Stop() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+// WatchImap fetches new mails from imap folder and keeps waiting for new mails with IDLE. State is saved
+// after each fetch. Errors are logged and connection is retried until watcher is stopped.
+func (w *Watcher) WatchImap(newClient func() *Imap, folder string, state *ImapSyncState) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		client := newClient()
+		log := logrus.WithFields(logrus.Fields{"account": client.Account, "folder": folder})
+		for {
+			err := w.watchImapFolder(client, folder, state, log)
+			client.Disconnect()
+			if err == nil {
+				return
+			}
+			log.Errorf("watch imap: %v", err)
+
+			select {
+			case <-w.stop:
+				return
+			case <-time.After(imapRetryInterval):
+				client = newClient()
+			}
+		}
+	}()
+}
+
+// watchImapFolder returns nil when watcher is stopped.
+func (w *Watcher) watchImapFolder(client *Imap, folder string, state *ImapSyncState, log *logrus.Entry) error {
+	err := client.Connect()
+	if err != nil {
+		return err
+	}
+	err = client.SelectMailbox(folder)
+	if err != nil {
+		return fmt.Errorf("select folder: %v", err)
+	}
+	log.Info("Watching imap folder")
+
+	changed := true
+	for {
+		if changed {
+			err = w.fetchImap(client, folder, state, log)
+			if err != nil {
+				return err
+			}
+		}
+
+		changed, err = client.WaitUpdate(w.stop, imapIdleTimeout)
+		if err != nil {
+			return fmt.Errorf("idle: %v", err)
+		}
+
+		select {
+		case <-w.stop:
+			return nil
+		default:
+		}
+	}
+}
+
+func (w *Watcher) fetchImap(client *Imap, folder string, state *ImapSyncState, log *logrus.Entry) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	fetched, err := client.FetchMail(state.Folder(client.Account, folder), w.flushFunc)
+	if fetched > 0 {
+		log.WithField("mails", fetched).Info("Indexed new mails")
+	}
+	if err != nil {
+		return err
+	}
+	return state.Save()
+}
+
+// mailFingerprint returns hash of mail contents, which is used to detect changed mails.
+func mailFingerprint(mail *Mail) string {
+	data, _ := json.Marshal(mail)
+	hash := md5.Sum(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// watchedFile is mbox file or maildir directory being watched.
+type watchedFile struct {
+	external.MboxFile
+	// dir is true for maildir 'cur' and 'new' directories.
+	dir bool
+	// folder is true for maildir folder directories, where new folders may be created.
+	folder bool
+	// mails contains fingerprints of mbox mails, uid -> fingerprint.
+	mails map[string]string
+	// size is the size of mbox file when it was last read, and offset is the offset of last message in it.
+	size   int64
+	offset int64
+}
+
+// WatchMbox watches mbox files and indexes new or changed mails when files change. Files are read once
+// when starting to watch them, and only mails that are added or changed after that are indexed.
+func (w *Watcher) WatchMbox(account string, files []external.MboxFile) error {
+	watched := make(map[string]*watchedFile, len(files))
+	for _, file := range files {
+		path, _ := filepath.Abs(file.File)
+		f := &watchedFile{MboxFile: file, mails: map[string]string{}}
+		_, err := w.readMbox(f, func(mails []*Mail) error { return nil })
+		if err != nil {
+			return fmt.Errorf("read %s: %v", file.File, err)
+		}
+		watched[path] = f
+	}
+
+	log := logrus.WithField("account", account)
+	flushFunc := WithAccount(account, w.flushFunc)
+	return w.watchFiles(watched, log, func(path string, file *watchedFile) {
+		count, err := w.readMbox(file, flushFunc)
+		if err != nil {
+			log.WithField("file", path).Errorf("read mbox: %v", err)
+		} else if count > 0 {
+			log.WithFields(logrus.Fields{"folder": file.Name, "mails": count}).Info("Indexed new mails")
+		}
+	})
+}
+
+// readMbox reads mbox file and pushes mails that have been added or changed since last read.
+// If file has grown, only the last message and messages after it are read, since new mails are appended to
+// mbox. Last message is read again in case it was not complete. Otherwise, e.g. when flags have been
+// changed in place or mails have been removed, whole file is read.
+func (w *Watcher) readMbox(file *watchedFile, flushFunc func(mails []*Mail) error) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	info, err := os.Stat(file.File)
+	if err != nil {
+		return 0, err
+	}
+	start := int64(0)
+	if file.size > 0 && info.Size() > file.size && isMboxMessage(file.File, file.offset) {
+		start = file.offset
+	}
+
+	var changed []*Mail
+	collect := func(mails []*Mail) error {
+		for _, mail := range mails {
+			fingerprint := mailFingerprint(mail)
+			if file.mails[mail.Uid] != fingerprint {
+				file.mails[mail.Uid] = fingerprint
+				changed = append(changed, mail)
+			}
+		}
+		return nil
+	}
+
+	mails, _, last, err := readFileFrom(file.File, file.Name, start, collect)
+	if err != nil {
+		return 0, err
+	}
+	file.size = info.Size()
+	file.offset = last
+	collect(mails)
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	return len(changed), flushFunc(changed)
+}
+
+// WatchMaildir watches all 'cur' and 'new' directories in maildir, and indexes mail files that are created
+// or renamed (e.g. when flags change) in them. Folders that are created later are watched as well.
+func (w *Watcher) WatchMaildir(account, baseDir string) error {
+	root, err := filepath.Abs(baseDir)
+	if err != nil {
+		return err
+	}
+	dirs, err := external.MaildirDirs(root, "")
+	if err != nil {
+		return err
+	}
+	watched := map[string]*watchedFile{}
+	for _, dir := range dirs {
+		watched[dir.Path] = newWatchedDir(dir)
+	}
+
+	log := logrus.WithField("account", account)
+	flushFunc := WithAccount(account, w.flushFunc)
+	return w.watchFiles(watched, log, func(path string, dir *watchedFile) {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		mail, err := readMaildirFile(path, dir.Name)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.WithField("file", path).Errorf("read mail: %v", err)
+			}
+			return
+		}
+		err = flushFunc([]*Mail{mail})
+		if err != nil {
+			log.WithField("file", path).Errorf("index mail: %v", err)
+			return
+		}
+		log.WithFields(logrus.Fields{"folder": dir.Name, "mails": 1}).Info("Indexed new mails")
+	})
+}
+
+func newWatchedDir(dir external.MaildirDir) *watchedFile {
+	return &watchedFile{
+		MboxFile: external.MboxFile{File: dir.Path, Name: dir.Folder},
+		dir:      dir.Mails,
+		folder:   !dir.Mails,
+	}
+}
+
+// addMaildirDirs starts watching new directories in maildir folder and returns mail files that
+// already exist in new 'cur' and 'new' directories, since they may have been delivered before the watch was added.
+func addMaildirDirs(watcher *fsnotify.Watcher, files map[string]*watchedFile, folder *watchedFile) ([]string, error) {
+	dirs, err := external.MaildirDirs(folder.File, folder.Name)
+	if err != nil {
+		return nil, err
+	}
+	var mails []string
+	for _, dir := range dirs {
+		_, known := files[dir.Path]
+		err = watcher.Add(dir.Path)
+		if err != nil {
+			return mails, fmt.Errorf("watch %s: %v", dir.Path, err)
+		}
+		files[dir.Path] = newWatchedDir(dir)
+		if known || !dir.Mails {
+			continue
+		}
+		existing, err := external.MaildirFiles(dir.Path, dir.Folder)
+		if err != nil {
+			return mails, err
+		}
+		for _, v := range existing {
+			mails = append(mails, v.File)
+		}
+	}
+	return mails, nil
+}
+
+// watchFiles watches changes in files. Files is a map of absolute path -> file. For directories, all files
+// inside the directory are reported to changeFunc. Changes are debounced with fileDebounce.
+// Parent directories are watched instead of files themselves, since mail clients may replace files.
+// Directories that are created in maildir folder directories are added to files and watched.
+func (w *Watcher) watchFiles(files map[string]*watchedFile, log *logrus.Entry,
+	changeFunc func(path string, file *watchedFile)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("init file watcher: %v", err)
+	}
+
+	dirs := map[string]bool{}
+	for path := range files {
+		dir := filepath.Dir(path)
+		if files[path].dir || files[path].folder {
+			dir = path
+		}
+		if dirs[dir] {
+			continue
+		}
+		err = watcher.Add(dir)
+		if err != nil {
+			watcher.Close()
+			return fmt.Errorf("watch %s: %v", dir, err)
+		}
+		dirs[dir] = true
+	}
+	log.WithField("files", len(files)).Info("Watching files")
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer watcher.Close()
+
+		// pending contains path -> time of last change
+		pending := map[string]time.Time{}
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case err := <-watcher.Errors:
+				log.Errorf("watch files: %v", err)
+			case event := <-watcher.Events:
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+					continue
+				}
+				if parent, ok := files[filepath.Dir(event.Name)]; ok && parent.folder {
+					// new folder, or 'cur' / 'new' directory of a folder
+					if event.Op&fsnotify.Create == 0 {
+						continue
+					}
+					if info, err := os.Stat(event.Name); err != nil || !info.IsDir() {
+						continue
+					}
+					mails, err := addMaildirDirs(watcher, files, parent)
+					if err != nil {
+						log.Errorf("watch new folder %s: %v", event.Name, err)
+					}
+					for _, mail := range mails {
+						pending[mail] = time.Now()
+					}
+				} else if _, ok := files[event.Name]; ok {
+					pending[event.Name] = time.Now()
+				} else if _, ok := files[filepath.Dir(event.Name)]; ok && event.Op&fsnotify.Rename == 0 {
+					// file created in maildir. Renaming a file creates a new one as well.
+					pending[event.Name] = time.Now()
+				}
+			case now := <-ticker.C:
+				for path, changed := range pending {
+					if now.Sub(changed) < fileDebounce {
+						continue
+					}
+					delete(pending, path)
+					file, ok := files[path]
+					if !ok {
+						file = files[filepath.Dir(path)]
+					}
+					changeFunc(path, file)
+				}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package indexer
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"tryffel.net/go/meilindex/external"
+)
+
+func Test_addMaildirDirs(t *testing.T) {
+	root, err := ioutil.TempDir("", "meilindex-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+
+	files := map[string]*watchedFile{root: newWatchedDir(external.MaildirDir{Path: root})}
+
+	// folder is created with a mail already delivered to it
+	mail := filepath.Join(root, "Lists", "new", "1.host")
+	if err := os.MkdirAll(filepath.Join(root, "Lists", "cur"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(mail), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(mail, []byte("Subject: a\n\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	mails, err := addMaildirDirs(watcher, files, files[root])
+	if err != nil {
+		t.Fatalf("addMaildirDirs() error = %v", err)
+	}
+	if want := []string{mail}; !reflect.DeepEqual(mails, want) {
+		t.Errorf("addMaildirDirs() = %v, want %v", mails, want)
+	}
+
+	tests := []struct {
+		path       string
+		wantName   string
+		wantDir    bool
+		wantFolder bool
+	}{
+		{path: filepath.Join(root, "Lists"), wantName: "Lists", wantFolder: true},
+		{path: filepath.Join(root, "Lists", "cur"), wantName: "Lists", wantDir: true},
+		{path: filepath.Join(root, "Lists", "new"), wantName: "Lists", wantDir: true},
+	}
+	for _, tt := range tests {
+		file, ok := files[tt.path]
+		if !ok {
+			t.Errorf("%s is not watched", tt.path)
+			continue
+		}
+		if file.Name != tt.wantName || file.dir != tt.wantDir || file.folder != tt.wantFolder {
+			t.Errorf("%s: name = %s, dir = %v, folder = %v", tt.path, file.Name, file.dir, file.folder)
+		}
+	}
+
+	// known directories are not reported again
+	mails, err = addMaildirDirs(watcher, files, files[root])
+	if err != nil {
+		t.Fatalf("addMaildirDirs() error = %v", err)
+	}
+	if len(mails) != 0 {
+		t.Errorf("addMaildirDirs() = %v, want no mails", mails)
+	}
+}
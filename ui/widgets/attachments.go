@@ -0,0 +1,254 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package widgets
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"github.com/sirupsen/logrus"
+	"gitlab.com/tslocum/cview"
+	"io/ioutil"
+	"os"
+	"tryffel.net/go/meilindex/external"
+	"tryffel.net/go/meilindex/indexer"
+)
+
+// AttachmentPicker is a modal that lists attachments of a mail and saves or opens them. Attachments are read
+// from cache, or from the original message if mail is not cached.
+type AttachmentPicker struct {
+	*cview.Flex
+	list        *cview.List
+	message     *cview.TextView
+	app         *cview.Application
+	cache       *indexer.AttachmentCache
+	raw         indexer.RawFunc
+	saveDir     string
+	attachments []indexer.Attachment
+	// uid is the uid of current mail.
+	uid string
+	// tempDirs are private directories of opened attachments, removed with RemoveTempFiles.
+	tempDirs []string
+
+	isOpen bool
+}
+
+func (a *AttachmentPicker) SetDoneFunc(doneFunc func()) {
+}
+
+func (a *AttachmentPicker) SetVisible(visible bool) {
+}
+
+// NewAttachmentPicker creates new picker that reads attachments from cache or with raw from original message,
+// and saves them to saveDir.
+func NewAttachmentPicker(app *cview.Application, cache *indexer.AttachmentCache, saveDir string,
+	raw indexer.RawFunc) *AttachmentPicker {
+	a := &AttachmentPicker{
+		Flex:    cview.NewFlex(),
+		list:    cview.NewList(),
+		message: cview.NewTextView(),
+		app:     app,
+		cache:   cache,
+		raw:     raw,
+		saveDir: saveDir,
+	}
+
+	a.SetDirection(cview.FlexRow)
+	a.SetBorder(true)
+	a.SetTitle("Attachments")
+	a.SetBackgroundColor(colorBackground)
+	a.SetBorderColor(tcell.Color230)
+	a.SetTitleColor(colorText)
+
+	a.list.ShowSecondaryText(false)
+	a.list.SetHighlightFullLine(true)
+	a.list.SetMainTextColor(colorText)
+	a.list.SetSelectedTextColor(colorTextSelected)
+	a.list.SetSelectedBackgroundColor(colorBackgroundSelected)
+	a.list.SetSelectedFunc(func(index int, _, _ string, _ rune) {
+		a.open(index)
+	})
+	a.list.SetInputCapture(a.inputCapture)
+
+	a.message.SetDynamicColors(true)
+	a.message.SetWrap(false)
+
+	a.AddItem(a.list, 0, 1, true)
+	a.AddItem(a.message, 2, 0, false)
+	return a
+}
+
+// SetMail lists attachments of mail. If attachments are not cached, they are read from original message
+// in background.
+func (a *AttachmentPicker) SetMail(mail *indexer.Mail) {
+	a.list.Clear()
+	a.attachments = nil
+	a.uid = mail.Uid
+
+	attachments, err := a.readCache(mail.Uid)
+	if err != nil {
+		a.setMessage(fmt.Sprintf("[red]Read attachments: %s[-]", cview.Escape(err.Error())))
+		return
+	}
+	if len(attachments) > 0 {
+		a.setAttachments(attachments)
+		return
+	}
+	if len(mail.AttachmentNames) == 0 {
+		a.setMessage("Mail has no attachments")
+		return
+	}
+	if a.raw == nil || mail.Source == nil {
+		a.setMessage("[yellow]Attachments are not cached and original message is not known.[-] Index mail again")
+		return
+	}
+
+	uid := mail.Uid
+	source := mail.Source
+	a.setMessage("Reading attachments from " + cview.Escape(source.String()))
+	go func() {
+		var attachments []indexer.Attachment
+		data, err := a.raw(source)
+		if err == nil {
+			attachments, err = indexer.ReadAttachments(data)
+		}
+		a.app.QueueUpdateDraw(func() {
+			// another mail has been selected meanwhile
+			if a.uid != uid {
+				return
+			}
+			if err != nil {
+				logrus.Errorf("read attachments from %s: %v", source.String(), err)
+				a.setMessage(fmt.Sprintf("[red]Read original message: %s[-]", cview.Escape(err.Error())))
+				return
+			}
+			a.setAttachments(attachments)
+		})
+	}()
+}
+
+// readCache reads cached attachments of mail with uid.
+func (a *AttachmentPicker) readCache(uid string) ([]indexer.Attachment, error) {
+	cached, err := a.cache.List(uid)
+	if err != nil {
+		return nil, err
+	}
+	attachments := make([]indexer.Attachment, len(cached))
+	for i, v := range cached {
+		data, err := a.cache.Read(v)
+		if err != nil {
+			return nil, err
+		}
+		attachments[i] = indexer.Attachment{Name: v.Name, MimeType: v.MimeType, Data: data}
+	}
+	return attachments, nil
+}
+
+func (a *AttachmentPicker) setAttachments(attachments []indexer.Attachment) {
+	a.attachments = attachments
+	for _, v := range attachments {
+		part := indexer.MimePart{MimeType: v.MimeType, Name: v.Name, Size: len(v.Data)}
+		a.list.AddItem(cview.Escape(part.String()), "", 0, nil)
+	}
+	a.list.SetCurrentItem(0)
+	if len(attachments) > 0 {
+		a.setMessage("Enter / O: open, S: save to " + cview.Escape(a.saveDir))
+	} else {
+		a.setMessage("Mail has no attachments")
+	}
+}
+
+func (a *AttachmentPicker) setMessage(text string) {
+	a.message.SetText(text)
+}
+
+func (a *AttachmentPicker) inputCapture(event *tcell.EventKey) *tcell.EventKey {
+	if event.Key() != tcell.KeyRune {
+		return event
+	}
+	switch event.Rune() {
+	case 'o', 'O':
+		a.open(a.list.GetCurrentItem())
+		return nil
+	case 's', 'S':
+		a.save(a.list.GetCurrentItem())
+		return nil
+	}
+	return event
+}
+
+// RemoveTempFiles removes attachments that have been opened.
+func (a *AttachmentPicker) RemoveTempFiles() {
+	for _, dir := range a.tempDirs {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			logrus.Warningf("remove temporary attachment: %v", err)
+		}
+	}
+	a.tempDirs = nil
+}
+
+// save saves attachment to save directory.
+func (a *AttachmentPicker) save(index int) {
+	if index < 0 || index >= len(a.attachments) {
+		return
+	}
+	attachment := a.attachments[index]
+	path, err := indexer.SaveAttachment(attachment.Name, attachment.Data, a.saveDir)
+	if err != nil {
+		a.setMessage(fmt.Sprintf("[red]Save attachment: %s[-]", cview.Escape(err.Error())))
+		return
+	}
+	a.setMessage("Saved to " + cview.Escape(path))
+}
+
+// open saves attachment to a new private temporary directory and opens it with default application.
+// Attachments are opened in background, since xdg-open may wait for application.
+func (a *AttachmentPicker) open(index int) {
+	if index < 0 || index >= len(a.attachments) {
+		return
+	}
+	attachment := a.attachments[index]
+	// directory is only accessible by user, so that other users can not replace the file before it is opened.
+	// Keep file name, since applications are selected by file extension.
+	dir, err := ioutil.TempDir("", "meilindex-attachment-")
+	if err != nil {
+		a.setMessage(fmt.Sprintf("[red]Open attachment: %s[-]", cview.Escape(err.Error())))
+		return
+	}
+	a.tempDirs = append(a.tempDirs, dir)
+	path, err := indexer.SaveAttachment(attachment.Name, attachment.Data, dir)
+	if err != nil {
+		a.setMessage(fmt.Sprintf("[red]Open attachment: %s[-]", cview.Escape(err.Error())))
+		return
+	}
+
+	a.setMessage("Opening " + cview.Escape(attachment.Name))
+	go func() {
+		err := external.OpenFile(path)
+		a.app.QueueUpdateDraw(func() {
+			if err != nil {
+				a.setMessage(fmt.Sprintf("[red]Open attachment with xdg-open: %s[-]", cview.Escape(err.Error())))
+			} else {
+				a.setMessage("Opened " + cview.Escape(attachment.Name))
+			}
+		})
+	}()
+}
@@ -61,20 +61,18 @@ func NewHelp() *Help {
 	h.SetBorderPadding(0, 1, 2, 2)
 	h.setContent()
 	h.SetWordWrap(true)
+	return h
+}
 
-	meili, err := indexer.NewMeiliSearch()
-	if err == nil {
-		stats := meili.Stats()
-
-		h.infoText = fmt.Sprintf(`
+// SetStats shows meilisearch stats in info page.
+func (h *Help) SetStats(stats indexer.ServerStats) {
+	h.infoText = fmt.Sprintf(`
 [yellow]Meilisearch[-]:
 Total mails: %d
 Indexing in progress: %t
 Server version: %s
 `, stats.NumDocuments, stats.Indexing, stats.ServerVersion)
-	}
-
-	return h
+	h.setContent()
 }
 
 func (h *Help) InputHandler() func(event *tcell.EventKey, setFocus func(p cview.Primitive)) {
@@ -147,7 +145,7 @@ Features:
 * Multiple configurations for different mailboxes
 * Configure Meilisearch: stop words, ranking rules order
 * Query Meilisearch instance either with CLI or with terminal gui
-* Open selected mail in Thunderbird
+* Open selected mail in Thunderbird, mutt, neomutt, aerc, Mailspring, Evolution or custom command
 	`
 	return text
 }
@@ -160,7 +158,18 @@ func (h *Help) shortcutsPage() string {
         * Top / Bottom of list: g / G 
         * Page Up / Down: Ctrl+F / Ctrl+B
 * Switch between panels: Tab 
+* Run saved search: select it in 'Saved' sidebar with Enter
+* Recall previous / next search in query field: Up / Down
+* Find search from history: Ctrl-R
 * Select button or item: Enter
+* Open selected mail in mail client: F2
+* Open selected mail in reader: Enter
+* In reader: toggle all headers: H, next / previous mail: N / P or Right / Left, close: Escape
+* Show conversation of selected mail in preview: T
+* Reply to selected mail: R, reply to all: Shift-R, forward: F
+* Attachments of selected mail: A. Open attachment: Enter / O, save it: S
+* Sort mails by relevance, newest first or oldest first: S
+* Reconnect to Meilisearch or retry failed search: F5
 * Close application: Ctrl-C
 `
 }
@@ -169,27 +178,48 @@ func (h *Help) searchPage() string {
 	return `[yellow]Query[-]:
 Query field supports full-text-search. Any field will be 
 included, but only subject and message body will be highlighted. 
-Query field must always include something for search results to appear, even with filters.
+Query can also contain operators, which are applied as filters: 
+from:, to:, cc:, subject:, folder:, account:, has:attachment, before:, after: and 
+is:unread (read, flagged, answered, draft). Prefix operator with '-' to negate it, and 
+quote values with spaces, e.g. 'report from:alice -is:read subject:"weekly report"'. 
+from:, to: and cc: with a full address (alice@example.com) match that address exactly. 
+Names (from:alice) and subject: values are searched as text, since filters can not 
+match part of a field. Negated -subject: and -from:alice exclude exact values only. 
+folder: and account: match exact names. 
+Search runs shortly after you stop typing. Mail list title shows 'searching...' while 
+search is running, and the search time reported by Meilisearch once results arrive. 
 	
 [yellow]Filter[-]:
-You can define additional filters, which must match exactly. Boolean operators are supported. 
-Supported fields are: [from, to, subject, cc, body, before/after/time]. 
+You can define additional filters, which must match exactly. Operators AND, OR, NOT and 
+parentheses are supported, conditions without operator are combined with AND. 
+Supported fields are: [from, to, cc, bcc, from_address, to_address, subject, body, folder, 
+account, thread_id, before/after/time, date]. 
+Mail state can be filtered with: [seen, flagged, answered, draft] (true/false) or flags. 
+Mails with attachments can be filtered with has:attachment. 
+Invalid filters are shown below filter field. 
 	
 Examples: 
 	* 'folder=inbox AND from="example sender"'
 	* 'folder=inbox AND NOT from="example.sender@example.company'
+	* 'account=work AND folder=inbox'
+	* 'flagged=true AND folder=inbox'
+	* 'seen=false'
+	* '(folder=inbox OR folder=sent) has:attachment'
 	
 	
 [yellow]Time range filters[-]:
 Time ranges are parsed separately. 
-Supported fields are: 'after', 'before' and 'time', see below for examples.
+Supported fields are: 'after', 'before', 'time' and 'date', see below for examples.
 Format is year, optional month and optional day, 
 e.g.: '2020', '2020-01' or '2020-01-01'. 2020 and 2020-01
 will be expanded to 2020-01-01.
+Dates can also be relative: 'today', 'yesterday', '-7d', '-2w', '-3m' or '-1y'.
 
 Examples:
 	* 'after=2020-06 AND before=2021' (matches mails June 2020 - Jan 2021)
 	* 'time="2020:2021"'
+	* 'date=2020-05' (matches mails in May 2020)
+	* 'after=-7d' (matches mails from last week)
 	
 `
 }
@@ -0,0 +1,116 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package widgets
+
+import (
+	"github.com/gdamore/tcell"
+	"gitlab.com/tslocum/cview"
+	"tryffel.net/go/meilindex/config"
+)
+
+// HistoryPicker is a modal for fuzzy-searching search history.
+type HistoryPicker struct {
+	*cview.Flex
+	input      *cview.InputField
+	list       *cview.List
+	history    *config.History
+	entries    []config.HistoryEntry
+	selectFunc func(entry config.HistoryEntry)
+
+	isOpen bool
+}
+
+func (h *HistoryPicker) SetDoneFunc(doneFunc func()) {
+}
+
+func (h *HistoryPicker) SetVisible(visible bool) {
+}
+
+// NewHistoryPicker creates new picker. SelectFunc is called when user selects an entry.
+func NewHistoryPicker(history *config.History, selectFunc func(entry config.HistoryEntry)) *HistoryPicker {
+	h := &HistoryPicker{
+		Flex:       cview.NewFlex(),
+		input:      cview.NewInputField(),
+		list:       cview.NewList(),
+		history:    history,
+		selectFunc: selectFunc,
+	}
+
+	h.SetDirection(cview.FlexRow)
+	h.SetBorder(true)
+	h.SetTitle("Search history")
+	h.SetBackgroundColor(colorBackground)
+	h.SetBorderColor(tcell.Color230)
+	h.SetTitleColor(colorText)
+
+	h.input.SetLabel("Find: ")
+	h.input.SetFieldTextColor(tcell.Color252)
+	h.input.SetFieldBackgroundColor(tcell.Color235)
+	h.input.SetChangedFunc(h.update)
+	h.input.SetInputCapture(h.inputCapture)
+
+	h.list.ShowSecondaryText(false)
+	h.list.SetHighlightFullLine(true)
+	h.list.SetMainTextColor(colorText)
+	h.list.SetSelectedTextColor(colorTextSelected)
+	h.list.SetSelectedBackgroundColor(colorBackgroundSelected)
+
+	h.AddItem(h.input, 1, 0, true)
+	h.AddItem(h.list, 0, 1, false)
+	return h
+}
+
+// Reset clears pattern and lists all entries, newest first.
+func (h *HistoryPicker) Reset() {
+	h.input.SetText("")
+	h.update("")
+}
+
+func (h *HistoryPicker) update(pattern string) {
+	h.entries = h.history.Search(pattern)
+	h.list.Clear()
+	for _, v := range h.entries {
+		h.list.AddItem(v.String(), "", 0, nil)
+	}
+	h.list.SetCurrentItem(0)
+}
+
+// inputCapture moves selection in list while keeping focus in input field.
+func (h *HistoryPicker) inputCapture(event *tcell.EventKey) *tcell.EventKey {
+	current := h.list.GetCurrentItem()
+	switch event.Key() {
+	case tcell.KeyUp:
+		if current > 0 {
+			h.list.SetCurrentItem(current - 1)
+		}
+		return nil
+	case tcell.KeyDown:
+		if current < h.list.GetItemCount()-1 {
+			h.list.SetCurrentItem(current + 1)
+		}
+		return nil
+	case tcell.KeyEnter:
+		if current < len(h.entries) && h.selectFunc != nil {
+			h.selectFunc(h.entries[current])
+		}
+		return nil
+	}
+	return event
+}
@@ -22,6 +22,7 @@ package widgets
 
 import (
 	"fmt"
+	"github.com/gdamore/tcell"
 	"gitlab.com/tslocum/cview"
 	"tryffel.net/go/meilindex/indexer"
 	"tryffel.net/go/twidgets"
@@ -53,18 +54,47 @@ func NewMessageShort(index int, mail *indexer.Mail) *MessageShort {
 
 	m.SetBorder(false)
 	m.SetDynamicColors(true)
-	text := fmt.Sprintf(`%d. %s, %s
+	text := fmt.Sprintf(`%d. %s%s, %s
 %s
-`, index, mail.ShortDateTime(), mail.HighlightedFrom(), mail.HighlightedSubject())
+`, index, flagMarkers(mail), mail.ShortDateTime(), mail.HighlightedFrom(), mail.HighlightedSubject())
 
 	m.SetText(text)
 	return m
 }
 
+// flagMarkers returns markers for unread (N), flagged (F), answered (R) and draft (D) mails.
+// Mails indexed without flags have no markers.
+func flagMarkers(mail *indexer.Mail) string {
+	if mail.Flags == nil {
+		return ""
+	}
+	markers := ""
+	if !mail.HasFlag(indexer.FlagSeen) {
+		markers += "N"
+	}
+	if mail.HasFlag(indexer.FlagFlagged) {
+		markers += "F"
+	}
+	if mail.HasFlag(indexer.FlagAnswered) {
+		markers += "R"
+	}
+	if mail.HasFlag(indexer.FlagDraft) {
+		markers += "D"
+	}
+	if markers == "" {
+		return ""
+	}
+	return "[orange]" + markers + "[-] "
+}
+
+// loadMoreThreshold is the distance from end of list at which more mails are loaded.
+const loadMoreThreshold = 10
+
 type MessageList struct {
 	*twidgets.ScrollList
 	shortMessages []*MessageShort
 	selectFunc    func(m *indexer.Mail)
+	loadMoreFunc  func()
 }
 
 func NewMessageList(selectFunc func(m *indexer.Mail)) *MessageList {
@@ -75,6 +105,8 @@ func NewMessageList(selectFunc func(m *indexer.Mail)) *MessageList {
 	m.ScrollList = twidgets.NewScrollList(m.selectMail)
 	m.ScrollList.Padding = 0
 	m.SetBackgroundColor(colorBackground)
+	m.SetBorder(true)
+	m.SetTitle("Mails")
 
 	return m
 }
@@ -86,6 +118,24 @@ func (m *MessageList) AddMessage(index int, mail *indexer.Mail) {
 
 }
 
+// SetLoadMoreFunc sets function that is called when selection moves near the end of list.
+func (m *MessageList) SetLoadMoreFunc(loadMoreFunc func()) {
+	m.loadMoreFunc = loadMoreFunc
+}
+
+// InputHandler loads more mails when selection gets near the end of list.
+func (m *MessageList) InputHandler() func(event *tcell.EventKey, setFocus func(p cview.Primitive)) {
+	return func(event *tcell.EventKey, setFocus func(p cview.Primitive)) {
+		if handler := m.ScrollList.InputHandler(); handler != nil {
+			handler(event, setFocus)
+		}
+		if m.loadMoreFunc != nil && len(m.shortMessages) > 0 &&
+			m.GetSelectedIndex() >= len(m.shortMessages)-loadMoreThreshold {
+			m.loadMoreFunc()
+		}
+	}
+}
+
 func (m *MessageList) Clear() {
 	m.ScrollList.Clear()
 	m.shortMessages = []*MessageShort{}
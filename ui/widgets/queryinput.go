@@ -23,6 +23,7 @@ package widgets
 import (
 	"github.com/gdamore/tcell"
 	"gitlab.com/tslocum/cview"
+	"tryffel.net/go/meilindex/config"
 )
 
 type QueryInput struct {
@@ -30,19 +31,28 @@ type QueryInput struct {
 	query     *cview.InputField
 	filter    *cview.InputField
 	queryFunc func(string, string)
+	// doneFunc is called when user presses enter in either field.
+	doneFunc func(query, filter string)
+
+	history *config.History
+	// historyIndex is the index of recalled history entry, or -1 if history is not being browsed.
+	historyIndex int
+	// draft is the search that was being typed before browsing history.
+	draft config.HistoryEntry
 }
 
 func NewQueryInput(query func(query, filter string)) *QueryInput {
 	q := &QueryInput{
-		Form:      cview.NewForm(),
-		query:     cview.NewInputField(),
-		filter:    cview.NewInputField(),
-		queryFunc: query,
+		Form:         cview.NewForm(),
+		query:        cview.NewInputField(),
+		filter:       cview.NewInputField(),
+		queryFunc:    query,
+		historyIndex: -1,
 	}
 
 	q.SetBorder(false)
 	q.query.SetLabel("Query")
-	q.query.SetPlaceholder("marketing")
+	q.query.SetPlaceholder("marketing from:alice is:unread")
 
 	q.filter.SetLabel("Filter")
 	q.filter.SetPlaceholder(`folder=inbox AND time="2020:2020-06"`)
@@ -54,11 +64,74 @@ func NewQueryInput(query func(query, filter string)) *QueryInput {
 	q.AddFormItem(q.filter)
 	q.query.SetChangedFunc(q.search)
 	q.filter.SetChangedFunc(q.searchFilter)
+	q.query.SetDoneFunc(q.done)
+	q.filter.SetDoneFunc(q.done)
+	q.query.SetInputCapture(q.recallHistory)
 	return q
 }
 
+// SetHistory sets history to recall searches from with Up and Down, and doneFunc
+// that is called when user presses enter.
+func (q *QueryInput) SetHistory(history *config.History, doneFunc func(query, filter string)) {
+	q.history = history
+	q.doneFunc = doneFunc
+}
+
+func (q *QueryInput) done(key tcell.Key) {
+	if key == tcell.KeyEnter && q.doneFunc != nil {
+		q.historyIndex = -1
+		q.doneFunc(q.query.GetText(), q.filter.GetText())
+	}
+}
+
+// recallHistory recalls older search with Up and newer with Down. Moving past newest entry restores
+// the search that was being typed.
+func (q *QueryInput) recallHistory(event *tcell.EventKey) *tcell.EventKey {
+	if q.history == nil || (event.Key() != tcell.KeyUp && event.Key() != tcell.KeyDown) {
+		return event
+	}
+	entries := q.history.Entries
+	if len(entries) == 0 {
+		return nil
+	}
+
+	index := q.historyIndex
+	if index == -1 {
+		q.draft = config.HistoryEntry{Query: q.query.GetText(), Filter: q.filter.GetText()}
+		index = len(entries)
+	}
+	if event.Key() == tcell.KeyUp && index > 0 {
+		index -= 1
+	} else if event.Key() == tcell.KeyDown && index < len(entries) {
+		index += 1
+	}
+
+	if index == len(entries) {
+		q.historyIndex = -1
+		q.SetSearch(q.draft.Query, q.draft.Filter)
+	} else {
+		q.historyIndex = index
+		q.SetSearch(entries[index].Query, entries[index].Filter)
+	}
+	return nil
+}
+
+// SetSearch sets both query and filter and runs search once.
+func (q *QueryInput) SetSearch(query, filter string) {
+	queryFunc := q.queryFunc
+	q.queryFunc = nil
+	q.query.SetText(query)
+	q.filter.SetText(filter)
+	q.queryFunc = queryFunc
+	if q.queryFunc != nil {
+		q.queryFunc(query, filter)
+	}
+}
+
 func (q *QueryInput) search(query string) {
 	if q.queryFunc != nil {
+		// user edited search
+		q.historyIndex = -1
 		filter := q.filter.GetText()
 		q.queryFunc(query, filter)
 	}
@@ -66,6 +139,7 @@ func (q *QueryInput) search(query string) {
 
 func (q *QueryInput) searchFilter(filter string) {
 	if q.queryFunc != nil {
+		q.historyIndex = -1
 		query := q.query.GetText()
 		q.queryFunc(query, filter)
 	}
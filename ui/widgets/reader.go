@@ -0,0 +1,189 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+
+package widgets
+
+import (
+	"fmt"
+	"github.com/gdamore/tcell"
+	"gitlab.com/tslocum/cview"
+	"strings"
+	"tryffel.net/go/meilindex/indexer"
+)
+
+// quoteColors are colors of quoted lines by quote level. Colors repeat for deeper levels.
+var quoteColors = []string{"skyblue", "lightgreen", "plum", "khaki"}
+
+// Reader is a modal that shows whole mail with headers, body and mime parts.
+type Reader struct {
+	*cview.TextView
+	mail *indexer.Mail
+	// allHeaders shows all headers instead of only from, to, cc, date and subject.
+	allHeaders   bool
+	navigateFunc func(step int)
+
+	isOpen bool
+}
+
+func (r *Reader) SetDoneFunc(doneFunc func()) {
+}
+
+func (r *Reader) SetVisible(visible bool) {
+}
+
+// NewReader creates new reader. NavigateFunc is called with 1 or -1 to show next or previous mail.
+func NewReader(navigateFunc func(step int)) *Reader {
+	r := &Reader{
+		TextView:     cview.NewTextView(),
+		navigateFunc: navigateFunc,
+	}
+
+	r.SetBackgroundColor(colorBackground)
+	r.SetBorder(true)
+	r.SetTitle("Mail")
+	r.SetBorderColor(tcell.Color230)
+	r.SetTitleColor(colorText)
+	r.SetDynamicColors(true)
+	r.SetBorderPadding(0, 0, 1, 1)
+	r.SetWordWrap(true)
+	return r
+}
+
+// SetMail shows mail. Index and total are the position of mail in search results.
+func (r *Reader) SetMail(mail *indexer.Mail, index, total int) {
+	r.mail = mail
+	r.SetTitle(fmt.Sprintf("Mail %d / %d", index+1, total))
+	r.render()
+	r.ScrollTo(0, 0)
+}
+
+func (r *Reader) InputHandler() func(event *tcell.EventKey, setFocus func(p cview.Primitive)) {
+	return func(event *tcell.EventKey, setFocus func(p cview.Primitive)) {
+		key := event.Key()
+		switch {
+		case key == tcell.KeyRune && event.Rune() == 'h':
+			r.allHeaders = !r.allHeaders
+			r.render()
+		case key == tcell.KeyRight || key == tcell.KeyRune && event.Rune() == 'n':
+			r.navigateFunc(1)
+		case key == tcell.KeyLeft || key == tcell.KeyRune && event.Rune() == 'p':
+			r.navigateFunc(-1)
+		default:
+			r.TextView.InputHandler()(event, setFocus)
+		}
+	}
+}
+
+func (r *Reader) render() {
+	if r.mail == nil {
+		r.SetText("")
+		return
+	}
+	mail := r.mail
+	text := ""
+	header := func(name, value string) {
+		text += fmt.Sprintf("[yellow]%s:[-] %s\n", name, value)
+	}
+
+	from := highlight(mail.From, "-")
+	if mail.FromAddress.Address != "" && mail.FromAddress.Name != "" {
+		from += " <" + cview.Escape(mail.FromAddress.Address) + ">"
+	}
+	header("From", from)
+	header("To", cview.Escape(formatAddresses(mail.To, mail.ToAddresses)))
+	if len(mail.Cc) > 0 {
+		header("Cc", cview.Escape(formatAddresses(mail.Cc, mail.CcAddresses)))
+	}
+	header("Date", mail.DateTime())
+	header("Subject", highlight(mail.Subject, "-"))
+
+	if r.allHeaders {
+		if len(mail.Bcc) > 0 {
+			header("Bcc", cview.Escape(formatAddresses(mail.Bcc, mail.BccAddresses)))
+		}
+		header("Account", cview.Escape(mail.Account))
+		header("Folder", cview.Escape(mail.Folder))
+		if len(mail.Flags) > 0 {
+			header("Flags", strings.Join(mail.Flags, ", "))
+		}
+		header("Message-ID", cview.Escape(mail.Id))
+		if mail.InReplyTo != "" {
+			header("In-Reply-To", cview.Escape(mail.InReplyTo))
+		}
+		if len(mail.References) > 0 {
+			header("References", cview.Escape(strings.Join(mail.References, " ")))
+		}
+		if mail.ThreadId != "" {
+			header("Thread", cview.Escape(mail.ThreadId))
+		}
+	}
+
+	text += "------------\n\n"
+	text += formatBody(mail.Body)
+
+	if len(mail.Parts) > 0 {
+		text += fmt.Sprintf("\n------------\n[yellow]Parts (%d):[-]\n", len(mail.Parts))
+		for i, part := range mail.Parts {
+			text += fmt.Sprintf("%d. %s\n", i+1, cview.Escape(part.String()))
+		}
+	} else if len(mail.AttachmentNames) > 0 {
+		// mail was indexed without parts
+		text += fmt.Sprintf("\n------------\n[yellow]Attachments (%d):[-]\n", len(mail.AttachmentNames))
+		for i, name := range mail.AttachmentNames {
+			text += fmt.Sprintf("%d. %s\n", i+1, cview.Escape(name))
+		}
+	}
+	r.SetText(text)
+}
+
+// formatBody colors quoted lines by their quote level and highlights search matches.
+func formatBody(body string) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		level := quoteLevel(line)
+		if level == 0 {
+			lines[i] = highlight(line, "-")
+			continue
+		}
+		color := quoteColors[(level-1)%len(quoteColors)]
+		lines[i] = "[" + color + "]" + highlight(line, color) + "[-]"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quoteLevel returns the number of quote markers ('>') in the beginning of line, e.g. '> > text' is 2.
+func quoteLevel(line string) int {
+	level := 0
+	for _, c := range line {
+		if c == '>' {
+			level++
+		} else if c != ' ' {
+			break
+		}
+	}
+	return level
+}
+
+// highlight escapes text and replaces search match tags with colors. Color is the text color after a match.
+func highlight(text, color string) string {
+	text = cview.Escape(text)
+	text = strings.Replace(text, "<em>", "[black:orange:]", -1)
+	return strings.Replace(text, "</em>", "["+color+":-:]", -1)
+}
@@ -0,0 +1,62 @@
+/*
+ * Meilindex - mail indexing and search tool.
+ * Copyright (C) 2021 Tero Vierimaa
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ *
+ *
+ */
+package widgets
+
+import (
+	"gitlab.com/tslocum/cview"
+	"tryffel.net/go/meilindex/config"
+)
+
+// SavedSearchList is a sidebar that lists saved searches.
+type SavedSearchList struct {
+	*cview.List
+	searches   []config.SavedSearch
+	selectFunc func(search config.SavedSearch)
+}
+
+// NewSavedSearchList creates new list. SelectFunc is called when user selects a search.
+func NewSavedSearchList(searches []config.SavedSearch, selectFunc func(search config.SavedSearch)) *SavedSearchList {
+	s := &SavedSearchList{
+		List:       cview.NewList(),
+		searches:   searches,
+		selectFunc: selectFunc,
+	}
+
+	s.SetBorder(true)
+	s.SetTitle("Saved")
+	s.SetBackgroundColor(colorBackground)
+	s.ShowSecondaryText(false)
+	s.SetHighlightFullLine(true)
+	s.SetMainTextColor(colorText)
+	s.SetSelectedTextColor(colorTextSelected)
+	s.SetSelectedBackgroundColor(colorBackgroundSelected)
+
+	for _, v := range searches {
+		s.AddItem(v.Name, "", 0, nil)
+	}
+	s.SetSelectedFunc(s.selectSearch)
+	return s
+}
+
+func (s *SavedSearchList) selectSearch(index int, mainText, secondaryText string, shortcut rune) {
+	if s.selectFunc != nil && index < len(s.searches) {
+		s.selectFunc(s.searches[index])
+	}
+}
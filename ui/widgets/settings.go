@@ -62,30 +62,47 @@ func NewSettings() *Settings {
 	s.SetBorderPadding(0, 1, 2, 2)
 
 	s.SetWordWrap(true)
+	s.setContent()
+	return s
+}
 
-	meili, err := indexer.NewMeiliSearch()
+// serverSettings contains formatted meilisearch settings. Settings that could not be read are empty.
+type serverSettings struct {
+	rankings  string
+	stopwords string
+	synonyms  string
+}
+
+// loadServerSettings reads settings from meilisearch. It does not modify ui, so it can be called in background.
+func loadServerSettings(meili *indexer.Meilisearch) serverSettings {
+	settings := serverSettings{}
+	rankings, err := meili.RankingRules()
 	if err == nil {
-		rankings, err := meili.RankingRules()
-		if err == nil {
-			s.rankings = "- " + strings.Join(*rankings, "\n- ")
-		}
+		settings.rankings = "- " + strings.Join(*rankings, "\n- ")
+	}
 
-		stopWords, err := meili.StopWords()
-		if err == nil {
-			s.stopwords = fmt.Sprintf("Total: %d\n\n", len(*stopWords))
-			s.stopwords += strings.Join(*stopWords, ", ")
-		}
+	stopWords, err := meili.StopWords()
+	if err == nil {
+		settings.stopwords = fmt.Sprintf("Total: %d\n\n", len(*stopWords))
+		settings.stopwords += strings.Join(*stopWords, ", ")
+	}
 
-		synonyms, err := meili.Synonyms()
-		s.synonyms += fmt.Sprintf("Total: %d\n", len(*synonyms))
-		if err == nil {
-			for i, v := range *synonyms {
-				s.synonyms += "\n- " + i + ": " + strings.Join(v, ", ")
-			}
+	synonyms, err := meili.Synonyms()
+	if err == nil {
+		settings.synonyms = fmt.Sprintf("Total: %d\n", len(*synonyms))
+		for i, v := range *synonyms {
+			settings.synonyms += "\n- " + i + ": " + strings.Join(v, ", ")
 		}
 	}
+	return settings
+}
+
+// setServerSettings shows settings loaded with loadServerSettings.
+func (s *Settings) setServerSettings(settings serverSettings) {
+	s.rankings = settings.rankings
+	s.stopwords = settings.stopwords
+	s.synonyms = settings.synonyms
 	s.setContent()
-	return s
 }
 
 func (s *Settings) InputHandler() func(event *tcell.EventKey, setFocus func(p cview.Primitive)) {
@@ -21,10 +21,17 @@
 package widgets
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/gdamore/tcell"
 	"github.com/sirupsen/logrus"
 	"gitlab.com/tslocum/cview"
+	"io/ioutil"
+	netmail "net/mail"
+	"os"
+	"strings"
+	"time"
 	"tryffel.net/go/meilindex/config"
 	"tryffel.net/go/meilindex/external"
 	"tryffel.net/go/meilindex/indexer"
@@ -33,30 +40,74 @@ import (
 
 type Window struct {
 	*twidgets.ModalLayout
-	query    *QueryInput
-	app      *cview.Application
-	list     *MessageList
-	preview  *cview.TextView
-	navBar   *twidgets.NavBar
-	help     *Help
-	settings *Settings
-	client   *indexer.Meilisearch
+	query   *QueryInput
+	app     *cview.Application
+	list    *MessageList
+	preview *cview.TextView
+	// filterError shows error in filter below query input.
+	filterError *cview.TextView
+	// status shows connection state and last Meilisearch error.
+	status *cview.TextView
+	// saved is nil if there are no saved searches.
+	saved         *SavedSearchList
+	history       *config.History
+	historyPicker *HistoryPicker
+	reader        *Reader
+	attachments   *AttachmentPicker
+	navBar        *twidgets.NavBar
+	help          *Help
+	settings      *Settings
+	client        *indexer.Meilisearch
 
 	mails []*indexer.Mail
+	// readerIndex is the index of mail shown in reader in mails.
+	readerIndex int
+	// current search
+	searchText   string
+	searchFilter string
+	totalHits    int
+	truncated    bool
+	hasMore      bool
+	sort         string
+	// searchCtx is cancelled when current search is superseded by a new one.
+	searchCtx    context.Context
+	searchCancel context.CancelFunc
+	// loading is true while a page of results is being fetched.
+	loading bool
+	// latency is the processing time of last search reported by meilisearch.
+	latency time.Duration
+	// connected is true when connection to meilisearch has succeeded.
+	connected  bool
+	connecting bool
+	// lastError is the last error message from meilisearch, empty if last request succeeded.
+	lastError string
+	// notice is a message about last action, e.g. opening mail in mail client.
+	notice string
+	// threadId is the thread being loaded or shown in preview.
+	threadId string
 }
 
-func NewWindow() *Window {
+const (
+	// searchPageSize is the number of mails loaded to list at once.
+	searchPageSize = 50
+	// searchDebounce is the time to wait after last keystroke before searching.
+	searchDebounce = 200 * time.Millisecond
+)
+
+// NewWindow creates new window. Raw reads original messages, e.g. to open attachments that are not cached.
+func NewWindow(raw indexer.RawFunc) *Window {
 	w := &Window{
-		app:         cview.NewApplication(),
-		ModalLayout: twidgets.NewModalLayout(),
-		help:        NewHelp(),
-		settings:    NewSettings(),
-		client: &indexer.Meilisearch{
-			Url:    config.Conf.Meilisearch.Url,
-			Index:  config.Conf.Meilisearch.Index,
-			ApiKey: config.Conf.Meilisearch.ApiKey,
-		},
-		preview: cview.NewTextView(),
+		app:          cview.NewApplication(),
+		ModalLayout:  twidgets.NewModalLayout(),
+		help:         NewHelp(),
+		settings:     NewSettings(),
+		client:       indexer.NewMeiliSearchClient(),
+		preview:      cview.NewTextView(),
+		filterError:  cview.NewTextView(),
+		status:       cview.NewTextView(),
+		sort:         indexer.SortRelevance,
+		searchCtx:    context.Background(),
+		searchCancel: func() {},
 	}
 
 	colors := twidgets.NavBarColors{
@@ -74,23 +125,49 @@ func NewWindow() *Window {
 	w.navBar.AddButton(cview.NewButton("Settings"), tcell.KeyF3)
 
 	w.query = NewQueryInput(w.search)
-	w.list = NewMessageList(w.showMessage)
-
-	err := w.client.Connect()
-	if err != nil {
-	}
+	w.list = NewMessageList(w.openReader)
+	w.reader = NewReader(w.navigateReader)
+	w.attachments = NewAttachmentPicker(w.app, indexer.NewAttachmentCache(config.Conf.Attachments.CacheDir),
+		config.Conf.Attachments.SaveDir, raw)
+	w.list.SetLoadMoreFunc(w.loadMore)
 
 	grid := w.ModalLayout.Grid()
 
-	grid.SetRows(1, 5, -1, -1, -1, -1, -1, -1, 5, 1)
+	grid.SetRows(1, 5, 2, -1, -1, -1, -1, -1, -1, 5, 1, 1)
 	grid.SetColumns(1, -1, -1, -1, -1, -1, -1, -1, -1, 1)
 	grid.SetBorder(true)
 	grid.SetTitle("Meilindex")
 
 	grid.AddItem(w.navBar, 0, 0, 1, 10, 1, 15, false)
 	grid.AddItem(w.query, 1, 0, 1, 10, 5, 15, true)
-	grid.AddItem(w.list, 2, 0, 8, 6, 5, 15, false)
-	grid.AddItem(w.preview, 2, 6, 8, 4, 5, 15, false)
+	grid.AddItem(w.filterError, 2, 0, 1, 10, 2, 15, false)
+	searches, err := config.LoadSavedSearches(config.Conf.Search.SavedFile)
+	if err != nil {
+		logrus.Errorf("Load saved searches: %v", err)
+	}
+	w.history, err = config.LoadHistory(config.Conf.Search.HistoryFile, config.Conf.Search.HistorySize)
+	if err != nil {
+		logrus.Errorf("Load search history: %v", err)
+	}
+	w.query.SetHistory(w.history, w.addHistory)
+	w.historyPicker = NewHistoryPicker(w.history, func(entry config.HistoryEntry) {
+		w.closeHistoryPicker()
+		w.query.SetSearch(entry.Query, entry.Filter)
+		w.app.SetFocus(w.query.query)
+	})
+
+	if len(searches.Searches) > 0 {
+		w.saved = NewSavedSearchList(searches.Searches, func(search config.SavedSearch) {
+			w.query.SetSearch(search.Query, search.Filter)
+			w.app.SetFocus(w.list)
+		})
+		grid.AddItem(w.saved, 3, 0, 8, 2, 5, 15, false)
+		grid.AddItem(w.list, 3, 2, 8, 4, 5, 15, false)
+	} else {
+		grid.AddItem(w.list, 3, 0, 8, 6, 5, 15, false)
+	}
+	grid.AddItem(w.preview, 3, 6, 8, 4, 5, 15, false)
+	grid.AddItem(w.status, 11, 0, 1, 10, 1, 15, false)
 
 	w.app.SetRoot(w, true).EnableMouse(config.Conf.Gui.Mouse)
 	w.app.SetFocus(w)
@@ -99,52 +176,459 @@ func NewWindow() *Window {
 	w.preview.SetBorder(true)
 	w.preview.SetTitle("Preview")
 	w.preview.SetWordWrap(true)
+	w.filterError.SetTextColor(tcell.ColorRed)
+	w.filterError.SetWrap(false)
+	w.status.SetDynamicColors(true)
+	w.status.SetWrap(false)
 	w.app.SetInputCapture(w.inputCapture)
+	w.connect(false)
 	return w
 }
 
 func (w *Window) Run() {
 	w.app.Run()
+	w.attachments.RemoveTempFiles()
 }
 
 func (w *Window) search(text, filter string) {
-	filt := indexer.NewFilter(filter).Query()
-	mails, _, err := w.client.Query(text, filt)
+	query, err := indexer.ParseQuery(text, time.Now())
+	if err != nil {
+		w.showFilterError(err)
+		return
+	}
+	f, err := indexer.NewFilter(filter)
 	if err != nil {
+		w.showFilterError(err)
 		return
 	}
+	w.showFilterError(nil)
+
+	w.searchText = query.Text
+	w.searchFilter = indexer.CombineFilters(query.Filter, f.Query())
+
+	// search is called on every keystroke, wait until user stops typing.
+	ctx := w.newSearch()
+	w.loading = true
+	w.updateTitle()
+	time.AfterFunc(searchDebounce, func() {
+		w.app.QueueUpdate(func() {
+			if ctx.Err() == nil {
+				w.fetch(ctx, true)
+			}
+		})
+	})
+}
 
-	w.mails = mails
+// newSearch cancels current search and returns context for new one.
+func (w *Window) newSearch() context.Context {
+	w.searchCancel()
+	w.searchCtx, w.searchCancel = context.WithCancel(context.Background())
+	return w.searchCtx
+}
 
-	w.list.Clear()
-	for i, v := range mails {
-		w.list.AddMessage(i+1, v)
+// showFilterError shows filter error with a caret pointing to the error, or clears error if err is nil.
+func (w *Window) showFilterError(err error) {
+	if err == nil {
+		w.filterError.SetText("")
+		return
+	}
+	if filterErr, ok := err.(*indexer.FilterError); ok {
+		caret := strings.Repeat(" ", filterErr.Column()) + "^ "
+		w.filterError.SetText(filterErr.Query + "\n" + caret + filterErr.Message)
+		return
 	}
+	w.filterError.SetText(err.Error())
 }
 
-func (w *Window) showMessage(mail *indexer.Mail) {
-	text := "Folder: " + mail.Folder + "\n"
-	text += "From: " + mail.HighlightedFrom() + "\n"
-	text += "To: "
-	for i, v := range mail.To {
-		if i > 0 {
-			text += ", "
+// toggleSort changes sort order to next one and reloads results.
+func (w *Window) toggleSort() {
+	for i, v := range indexer.SortOrders {
+		if v == w.sort {
+			w.sort = indexer.SortOrders[(i+1)%len(indexer.SortOrders)]
+			break
 		}
-		text += v
 	}
-	text += "\n"
-	text += "Cc: "
-	for i, v := range mail.Cc {
-		if i > 0 {
-			text += ", "
+	w.reload()
+}
+
+// reload runs current search again from the beginning.
+func (w *Window) reload() {
+	w.fetch(w.newSearch(), true)
+}
+
+// loadMore loads next page of current search results to list.
+func (w *Window) loadMore() {
+	if !w.hasMore || w.loading {
+		return
+	}
+	w.fetch(w.searchCtx, false)
+}
+
+// fetch loads page of current search results in background. If reset is true, list is cleared and
+// first page is loaded, else next page is appended to list. Results are discarded if ctx is cancelled
+// before they arrive. Must be called from ui goroutine.
+func (w *Window) fetch(ctx context.Context, reset bool) {
+	offset := len(w.mails)
+	if reset {
+		offset = 0
+	}
+	text := w.searchText
+	filter := w.searchFilter
+	opts := indexer.SearchOptions{
+		Limit:     searchPageSize,
+		Offset:    offset,
+		Highlight: true,
+		Sort:      w.sort,
+	}
+
+	w.loading = true
+	w.updateTitle()
+	client := w.client
+	go func() {
+		res, err := client.SearchContext(ctx, text, filter, opts)
+		if err == context.Canceled {
+			return
+		}
+		w.app.QueueUpdateDraw(func() {
+			if ctx.Err() != nil {
+				return
+			}
+			w.loading = false
+			if err != nil {
+				logrus.Errorf("search: %v", err)
+				w.setRequestError(err)
+				w.updateTitle()
+				return
+			}
+			w.lastError = ""
+			w.connected = true
+			w.updateStatus()
+			if reset {
+				w.mails = []*indexer.Mail{}
+				w.list.Clear()
+			}
+			w.mails = append(w.mails, res.Mails...)
+			w.totalHits = res.TotalHits
+			w.truncated = res.Truncated
+			w.hasMore = len(res.Mails) == searchPageSize
+			w.latency = time.Duration(res.ProcessingTimeMs) * time.Millisecond
+			for i, v := range res.Mails {
+				w.list.AddMessage(offset+i+1, v)
+			}
+			w.updateTitle()
+		})
+	}()
+}
+
+// updateTitle shows number of mails, sort order and search state in list title.
+func (w *Window) updateTitle() {
+	title := fmt.Sprintf("Mails %d", len(w.mails))
+	if w.hasMore && w.totalHits > len(w.mails) {
+		title = fmt.Sprintf("Mails %d of ~%d", len(w.mails), w.totalHits)
+	}
+	title += ", " + indexer.SortDescription(w.sort)
+	if w.truncated {
+		title += fmt.Sprintf(" (first %d by relevance)", w.totalHits)
+	}
+	if w.loading {
+		title += ", searching..."
+	} else {
+		title += fmt.Sprintf(", %d ms", w.latency.Milliseconds())
+	}
+	w.list.SetTitle(title)
+}
+
+// updateStatus shows connection state and last error in status bar.
+func (w *Window) updateStatus() {
+	state := "[green]Connected[-]"
+	if w.connecting {
+		state = "[yellow]Connecting[-]"
+	} else if !w.connected {
+		state = "[red]Disconnected[-]"
+	}
+	text := fmt.Sprintf("%s %s", state, cview.Escape(w.client.Url))
+	if w.lastError != "" {
+		text += fmt.Sprintf(" | [red]Error:[-] %s | F5: retry", cview.Escape(w.lastError))
+	}
+	if w.notice != "" {
+		text += " | " + w.notice
+	}
+	w.status.SetText(text)
+}
+
+// setRequestError shows error from meilisearch in status bar. Connection is marked as lost if server could
+// not be reached.
+func (w *Window) setRequestError(err error) {
+	if indexer.IsNetworkError(err) {
+		w.connected = false
+	}
+	w.lastError = indexer.ErrorMessage(err)
+	w.updateStatus()
+}
+
+// setNotice shows message about last action in status bar. Message may contain color tags.
+func (w *Window) setNotice(notice string) {
+	w.notice = notice
+	w.updateStatus()
+}
+
+// openInClient opens mail in mail client configured for mail's account. Terminal clients take over terminal
+// until they exit, other clients are started in background. Errors are shown in status bar.
+func (w *Window) openInClient(mail *indexer.Mail) {
+	conf := config.Conf.Opener(mail.Account)
+	target := external.OpenTarget{Id: mail.Id, Folder: mail.Folder}
+	if account := config.Conf.Account(mail.Account); account != nil {
+		target.Path = account.FolderPath(mail.Folder)
+	}
+
+	opener, err := external.NewOpener(conf.Client, conf.Command, conf.Terminal)
+	if err != nil {
+		w.showOpenError(conf.Client, err, nil)
+		return
+	}
+	cmd, err := opener.Command(target)
+	if err != nil {
+		w.showOpenError(conf.Client, err, nil)
+		return
+	}
+
+	if opener.Terminal() {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		w.app.Suspend(func() {
+			err = cmd.Run()
+		})
+		if err != nil {
+			w.showOpenError(conf.Client, err, nil)
+		} else {
+			w.setNotice("")
 		}
-		text += v
+		return
+	}
+
+	w.setNotice("Opening mail in " + cview.Escape(conf.Client))
+	go func() {
+		output, err := cmd.CombinedOutput()
+		w.app.QueueUpdateDraw(func() {
+			if err != nil {
+				w.showOpenError(conf.Client, err, output)
+			} else {
+				w.setNotice("")
+			}
+		})
+	}()
+}
+
+// showOpenError shows error from mail client in status bar. Output is the output of client, if any.
+func (w *Window) showOpenError(client string, err error, output []byte) {
+	logrus.Errorf("Open mail in %s: %v: %s", client, err, output)
+	message := err.Error()
+	// last line of output usually contains the error
+	if out := strings.TrimSpace(string(output)); out != "" {
+		lines := strings.Split(out, "\n")
+		message += ": " + lines[len(lines)-1]
+	}
+	w.setErrorNotice("Open mail in "+client, message)
+}
+
+// setErrorNotice shows error of action in status bar.
+func (w *Window) setErrorNotice(action, message string) {
+	w.setNotice(fmt.Sprintf("[red]%s:[-] %s", cview.Escape(action), cview.Escape(message)))
+}
+
+// compose creates reply or forward draft of mail and passes it to configured compose handler.
+func (w *Window) compose(mail *indexer.Mail, draftType string) {
+	conf := config.Conf.Compose
+	var from indexer.Address
+	if conf.From != "" {
+		address, err := netmail.ParseAddress(conf.From)
+		if err != nil {
+			w.setErrorNotice("Parse compose.from", err.Error())
+			return
+		}
+		from = indexer.Address{Name: address.Name, Address: address.Address}
+	}
+	draft, err := indexer.NewDraft(mail, draftType, from, time.Now())
+	if err != nil {
+		w.setErrorNotice("Create draft", err.Error())
+		return
+	}
+
+	switch conf.Handler {
+	case config.ComposeMailto:
+		w.setNotice("Opening draft in mail client")
+		go func() {
+			err := external.OpenURL(indexer.MailtoURL(draft))
+			w.app.QueueUpdateDraw(func() {
+				if err != nil {
+					w.setErrorNotice("Open mailto url with xdg-open", err.Error())
+				} else {
+					w.setNotice("")
+				}
+			})
+		}()
+	case config.ComposeEditor:
+		w.editDraft(draft, conf)
+	default:
+		w.setErrorNotice("Compose", fmt.Sprintf("unknown compose.handler '%s', expected %s or %s",
+			conf.Handler, config.ComposeMailto, config.ComposeEditor))
+	}
+}
+
+// editDraft opens draft in editor and sends it with sendmail after editor exits. Draft that was not changed
+// is not sent. If sending fails, draft file is kept.
+func (w *Window) editDraft(draft *indexer.Mail, conf config.Compose) {
+	// sendmail expects local line endings
+	message := bytes.ReplaceAll(indexer.MailToMessage(draft), []byte("\r\n"), []byte("\n"))
+	file, err := ioutil.TempFile("", "meilindex-draft-*.eml")
+	if err != nil {
+		w.setErrorNotice("Create draft", err.Error())
+		return
+	}
+	path := file.Name()
+	_, err = file.Write(message)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		w.setErrorNotice("Write draft", err.Error())
+		return
+	}
+
+	cmd := external.EditCommand(conf.Editor, path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	w.app.Suspend(func() {
+		err = cmd.Run()
+	})
+	if err != nil {
+		w.setErrorNotice("Edit draft", fmt.Sprintf("%v, draft is saved to %s", err, path))
+		return
+	}
+
+	edited, err := ioutil.ReadFile(path)
+	if err != nil {
+		w.setErrorNotice("Read draft", err.Error())
+		return
+	}
+	if bytes.Equal(edited, message) {
+		os.Remove(path)
+		w.setNotice("Draft was not changed, mail was not sent")
+		return
+	}
+
+	w.setNotice("Sending mail")
+	go func() {
+		err := external.Sendmail(conf.Sendmail, edited)
+		w.app.QueueUpdateDraw(func() {
+			if err != nil {
+				logrus.Errorf("Send mail: %v", err)
+				w.setErrorNotice("Send mail", fmt.Sprintf("%v, draft is saved to %s", err, path))
+				return
+			}
+			os.Remove(path)
+			w.setNotice("Mail sent")
+		})
+	}()
+}
+
+// retry reconnects to meilisearch if connection has failed, and runs current search again.
+func (w *Window) retry() {
+	if w.connecting {
+		return
+	}
+	if w.connected {
+		w.reload()
+		return
+	}
+	w.connect(true)
+}
+
+// connect connects a new meilisearch client in background, and on success replaces current client with it.
+// Current search is run again if reload is true or user has entered a search. Requests that are still
+// running keep using the old client.
+func (w *Window) connect(reload bool) {
+	// cancel running search, its results are replaced by search with new client.
+	w.newSearch()
+	w.connecting = true
+	w.updateStatus()
+	client := indexer.NewMeiliSearchClient()
+	go func() {
+		err := client.Connect()
+		w.app.QueueUpdateDraw(func() {
+			w.connecting = false
+			w.connected = err == nil
+			if err != nil {
+				logrus.Errorf("Connect to meilisearch: %v", err)
+				w.lastError = indexer.ErrorMessage(err)
+				w.updateStatus()
+				return
+			}
+			w.client = client
+			w.lastError = ""
+			w.updateStatus()
+			if reload || w.searchText != "" || w.searchFilter != "" {
+				w.reload()
+			}
+			go w.loadServerInfo(client)
+		})
+	}()
+}
+
+// loadServerInfo loads meilisearch stats and settings to help and settings pages. Must be called in background.
+func (w *Window) loadServerInfo(client *indexer.Meilisearch) {
+	stats := client.Stats()
+	settings := loadServerSettings(client)
+	w.app.QueueUpdateDraw(func() {
+		w.help.SetStats(stats)
+		w.settings.setServerSettings(settings)
+	})
+}
+
+// addHistory adds search to history and saves it.
+func (w *Window) addHistory(query, filter string) {
+	if w.history.Add(query, filter, time.Now()) {
+		err := w.history.Save()
+		if err != nil {
+			logrus.Errorf("Save search history: %v", err)
+		}
+	}
+}
+
+func (w *Window) openHistoryPicker() {
+	w.historyPicker.isOpen = true
+	w.historyPicker.Reset()
+	w.AddDynamicModal(w.historyPicker, twidgets.ModalSizeMedium)
+	w.app.SetFocus(w.historyPicker.input)
+}
+
+func (w *Window) closeHistoryPicker() {
+	w.historyPicker.isOpen = false
+	w.RemoveModal(w.historyPicker)
+}
+
+func (w *Window) showMessage(mail *indexer.Mail) {
+	// discard thread that is still loading
+	w.threadId = ""
+
+	text := "Account: " + mail.Account + "\n"
+	text += "Folder: " + mail.Folder + "\n"
+	if len(mail.Flags) > 0 {
+		text += "Flags: " + strings.Join(mail.Flags, ", ") + "\n"
+	}
+	text += "From: " + mail.HighlightedFrom()
+	if mail.FromAddress.Address != "" && mail.FromAddress.Name != "" {
+		text += " <" + mail.FromAddress.Address + ">"
 	}
 	text += "\n"
+	text += "To: " + formatAddresses(mail.To, mail.ToAddresses) + "\n"
+	text += "Cc: " + formatAddresses(mail.Cc, mail.CcAddresses) + "\n"
+	if len(mail.Bcc) > 0 {
+		text += "Bcc: " + formatAddresses(mail.Bcc, mail.BccAddresses) + "\n"
+	}
 
 	attachmentNames := ""
 	if len(mail.AttachmentNames) > 0 {
-		attachmentNames = fmt.Sprintf("}\nAttachments (%d): ", len(mail.AttachmentNames))
+		attachmentNames = fmt.Sprintf("\nAttachments (%d): ", len(mail.AttachmentNames))
 		for i, attachment := range mail.AttachmentNames {
 			if i > 0 {
 				attachmentNames += ", "
@@ -163,6 +647,128 @@ Subject: %s%s
 	w.preview.ScrollTo(0, 0)
 }
 
+// formatAddresses returns comma-separated addresses with names, or only names if addresses are not known.
+func formatAddresses(names []string, addresses []indexer.Address) string {
+	if len(addresses) == 0 {
+		return strings.Join(names, ", ")
+	}
+	out := make([]string, len(addresses))
+	for i, v := range addresses {
+		out[i] = v.String()
+	}
+	return strings.Join(out, ", ")
+}
+
+// openReader shows mail in preview and opens it in reader.
+func (w *Window) openReader(mail *indexer.Mail) {
+	w.readerIndex = 0
+	for i, v := range w.mails {
+		if v == mail {
+			w.readerIndex = i
+			break
+		}
+	}
+	w.showMessage(mail)
+	w.reader.SetMail(mail, w.readerIndex, w.totalHits)
+	if !w.reader.isOpen {
+		// opening a mail means search was useful. Browsing mails in reader does not change search.
+		w.addHistory(w.query.query.GetText(), w.query.filter.GetText())
+		w.reader.isOpen = true
+		w.AddDynamicModal(w.reader, twidgets.ModalSizeLarge)
+	}
+	w.app.SetFocus(w.reader)
+}
+
+// navigateReader shows next (step 1) or previous (step -1) mail from search results in reader.
+// More mails are loaded when reader gets near the end of loaded mails.
+func (w *Window) navigateReader(step int) {
+	index := w.readerIndex + step
+	if index < 0 || index >= len(w.mails) {
+		return
+	}
+	if index >= len(w.mails)-loadMoreThreshold {
+		w.loadMore()
+	}
+	w.readerIndex = index
+	mail := w.mails[index]
+	w.showMessage(mail)
+	w.reader.SetMail(mail, index, w.totalHits)
+}
+
+// closeReader closes reader and returns focus to mail list.
+func (w *Window) closeReader() {
+	w.reader.isOpen = false
+	w.RemoveModal(w.reader)
+	w.app.SetFocus(w.list)
+}
+
+// openAttachments opens attachment picker for mail.
+func (w *Window) openAttachments(mail *indexer.Mail) {
+	w.attachments.isOpen = true
+	w.attachments.SetMail(mail)
+	w.AddDynamicModal(w.attachments, twidgets.ModalSizeMedium)
+	w.app.SetFocus(w.attachments.list)
+}
+
+// closeAttachments closes attachment picker and returns focus to reader or mail list.
+func (w *Window) closeAttachments() {
+	w.attachments.isOpen = false
+	w.RemoveModal(w.attachments)
+	if w.reader.isOpen {
+		w.app.SetFocus(w.reader)
+	} else {
+		w.app.SetFocus(w.list)
+	}
+}
+
+// showThread shows all mails in the same thread as mail in preview, oldest first.
+// Thread is loaded in background, and errors are shown in status bar.
+func (w *Window) showThread(mail *indexer.Mail) {
+	if mail.ThreadId == "" {
+		w.showMessage(mail)
+		return
+	}
+	threadId := mail.ThreadId
+	w.threadId = threadId
+	w.preview.SetText("[yellow]Loading thread...[-]")
+	client := w.client
+	go func() {
+		mails, err := client.Thread(threadId)
+		w.app.QueueUpdateDraw(func() {
+			// another mail or thread has been selected meanwhile
+			if w.threadId != threadId {
+				return
+			}
+			if err != nil {
+				logrus.Errorf("get thread: %v", err)
+				w.preview.SetText("")
+				if indexer.IsNetworkError(err) {
+					w.connected = false
+				}
+				w.setErrorNotice("Get thread", indexer.ErrorMessage(err))
+				return
+			}
+			w.showThreadMails(mails)
+		})
+	}()
+}
+
+// showThreadMails shows mails of thread in preview.
+func (w *Window) showThreadMails(mails []*indexer.Mail) {
+	text := fmt.Sprintf("[yellow]Thread: %d mails[-]\n", len(mails))
+	for _, v := range mails {
+		text += fmt.Sprintf(`------------
+Date: %s
+From: %s
+Subject: %s
+
+%s
+`, v.DateTime(), v.HighlightedFrom(), v.HighlightedSubject(), v.HighlightedBody())
+	}
+	w.preview.SetText(text)
+	w.preview.ScrollTo(0, 0)
+}
+
 func (w *Window) inputCapture(event *tcell.EventKey) *tcell.EventKey {
 	key := event.Key()
 	if key == tcell.KeyTAB {
@@ -176,6 +782,11 @@ func (w *Window) inputCapture(event *tcell.EventKey) *tcell.EventKey {
 			nextFocus = w.query.filter
 		case w.query.filter:
 			nextFocus = w.list
+			if w.saved != nil {
+				nextFocus = w.saved
+			}
+		case w.saved:
+			nextFocus = w.list
 		case w.preview:
 			nextFocus = w.query
 		default:
@@ -186,8 +797,16 @@ func (w *Window) inputCapture(event *tcell.EventKey) *tcell.EventKey {
 		return nil
 	}
 
+	if key == tcell.KeyCtrlR {
+		if w.settings.isOpen || w.help.isOpen || w.historyPicker.isOpen || w.reader.isOpen || w.attachments.isOpen {
+			return event
+		}
+		w.openHistoryPicker()
+		return nil
+	}
+
 	if key == tcell.KeyF3 {
-		if w.settings.isOpen || w.help.isOpen {
+		if w.settings.isOpen || w.help.isOpen || w.historyPicker.isOpen || w.reader.isOpen || w.attachments.isOpen {
 			return event
 		} else {
 			w.settings.isOpen = true
@@ -196,19 +815,65 @@ func (w *Window) inputCapture(event *tcell.EventKey) *tcell.EventKey {
 		}
 	}
 
+	if key == tcell.KeyF5 {
+		w.retry()
+		return nil
+	}
+
+	if key == tcell.KeyRune && event.Rune() == 'a' && !w.attachments.isOpen {
+		if focus := w.app.GetFocus(); focus == w.reader {
+			w.openAttachments(w.reader.mail)
+			return nil
+		} else if focus == w.list {
+			index := w.list.GetSelectedIndex()
+			if index < len(w.list.shortMessages) {
+				w.openAttachments(w.list.shortMessages[index].mail)
+				return nil
+			}
+		}
+	}
+
+	if key == tcell.KeyRune && (event.Rune() == 'r' || event.Rune() == 'R' || event.Rune() == 'f') {
+		draftType := map[rune]string{'r': indexer.DraftReply, 'R': indexer.DraftReplyAll, 'f': indexer.DraftForward}
+		if focus := w.app.GetFocus(); focus == w.reader {
+			w.compose(w.reader.mail, draftType[event.Rune()])
+			return nil
+		} else if focus == w.list {
+			index := w.list.GetSelectedIndex()
+			if index < len(w.list.shortMessages) {
+				w.compose(w.list.shortMessages[index].mail, draftType[event.Rune()])
+				return nil
+			}
+		}
+	}
+
+	if key == tcell.KeyRune && event.Rune() == 's' && w.app.GetFocus() == w.list {
+		w.toggleSort()
+		return nil
+	}
+
+	if key == tcell.KeyRune && event.Rune() == 't' && w.app.GetFocus() == w.list {
+		index := w.list.GetSelectedIndex()
+		if index < len(w.list.shortMessages) {
+			w.showThread(w.list.shortMessages[index].mail)
+			return nil
+		}
+	}
+
 	if key == tcell.KeyF2 {
+		if w.reader.isOpen {
+			w.openInClient(w.reader.mail)
+			return nil
+		}
 		index := w.list.GetSelectedIndex()
 		if index < len(w.list.shortMessages) {
-			mail := w.list.shortMessages[index].mail
-			err := external.OpenById(mail.Id)
-			if err != nil {
-				logrus.Errorf("Open mail in external application: %v", err)
-			}
+			w.openInClient(w.list.shortMessages[index].mail)
+			return nil
 		}
 	}
 
 	if key == tcell.KeyF1 {
-		if w.help.isOpen || w.settings.isOpen {
+		if w.help.isOpen || w.settings.isOpen || w.historyPicker.isOpen || w.reader.isOpen || w.attachments.isOpen {
 			return event
 		} else {
 			w.help.isOpen = true
@@ -226,6 +891,13 @@ func (w *Window) inputCapture(event *tcell.EventKey) *tcell.EventKey {
 			w.settings.isOpen = false
 			w.RemoveModal(w.settings)
 			w.app.SetFocus(w.query)
+		} else if w.historyPicker.isOpen {
+			w.closeHistoryPicker()
+			w.app.SetFocus(w.query.query)
+		} else if w.attachments.isOpen {
+			w.closeAttachments()
+		} else if w.reader.isOpen {
+			w.closeReader()
 		}
 	}
 